@@ -0,0 +1,413 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/xoom/jenkins"
+	"gopkg.in/yaml.v2"
+
+	"github.com/xoom/stashkins/stashkins"
+)
+
+// Settings are resolved from these sources, each overriding the ones before it:
+//
+//  1. flag defaults
+//  2. the YAML configuration file named by -config or STASHKINS_CONFIG
+//  3. environment variables named STASHKINS_ followed by the flag name upper cased, with - replaced by _
+//  4. flags given on the command line
+//
+// Top level keys in the configuration file are flag names.  The projects section holds per-project overrides, keyed by
+// project key or by project-key/slug, which apply on top of the resolved settings for matching repositories.  A
+// project-key/slug override takes precedence over a project key override.  An example:
+//
+//	stash-rest-base-url: https://stash.example.com
+//	managed-branch-prefixes: [feature/, hotfix/]
+//	projects:
+//	  PLATFORM:
+//	    archive-obsolete-jobs: true
+//	  PLATFORM/legacy:
+//	    enabled: false
+const environmentPrefix = "STASHKINS_"
+
+// overridableSettings are the settings that may be given per project in the configuration file.
+var overridableSettings = map[string]bool{
+	"enabled":                       true,
+	"managed-branch-prefixes":       true,
+	"mainline-branches":             true,
+	"branch-rules":                  true,
+	"stale-branch-days":             true,
+	"job-name-prefix":               true,
+	"parameters":                    true,
+	"aspect":                        true,
+	"maven-repo-repository-groupID": true,
+	"repair-drift":                  true,
+	"archive-obsolete-jobs":         true,
+	"archive-retention-days":        true,
+	"scm-provider":                  true,
+	"scm-base-url":                  true,
+	"scm-username":                  true,
+	"scm-password":                  true,
+	"tag-pattern":                   true,
+	"tag-jobs-keep":                 true,
+}
+
+// settingsFileSettings are the settings that may be given in a repository's stashkins.yaml.  Anyone who can change the
+// template repository can change the file, so it cannot choose the SCM provider or the credentials sent to it.
+var settingsFileSettings = map[string]bool{
+	"enabled":                       true,
+	"managed-branch-prefixes":       true,
+	"mainline-branches":             true,
+	"branch-rules":                  true,
+	"stale-branch-days":             true,
+	"job-name-prefix":               true,
+	"parameters":                    true,
+	"aspect":                        true,
+	"maven-repo-repository-groupID": true,
+	"repair-drift":                  true,
+	"archive-obsolete-jobs":         true,
+	"archive-retention-days":        true,
+	"tag-pattern":                   true,
+	"tag-jobs-keep":                 true,
+}
+
+type configFile struct {
+	Settings map[string]interface{}            `yaml:",inline"`
+	Projects map[string]map[string]interface{} `yaml:"projects"`
+}
+
+// projectOverrides holds the per-project overrides from the configuration file, keyed by lower cased project key or
+// project-key/slug.
+var projectOverrides = make(map[string]map[string]string)
+
+// loadConfiguration layers the configuration file and environment beneath the command line flags already parsed into fs.
+// Values are set on the flags directly so that fs.Visit continues to report only the flags given on the command line.
+func loadConfiguration(fs *flag.FlagSet, getenv func(string) string) error {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	// The configuration file location itself may come from the environment.
+	configFileName := fs.Lookup("config").Value.String()
+	if !explicit["config"] && getenv(environmentVariable("config")) != "" {
+		configFileName = getenv(environmentVariable("config"))
+	}
+
+	if configFileName != "" {
+		data, err := ioutil.ReadFile(configFileName)
+		if err != nil {
+			return err
+		}
+		var config configFile
+		if err := yaml.Unmarshal(data, &config); err != nil {
+			return fmt.Errorf("cannot parse configuration file %s: %v", configFileName, err)
+		}
+
+		for _, name := range sortedKeys(config.Settings) {
+			if fs.Lookup(name) == nil || name == "config" {
+				return fmt.Errorf("unknown setting %s in configuration file %s", name, configFileName)
+			}
+			if explicit[name] {
+				continue
+			}
+			if err := fs.Lookup(name).Value.Set(settingValue(config.Settings[name])); err != nil {
+				return fmt.Errorf("invalid value for %s in configuration file %s: %v", name, configFileName, err)
+			}
+		}
+
+		for project, settings := range config.Projects {
+			overrides := make(map[string]string)
+			for name, value := range settings {
+				if !overridableSettings[name] {
+					return fmt.Errorf("setting %s cannot be overridden for project %s in configuration file %s", name, project, configFileName)
+				}
+				overrides[name] = settingValue(value)
+			}
+			projectOverrides[strings.ToLower(project)] = overrides
+		}
+	}
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || explicit[f.Name] || f.Name == "config" {
+			return
+		}
+		if value := getenv(environmentVariable(f.Name)); value != "" {
+			if e := f.Value.Set(value); e != nil {
+				err = fmt.Errorf("invalid value for environment variable %s: %v", environmentVariable(f.Name), e)
+			}
+		}
+	})
+	return err
+}
+
+// reloadConfiguration resets every flag not given on the command line to its default and loads the configuration file and
+// environment again.  validate is then called to vet the result.  If loading or validation fails, the previous flag
+// values and project overrides are restored.
+func reloadConfiguration(fs *flag.FlagSet, getenv func(string) string, validate func() error) error {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	saved := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		saved[f.Name] = f.Value.String()
+		if !explicit[f.Name] {
+			f.Value.Set(f.DefValue)
+		}
+	})
+	savedOverrides := projectOverrides
+	projectOverrides = make(map[string]map[string]string)
+
+	err := loadConfiguration(fs, getenv)
+	if err == nil {
+		err = validate()
+	}
+	if err != nil {
+		for name, value := range saved {
+			fs.Lookup(name).Value.Set(value)
+		}
+		projectOverrides = savedOverrides
+	}
+	return err
+}
+
+func environmentVariable(flagName string) string {
+	return environmentPrefix + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// settingValue renders a YAML value as a flag value.  Lists become comma separated strings.
+func settingValue(v interface{}) string {
+	if list, ok := v.([]interface{}); ok {
+		values := make([]string, 0, len(list))
+		for _, item := range list {
+			values = append(values, fmt.Sprint(item))
+		}
+		return strings.Join(values, ",")
+	}
+	return fmt.Sprint(v)
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// repositorySettings are the settings that may differ from one repository to the next.
+type repositorySettings struct {
+	enabled                bool
+	managedBranchPrefixes  string
+	mainlineBranches       []stashkins.MainlineBranch
+	branchRules            []stashkins.BranchRule
+	staleBranchDays        int
+	jobNamePrefix          string
+	parameters             map[string]string
+	aspect                 string
+	mavenRepositoryGroupID string
+	repairDrift            bool
+	archiveObsoleteJobs    bool
+	archiveRetentionDays   int
+	scmProvider            string
+	scmBaseURL             string
+	scmUserName            string // a credential reference
+	scmPassword            string // a credential reference
+	tagPattern             string
+	tagJobsKeep            int
+}
+
+// Aspects a repository may choose in place of the one its template's job type implies.
+const (
+	aspectMaven     = "maven"
+	aspectFreestyle = "freestyle"
+)
+
+// jobType is the job type determining the repository's aspect, which is the template's unless overridden.
+func (s repositorySettings) jobType(jobTemplate stashkins.JobTemplate) jenkins.JobType {
+	switch s.aspect {
+	case aspectMaven:
+		return jenkins.Maven
+	case aspectFreestyle:
+		return jenkins.Freestyle
+	}
+	return jobTemplate.JobType
+}
+
+// scmKey identifies the SCM provider, and the login with it, with which the repository is reconciled.
+func (s repositorySettings) scmKey() string {
+	return strings.Join([]string{s.scmProvider, s.scmBaseURL, s.scmUserName, s.scmPassword}, " ")
+}
+
+// settingsFor returns the resolved settings for a repository, with the settings from its stashkins.yaml and then any
+// project and project/slug overrides applied.
+func settingsFor(jobTemplate stashkins.JobTemplate) (repositorySettings, error) {
+	settings := repositorySettings{
+		enabled:                true,
+		managedBranchPrefixes:  *managedBranchPrefixes,
+		staleBranchDays:        *staleBranchDays,
+		mavenRepositoryGroupID: *mavenRepositoryGroupID,
+		repairDrift:            *repairDrift,
+		archiveObsoleteJobs:    *archiveObsoleteJobs,
+		archiveRetentionDays:   *archiveRetentionDays,
+		scmProvider:            *scmProvider,
+		scmBaseURL:             *scmBaseURL,
+		scmUserName:            *scmUserName,
+		scmPassword:            *scmPassword,
+		tagPattern:             *tagPattern,
+		tagJobsKeep:            *tagJobsKeep,
+	}
+
+	var err error
+	if settings.mainlineBranches, err = stashkins.ParseMainlineBranches(*mainlineBranches); err != nil {
+		return repositorySettings{}, err
+	}
+	if settings.branchRules, err = stashkins.ParseBranchRules(*branchRules); err != nil {
+		return repositorySettings{}, err
+	}
+	if settings.parameters, err = stashkins.ParseParameters(*parameters); err != nil {
+		return repositorySettings{}, err
+	}
+
+	// The repository's own settings file comes first, so that the configuration file keeps the last word.
+	if jobTemplate.SettingsError != nil {
+		return repositorySettings{}, settingsFileError{jobTemplate.SettingsError}
+	}
+	for _, name := range sortedSettingNames(jobTemplate.Settings) {
+		if !settingsFileSettings[name] {
+			return repositorySettings{}, settingsFileError{fmt.Errorf("setting %s cannot be given in %s/%s/stashkins.yaml", name, jobTemplate.ProjectKey, jobTemplate.Slug)}
+		}
+		if err := settings.set(name, jobTemplate.Settings[name]); err != nil {
+			return repositorySettings{}, settingsFileError{fmt.Errorf("invalid value %s for %s in %s/%s/stashkins.yaml: %v", jobTemplate.Settings[name], name, jobTemplate.ProjectKey, jobTemplate.Slug, err)}
+		}
+	}
+
+	projectKey := strings.ToLower(jobTemplate.ProjectKey)
+	for _, key := range []string{projectKey, projectKey + "/" + strings.ToLower(jobTemplate.Slug)} {
+		for name, value := range projectOverrides[key] {
+			if err := settings.set(name, value); err != nil {
+				return repositorySettings{}, fmt.Errorf("invalid value %s for %s in project override %s: %v", value, name, key, err)
+			}
+		}
+	}
+	return settings, nil
+}
+
+// jobNamePrefixCollisions returns why each repository whose job names would begin with the same prefix as another's, its
+// job-name-prefix or the default project-key-slug, must be skipped, keyed by project-key/slug.  Jenkins job names ignore
+// case, so neither does the comparison.  Such repositories' jobs cannot be told apart, so every one of them is skipped.
+func jobNamePrefixCollisions(jobTemplates []stashkins.JobTemplate) map[string]error {
+	repositories := make(map[string][]string)
+	for _, jobTemplate := range jobTemplates {
+		prefix := jobTemplate.ProjectKey + "-" + jobTemplate.Slug
+		if settings, err := settingsFor(jobTemplate); err == nil && settings.jobNamePrefix != "" {
+			prefix = settings.jobNamePrefix
+		}
+		prefix = strings.ToLower(prefix)
+		repositories[prefix] = append(repositories[prefix], jobTemplate.ProjectKey+"/"+jobTemplate.Slug)
+	}
+
+	collisions := make(map[string]error)
+	for prefix, sharing := range repositories {
+		if len(sharing) < 2 {
+			continue
+		}
+		sort.Strings(sharing)
+		for _, repository := range sharing {
+			collisions[repository] = fmt.Errorf("job name prefix %s is shared by %s", prefix, strings.Join(sharing, ", "))
+		}
+	}
+	return collisions
+}
+
+// set sets the named overridable setting from its string form.
+func (s *repositorySettings) set(name, value string) error {
+	var err error
+	switch name {
+	case "enabled":
+		s.enabled, err = strconv.ParseBool(value)
+	case "managed-branch-prefixes":
+		s.managedBranchPrefixes = value
+	case "mainline-branches":
+		s.mainlineBranches, err = stashkins.ParseMainlineBranches(value)
+	case "branch-rules":
+		s.branchRules, err = stashkins.ParseBranchRules(value)
+	case "stale-branch-days":
+		s.staleBranchDays, err = strconv.Atoi(value)
+		if err == nil && s.staleBranchDays < 0 {
+			err = errors.New("must not be negative")
+		}
+	case "job-name-prefix":
+		s.jobNamePrefix = value
+	case "parameters":
+		// Parameters are merged, so an override need give only those it changes.
+		var overrides map[string]string
+		if overrides, err = stashkins.ParseParameters(value); err == nil {
+			merged := make(map[string]string, len(s.parameters)+len(overrides))
+			for name, v := range s.parameters {
+				merged[name] = v
+			}
+			for name, v := range overrides {
+				merged[name] = v
+			}
+			s.parameters = merged
+		}
+	case "aspect":
+		if value != "" && value != aspectMaven && value != aspectFreestyle {
+			err = fmt.Errorf("must be %s or %s", aspectMaven, aspectFreestyle)
+		}
+		s.aspect = value
+	case "maven-repo-repository-groupID":
+		s.mavenRepositoryGroupID = value
+	case "repair-drift":
+		s.repairDrift, err = strconv.ParseBool(value)
+	case "archive-obsolete-jobs":
+		s.archiveObsoleteJobs, err = strconv.ParseBool(value)
+	case "archive-retention-days":
+		s.archiveRetentionDays, err = strconv.Atoi(value)
+		if err == nil && s.archiveRetentionDays < 0 {
+			err = errors.New("must not be negative")
+		}
+	case "scm-provider":
+		s.scmProvider = value
+	case "scm-base-url":
+		s.scmBaseURL = value
+	case "scm-username":
+		s.scmUserName = value
+	case "scm-password":
+		s.scmPassword = value
+	case "tag-pattern":
+		s.tagPattern = value
+		err = stashkins.TagPolicy{Pattern: value}.Validate()
+	case "tag-jobs-keep":
+		s.tagJobsKeep, err = strconv.Atoi(value)
+		if err == nil && s.tagJobsKeep < 0 {
+			err = errors.New("must not be negative")
+		}
+	}
+	return err
+}
+
+// A settingsFileError is an invalid setting in a repository's stashkins.yaml, or a file that cannot be read or parsed.  It
+// disables only that repository.
+type settingsFileError struct {
+	error
+}
+
+func sortedSettingNames(m map[string]string) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
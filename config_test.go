@@ -0,0 +1,259 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/xoom/jenkins"
+	"github.com/xoom/stashkins/stashkins"
+)
+
+func testFlagSet() (*flag.FlagSet, *string, *string, *string, *int) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("config", "", "")
+	url := fs.String("stash-rest-base-url", "http://default", "")
+	user := fs.String("username", "", "")
+	prefixes := fs.String("managed-branch-prefixes", "feature/", "")
+	workers := fs.Int("workers", 1, "")
+	return fs, url, user, prefixes, workers
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "stashkins-config-")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	return f.Name()
+}
+
+func TestLoadConfigurationPrecedence(t *testing.T) {
+	fileName := writeConfigFile(t, `
+stash-rest-base-url: http://file
+username: file-user
+managed-branch-prefixes: [feature/, hotfix/]
+workers: 4
+`)
+	defer os.Remove(fileName)
+
+	fs, url, user, prefixes, workers := testFlagSet()
+	if err := fs.Parse([]string{"-config", fileName, "-stash-rest-base-url", "http://flag"}); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	env := map[string]string{
+		"STASHKINS_STASH_REST_BASE_URL": "http://env",
+		"STASHKINS_USERNAME":            "env-user",
+	}
+	if err := loadConfiguration(fs, func(k string) string { return env[k] }); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	if *url != "http://flag" {
+		t.Fatalf("Want http://flag but got %s\n", *url)
+	}
+	if *user != "env-user" {
+		t.Fatalf("Want env-user but got %s\n", *user)
+	}
+	if *prefixes != "feature/,hotfix/" {
+		t.Fatalf("Want feature/,hotfix/ but got %s\n", *prefixes)
+	}
+	if *workers != 4 {
+		t.Fatalf("Want 4 but got %d\n", *workers)
+	}
+}
+
+func TestLoadConfigurationFileFromEnvironment(t *testing.T) {
+	fileName := writeConfigFile(t, "username: file-user\n")
+	defer os.Remove(fileName)
+
+	fs, _, user, _, _ := testFlagSet()
+	fs.Parse([]string{})
+	env := map[string]string{"STASHKINS_CONFIG": fileName}
+	if err := loadConfiguration(fs, func(k string) string { return env[k] }); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if *user != "file-user" {
+		t.Fatalf("Want file-user but got %s\n", *user)
+	}
+}
+
+func TestLoadConfigurationRejectsUnknownSettings(t *testing.T) {
+	for _, content := range []string{
+		"no-such-flag: 1\n",
+		"workers: many\n",
+		"projects:\n  PROJ:\n    username: x\n",
+	} {
+		fileName := writeConfigFile(t, content)
+		fs, _, _, _, _ := testFlagSet()
+		fs.Parse([]string{"-config", fileName})
+		if err := loadConfiguration(fs, func(string) string { return "" }); err == nil {
+			t.Fatalf("Want an error for %q\n", content)
+		}
+		os.Remove(fileName)
+	}
+}
+
+func TestSettingsForProjectOverrides(t *testing.T) {
+	defer func(saved map[string]map[string]string) { projectOverrides = saved }(projectOverrides)
+	projectOverrides = map[string]map[string]string{
+		"proj":        {"managed-branch-prefixes": "bug/", "archive-retention-days": "7"},
+		"proj/legacy": {"enabled": "false", "archive-retention-days": "30"},
+	}
+
+	settings, err := settingsFor(stashkins.JobTemplate{ProjectKey: "proj", Slug: "app"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if !settings.enabled || settings.managedBranchPrefixes != "bug/" || settings.archiveRetentionDays != 7 {
+		t.Fatalf("Want enabled, bug/ and 7 but got %+v\n", settings)
+	}
+
+	settings, err = settingsFor(stashkins.JobTemplate{ProjectKey: "proj", Slug: "legacy"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if settings.enabled || settings.managedBranchPrefixes != "bug/" || settings.archiveRetentionDays != 30 {
+		t.Fatalf("Want disabled, bug/ and 30 but got %+v\n", settings)
+	}
+
+	settings, err = settingsFor(stashkins.JobTemplate{ProjectKey: "other", Slug: "app"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if settings.managedBranchPrefixes != *managedBranchPrefixes {
+		t.Fatalf("Want %s but got %s\n", *managedBranchPrefixes, settings.managedBranchPrefixes)
+	}
+
+	projectOverrides = map[string]map[string]string{"proj": {"repair-drift": "sometimes"}}
+	if _, err := settingsFor(stashkins.JobTemplate{ProjectKey: "proj", Slug: "app"}); err == nil {
+		t.Fatalf("Want an error\n")
+	}
+}
+
+func TestSettingsForSettingsFile(t *testing.T) {
+	defer func(saved map[string]map[string]string) { projectOverrides = saved }(projectOverrides)
+	projectOverrides = map[string]map[string]string{
+		"proj/app": {"archive-retention-days": "30", "parameters": "notify=ops@example.com"},
+	}
+
+	jobTemplate := stashkins.JobTemplate{ProjectKey: "proj", Slug: "app", JobType: jenkins.Maven, Settings: map[string]string{
+		"archive-retention-days": "7",
+		"parameters":             "team=app,notify=app@example.com",
+		"job-name-prefix":        "app",
+		"aspect":                 "freestyle",
+	}}
+	settings, err := settingsFor(jobTemplate)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if settings.archiveRetentionDays != 30 || settings.jobNamePrefix != "app" || settings.jobType(jobTemplate) != jenkins.Freestyle {
+		t.Fatalf("Want 30, app and freestyle but got %+v\n", settings)
+	}
+	if settings.parameters["team"] != "app" || settings.parameters["notify"] != "ops@example.com" {
+		t.Fatalf("Want team=app and notify=ops@example.com but got %v\n", settings.parameters)
+	}
+
+	jobTemplate.SettingsError = errors.New("cannot parse settings file")
+	if _, err := settingsFor(jobTemplate); err == nil {
+		t.Fatalf("Want a settings file error for an unparseable file\n")
+	} else if _, ok := err.(settingsFileError); !ok {
+		t.Fatalf("Want a settings file error but got %v\n", err)
+	}
+	jobTemplate.SettingsError = nil
+
+	for _, fileSettings := range []map[string]string{{"aspect": "ant"}, {"username": "team"}, {"scm-base-url": "https://scm.example.com"}, {"scm-password": "env:TOKEN"}} {
+		jobTemplate.Settings = fileSettings
+		_, err := settingsFor(jobTemplate)
+		if _, ok := err.(settingsFileError); !ok {
+			t.Fatalf("Want a settings file error for %v but got %v\n", fileSettings, err)
+		}
+	}
+}
+
+func TestJobNamePrefixCollisions(t *testing.T) {
+	defer func(saved map[string]map[string]string) { projectOverrides = saved }(projectOverrides)
+	projectOverrides = map[string]map[string]string{"proj/c": {"job-name-prefix": "shared"}}
+
+	jobTemplates := []stashkins.JobTemplate{
+		stashkins.JobTemplate{ProjectKey: "proj", Slug: "a", Settings: map[string]string{"job-name-prefix": "PROJ-b"}},
+		stashkins.JobTemplate{ProjectKey: "proj", Slug: "b"},
+		stashkins.JobTemplate{ProjectKey: "proj", Slug: "c"},
+		stashkins.JobTemplate{ProjectKey: "proj", Slug: "d", Settings: map[string]string{"job-name-prefix": "shared"}},
+		stashkins.JobTemplate{ProjectKey: "proj", Slug: "e", Settings: map[string]string{"job-name-prefix": "e"}},
+		stashkins.JobTemplate{ProjectKey: "proj", Slug: "f"},
+	}
+	collisions := jobNamePrefixCollisions(jobTemplates)
+	for _, key := range []string{"proj/a", "proj/b", "proj/c", "proj/d"} {
+		if collisions[key] == nil {
+			t.Fatalf("Want %s skipped but got %v\n", key, collisions)
+		}
+	}
+	if len(collisions) != 4 {
+		t.Fatalf("Want 4 collisions but got %v\n", collisions)
+	}
+}
+
+func TestReloadConfiguration(t *testing.T) {
+	defer func(saved map[string]map[string]string) { projectOverrides = saved }(projectOverrides)
+
+	fileName := writeConfigFile(t, "username: first\nworkers: 2\nprojects:\n  PROJ:\n    enabled: false\n")
+	defer os.Remove(fileName)
+
+	fs, url, user, _, workers := testFlagSet()
+	fs.Parse([]string{"-config", fileName, "-stash-rest-base-url", "http://flag"})
+	if err := loadConfiguration(fs, func(string) string { return "" }); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	ioutil.WriteFile(fileName, []byte("username: second\n"), 0600)
+	if err := reloadConfiguration(fs, func(string) string { return "" }, func() error { return nil }); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if *user != "second" || *workers != 1 || *url != "http://flag" {
+		t.Fatalf("Want second, 1 and http://flag but got %s, %d and %s\n", *user, *workers, *url)
+	}
+	if len(projectOverrides) != 0 {
+		t.Fatalf("Want no project overrides but got %+v\n", projectOverrides)
+	}
+
+	ioutil.WriteFile(fileName, []byte("username: third\nworkers: 8\n"), 0600)
+	if err := reloadConfiguration(fs, func(string) string { return "" }, func() error { return errors.New("invalid") }); err == nil {
+		t.Fatalf("Want an error\n")
+	}
+	if *user != "second" || *workers != 1 {
+		t.Fatalf("Want second and 1 restored but got %s and %d\n", *user, *workers)
+	}
+}
+
+func TestSCMParams(t *testing.T) {
+	defer func(saved stashkins.WebClientParams) { stashParams = saved }(stashParams)
+	stashParams = stashkins.WebClientParams{URL: "http://stash", UserName: "stash-user", Password: "stash-password"}
+	os.Setenv("STASHKINS_TEST_GITHUB_TOKEN", "token")
+	defer os.Unsetenv("STASHKINS_TEST_GITHUB_TOKEN")
+
+	params, err := scmParams(repositorySettings{scmProvider: stashkins.SCMStash})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if params.URL != *stashBaseURL || params.UserName != "stash-user" || params.Password != "stash-password" {
+		t.Fatalf("Want the Stash login but got %+v\n", params)
+	}
+
+	params, err = scmParams(repositorySettings{scmProvider: stashkins.SCMGitHub, scmBaseURL: "https://api.github.com", scmPassword: "env:STASHKINS_TEST_GITHUB_TOKEN"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if params.URL != "https://api.github.com" || params.UserName != "" || params.Password != "token" {
+		t.Fatalf("Want the GitHub token alone but got %+v\n", params)
+	}
+
+	if _, err := scmParams(repositorySettings{scmPassword: "env:STASHKINS_TEST_NO_SUCH_VARIABLE"}); err == nil {
+		t.Fatalf("Want an error\n")
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/xoom/stashkins/stashkins"
+)
+
+// runDaemon reconciles every interval until SIGINT or SIGTERM.  The template clone in templateCloneDirectory is kept
+// between reconciliations and pulled rather than cloned again.  SIGHUP reloads configuration before the next
+// reconciliation.  A signal to shut down during a reconciliation lets the repositories in progress finish and skips the rest.
+func runDaemon(templateCloneDirectory string) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	stop := make(chan struct{})
+	reloads := make(chan struct{}, 1)
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGHUP {
+				select {
+				case reloads <- struct{}{}:
+				default:
+				}
+				continue
+			}
+			Log.WithField("signal", sig).Info("Shutting down")
+			close(stop)
+			return
+		}
+	}()
+
+	for _, server := range servers(templateCloneDirectory, stop) {
+		defer server.Close()
+	}
+
+	for {
+		reconcile(templateCloneDirectory, stop, nil)
+
+		Log.WithField("interval", *interval).Info("Waiting for next reconciliation")
+		timer := time.NewTimer(*interval)
+		for waiting := true; waiting; {
+			select {
+			case <-stop:
+				timer.Stop()
+				// Let a webhook reconciliation in progress finish.
+				reconcileMutex.Lock()
+				reconcileMutex.Unlock()
+				return
+			case <-reloads:
+				reload(templateCloneDirectory)
+			case <-timer.C:
+				waiting = false
+			}
+		}
+	}
+}
+
+// reload re-reads the configuration file, environment and credentials, and reconfigures logging.  If the new configuration is unusable the previous
+// one is kept.  A change of template source, repository, branch or ref discards the template clone so the next
+// reconciliation clones afresh.  Webhook reconciliations run on their own goroutines, so reload waits for any in progress
+// and holds off new ones until the settings are consistent again.
+func reload(templateCloneDirectory string) {
+	reconcileMutex.Lock()
+	defer reconcileMutex.Unlock()
+
+	templateSource := templateSourceKey()
+	savedStashParams, savedJenkinsParams, savedNexusParams := stashParams, jenkinsParams, nexusParams
+
+	err := reloadConfiguration(flag.CommandLine, os.Getenv, func() error {
+		configureClients()
+		if err := resolveCredentials(); err != nil {
+			return err
+		}
+		if err := validateCommandLineArguments(); err != nil {
+			return err
+		}
+		return stashkins.ConfigureLogging(*logLevel, *logFormat)
+	})
+	if err != nil {
+		stashParams, jenkinsParams, nexusParams = savedStashParams, savedJenkinsParams, savedNexusParams
+		Log.WithError(err).Error("Cannot reload configuration, keeping the previous configuration")
+		return
+	}
+
+	if templateSourceKey() != templateSource {
+		if err := os.RemoveAll(templateCloneDirectory); err != nil {
+			Log.WithError(err).WithField("directory", templateCloneDirectory).Error("Cannot remove template clone")
+		}
+	}
+	Log.Info("Reloaded configuration")
+}
+
+// servers starts listeners for webhooks and metrics.  Webhooks are served at /webhook and metrics at /metrics, on one
+// listener if their addresses are the same.  The listeners, webhook secret and debounce period are not changed by
+// reloading configuration.
+func servers(templateCloneDirectory string, stop <-chan struct{}) []*http.Server {
+	muxes := make(map[string]*http.ServeMux)
+	mux := func(address string) *http.ServeMux {
+		if _, present := muxes[address]; !present {
+			muxes[address] = http.NewServeMux()
+		}
+		return muxes[address]
+	}
+
+	if *webhookAddress != "" {
+		if webhookSecretValue == "" {
+			Log.Warn("webhook-secret is not set, so webhooks are not verified")
+		}
+
+		receiver := stashkins.NewWebhookReceiver(webhookSecretValue, *webhookDebounce, func(projectKey, slug string) {
+			reconcile(templateCloneDirectory, stop, func(jobTemplate stashkins.JobTemplate) bool {
+				return strings.EqualFold(jobTemplate.ProjectKey, projectKey) && strings.EqualFold(jobTemplate.Slug, slug)
+			})
+		})
+		go func() {
+			<-stop
+			receiver.Close()
+		}()
+		mux(*webhookAddress).Handle("/webhook", receiver)
+	}
+
+	if *metricsAddress != "" {
+		mux(*metricsAddress).Handle("/metrics", metrics.Handler())
+	}
+
+	servers := make([]*http.Server, 0, len(muxes))
+	for address, handler := range muxes {
+		server := &http.Server{Addr: address, Handler: handler}
+		go func(server *http.Server) {
+			Log.WithField("address", server.Addr).Info("Listening")
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				Log.WithError(err).WithField("address", server.Addr).Error("Listener failed")
+			}
+		}(server)
+		servers = append(servers, server)
+	}
+	return servers
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/xoom/stashkins/stashkins"
+)
+
+// lint checks the templates in the template repository, or in a local checkout of it, printing a line for each problem
+// found.  It returns the exit status:  0 if there are no problems, 1 if there are, and 2 if the templates cannot be checked.
+func lint(args []string) int {
+	fs := flag.NewFlagSet("lint", flag.ContinueOnError)
+	dir := fs.String("dir", "", "Local checkout of the template repository.  If omitted, templates come from the configured template source.")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	templateDirectory := *dir
+	if templateDirectory == "" {
+		source, err := templateSource()
+		if err != nil {
+			Log.WithError(err).Error("Either lint -dir or a template source is required")
+			return 2
+		}
+		cloneDirectory, err := ioutil.TempDir("", "stashkins-templates-")
+		if err != nil {
+			Log.WithError(err).Error("Cannot create template clone directory")
+			return 2
+		}
+		defer os.RemoveAll(cloneDirectory)
+		if templateDirectory, err = source.Fetch(cloneDirectory); err != nil {
+			Log.WithError(err).Error("Cannot fetch job templates")
+			return 2
+		}
+	}
+
+	// Settings are resolved as reconciliation resolves them, and an invalid settings file is itself a problem.
+	problems := make([]string, 0)
+	settings := make(map[string]repositorySettings)
+	setup := func(jobTemplate stashkins.JobTemplate) (stashkins.DefaultStashkins, stashkins.Aspect) {
+		key := jobTemplate.ProjectKey + "/" + jobTemplate.Slug
+		repositorySettings, present := settings[key]
+		if !present {
+			var err error
+			repositorySettings, err = settingsFor(jobTemplate)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", key, err))
+				jobTemplate.Settings = nil
+				repositorySettings, _ = settingsFor(jobTemplate)
+			}
+			settings[key] = repositorySettings
+		}
+		return repositorySetup(stashkins.DefaultStashkins{}, repositorySettings, nil, jobTemplate)
+	}
+
+	lintProblems, err := stashkins.LintTemplates(templateDirectory, setup)
+	if err != nil {
+		Log.WithError(err).WithField("directory", templateDirectory).Error("Cannot lint job templates")
+		return 2
+	}
+	for _, problem := range lintProblems {
+		problems = append(problems, problem.String())
+	}
+
+	if len(problems) > 0 {
+		fmt.Println(strings.Join(problems, "\n"))
+		Log.WithField("problems", len(problems)).Error("Job templates have problems")
+		return 1
+	}
+	Log.Info("Job templates have no problems")
+	return 0
+}
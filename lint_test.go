@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "templates-")
+	if err != nil {
+		t.Fatalf("Unexpected error creating temp dir: %v\n", err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name, content string) {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatalf("Unexpected error: %v\n", err)
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatalf("Unexpected error: %v\n", err)
+		}
+	}
+
+	write("proj/app/continuous-template.xml", `<project><description>{{xml .Description}}</description></project>`)
+	if status := lint([]string{"-dir", dir}); status != 0 {
+		t.Fatalf("Want 0 but got %d\n", status)
+	}
+
+	write("proj/app/stashkins.yaml", "aspect: maven\n")
+	if status := lint([]string{"-dir", dir}); status != 0 {
+		t.Fatalf("Want 0 for a freestyle template rendered with a Maven model but got %d\n", status)
+	}
+
+	write("proj/app/stashkins.yaml", "aspect: ant\n")
+	if status := lint([]string{"-dir", dir}); status != 1 {
+		t.Fatalf("Want 1 for an invalid settings file but got %d\n", status)
+	}
+
+	write("proj/app/stashkins.yaml", "aspect: [maven\n")
+	if status := lint([]string{"-dir", dir}); status != 1 {
+		t.Fatalf("Want 1 for a malformed settings file but got %d\n", status)
+	}
+
+	write("proj/app/stashkins.yaml", "")
+	write("proj/other/continuous-template.xml", `<project>{{.Unknown}}</project>`)
+	if status := lint([]string{"-dir", dir}); status != 1 {
+		t.Fatalf("Want 1 but got %d\n", status)
+	}
+
+	if status := lint([]string{"-dir", filepath.Join(dir, "missing")}); status != 2 {
+		t.Fatalf("Want 2 for a missing directory but got %d\n", status)
+	}
+}
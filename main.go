@@ -8,33 +8,89 @@ import (
 	"os/signal"
 	"runtime"
 	"syscall"
+	"time"
 
-	"log"
 	"os"
 
+	"github.com/sirupsen/logrus"
 	"github.com/xoom/jenkins"
 
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/xoom/stashkins/stashkins"
 )
 
 var (
 	stashBaseURL             = flag.String("stash-rest-base-url", "http://stash.example.com:8080", "Stash REST Base URL")
+	scmProvider              = flag.String("scm-provider", stashkins.SCMStash, "Source code management system hosting the repositories:  stash, bitbucket-server, github, gitlab or gitea")
+	scmBaseURL               = flag.String("scm-base-url", "", "API Base URL of the SCM provider, such as https://api.github.com.  If omitted, stash-rest-base-url is used.")
+	scmUserName              = flag.String("scm-username", "", "User with which to authenticate with the SCM provider, where the Stash credentials do not apply.  Accepts a credential reference.")
+	scmPassword              = flag.String("scm-password", "", "Password or access token with which to authenticate with the SCM provider, where the Stash credentials do not apply.  Accepts a credential reference.")
+	scmCloneProtocol         = flag.String("scm-clone-protocol", stashkins.CloneSSH, "Protocol over which jobs clone repositories:  ssh or https.  The stash provider supports only ssh.")
 	jenkinsBaseURL           = flag.String("jenkins-base-url", "http://jenkins.example.com:8080", "Jenkins Base URL")
 	jenkinsJobsDirectory     = flag.String("jenkins-jobs-directory", "", "Filesystem location of Jenkins jobs directory.  Used when acquiring job summaries from the Jenkins master filesystem.")
 	jobTemplateRepositoryURL = flag.String("job-template-repository-url", "", "The Stash repository where job templates are stored..")
 	jobTemplateBranch        = flag.String("job-template-repository-branch", "master", "Templates are held a Stash repository.  This is the branch from which to fetch the job template.")
-	userName                 = flag.String("username", "", "User capable of doing automation tasks on Stash and Jenkins")
-	password                 = flag.String("password", "", "Password for automation user")
+	jobTemplateRef           = flag.String("job-template-repository-ref", "", "A tag or commit of the template repository to check out instead of following job-template-repository-branch")
+	jobTemplateSource        = flag.String("job-template-source", stashkins.TemplateSourceGit, "Where job templates come from:  git, the template repository; directory, a directory at job-template-path; or archive, a .tar, .tar.gz, .tgz or .zip file at job-template-path")
+	jobTemplateDepth         = flag.Int("job-template-repository-depth", 0, "Clone only this many commits of the template repository's history.  0 clones all of it.")
+	jobTemplateUserName      = flag.String("job-template-repository-username", "", "User with which to fetch an http or https template repository")
+	jobTemplatePassword      = flag.String("job-template-repository-password", "", "Password or access token with which to fetch an http or https template repository.  Accepts a credential reference.")
+	jobTemplateSSHKey        = flag.String("job-template-repository-ssh-key", "", "Private key file with which to fetch an ssh template repository.  If omitted, the keys of the running ssh-agent are used.")
+	jobTemplateSSHPassphrase = flag.String("job-template-repository-ssh-key-passphrase", "", "Passphrase of job-template-repository-ssh-key, if encrypted.  Accepts a credential reference.")
+	jobTemplatePath          = flag.String("job-template-path", "", "Directory or archive holding the job templates, for the directory and archive template sources")
+	userName                 = flag.String("username", "", "User capable of doing automation tasks on Stash and Jenkins, where stash-username or jenkins-username is not given")
+	password                 = flag.String("password", "", "Password for automation user, where stash-password or jenkins-password is not given.  Accepts a credential reference.")
+	stashUserName            = flag.String("stash-username", "", "User capable of doing automation tasks on the SCM provider.  Accepts a credential reference.")
+	stashPassword            = flag.String("stash-password", "", "Password for the SCM provider automation user, or access token for github, gitlab and gitea.  Accepts a credential reference.")
+	jenkinsUserName          = flag.String("jenkins-username", "", "User capable of doing automation tasks on Jenkins.  Accepts a credential reference.")
+	jenkinsPassword          = flag.String("jenkins-password", "", "Password for the Jenkins automation user.  Accepts a credential reference.")
+	credentialHelper         = flag.String("credential-helper", "", "Command run with stash, jenkins or maven-repo as its argument to supply credentials not otherwise given")
 	mavenBaseURL             = flag.String("maven-repo-base-url", "http://localhost:8081/nexus", "Maven repository management Base URL")
-	mavenUsername            = flag.String("maven-repo-username", "", "User capable of doing automation of Maven repository management")
-	mavenPassword            = flag.String("maven-repo-password", "", "Password for Maven repository management user")
+	mavenUsername            = flag.String("maven-repo-username", "", "User capable of doing automation of Maven repository management.  Accepts a credential reference.")
+	mavenPassword            = flag.String("maven-repo-password", "", "Password for Maven repository management user.  Accepts a credential reference.")
 	mavenRepositoryGroupID   = flag.String("maven-repo-repository-groupID", "", "Repository groupID in which to group new per-branch repositories")
 	managedBranchPrefixes    = flag.String("managed-branch-prefixes", "feature/", "Branch prefixes to manage.")
+	branchRules              = flag.String("branch-rules", "", "Ordered rules selecting feature branches ahead of managed-branch-prefixes, each + to include or - to exclude followed by a glob or a /regular expression/, such as -dependabot/*,+bugfix/*.  The first matching rule decides.")
+	staleBranchDays          = flag.Int("stale-branch-days", 0, "Skip feature branches whose latest commit is more than this many days old.  0 skips none.")
+	parameters               = flag.String("parameters", "", "Custom parameters given to every job template as .Parameters, each name=value, such as team=platform,notify=builds@example.com")
+	mainlineBranches         = flag.String("mainline-branches", "develop", "Long-lived branches to manage, each a branch or pattern optionally followed by =snapshot-repository-ID, such as main,release/*=release-snapshots.  The first names the release job's branch.")
 	versionFlag              = flag.Bool("version", false, "Print build info from which stashkins was built")
+	reportFile               = flag.String("report-file", "", "Write a reconciliation report to this file.  Use - for stdout.  If omitted, no report is written.")
+	reportFormat             = flag.String("report-format", "json", "Reconciliation report format:  json or yaml")
+	requestTimeout           = flag.Duration("request-timeout", stashkins.DefaultRequestTimeout, "Time allowed for each request to the SCM provider's REST API, and each request to read, update or archive a Jenkins job")
+	maxDeletesPerRepository  = flag.Int("max-deletes-per-repository", 0, "Refuse to delete a repository's obsolete jobs if there are more than this many.  0 means no limit.")
+	maxDeletePercentPerRepo  = flag.Int("max-delete-percent-per-repository", 0, "Refuse to delete a repository's obsolete jobs if they are more than this percentage of its existing jobs.  0 means no limit.")
+	maxDeletesPerRun         = flag.Int("max-deletes-per-run", 0, "Refuse to delete a repository's obsolete jobs if the run's total deletions would exceed this many.  0 means no limit.")
+	maxDeletePercentPerRun   = flag.Int("max-delete-percent-per-run", 0, "Refuse to delete a repository's obsolete jobs if the run's total deletions would exceed this percentage of all templated jobs.  0 means no limit.")
+	archiveObsoleteJobs      = flag.Bool("archive-obsolete-jobs", false, "Disable and rename obsolete jobs to retired-<timestamp>-<job name> instead of deleting them")
+	archiveRetentionDays     = flag.Int("archive-retention-days", 0, "Delete archived jobs older than this many days.  0 keeps archived jobs forever.")
+	tagPattern               = flag.String("tag-pattern", "", "Create a release job from tag-template.xml for each tag matching this pattern, such as v*.  If omitted, no tag jobs are created.")
+	tagJobsKeep              = flag.Int("tag-jobs-keep", 0, "Keep jobs for only this many matching tags, those with the highest versions.  0 keeps a job for every matching tag.")
+	workers                  = flag.Int("workers", 1, "Number of repositories to reconcile concurrently")
+	stashConcurrency         = flag.Int("stash-concurrency", 0, "Maximum concurrent requests to Stash.  0 means no limit.")
+	jenkinsConcurrency       = flag.Int("jenkins-concurrency", 0, "Maximum concurrent requests to Jenkins.  0 means no limit.")
+	nexusConcurrency         = flag.Int("nexus-concurrency", 0, "Maximum concurrent requests to Nexus.  0 means no limit.")
+	repairDrift              = flag.Bool("repair-drift", false, "Update existing jobs whose configuration differs from their rendered template")
+	dryRun                   = flag.Bool("dry-run", false, "Print the reconciliation plan for each template without making changes to Jenkins or Nexus")
+	daemon                   = flag.Bool("daemon", false, "Run continuously, reconciling every interval.  SIGHUP reloads configuration and SIGINT or SIGTERM shut down between repositories.")
+	interval                 = flag.Duration("interval", 15*time.Minute, "Time between reconciliations in daemon mode")
+	webhookAddress           = flag.String("webhook-address", "", "In daemon mode, listen on this address, such as :8080, for Stash webhooks at /webhook.  If omitted, no webhooks are received.")
+	webhookSecret            = flag.String("webhook-secret", "", "Shared secret with which webhooks are signed or that they carry.  Accepts a credential reference.")
+	webhookDebounce          = flag.Duration("webhook-debounce", 5*time.Second, "Reconcile a repository once its webhooks have been quiet for this long")
+	metricsAddress           = flag.String("metrics-address", "", "In daemon mode, serve Prometheus metrics on this address, such as :9090, at /metrics")
+	metricsTextfile          = flag.String("metrics-textfile", "", "Write Prometheus metrics to this file after each run, for the node exporter textfile collector")
+	logLevel                 = flag.String("log-level", "info", "Minimum level of log entries:  debug, info, warning or error")
+	logFormat                = flag.String("log-format", "logfmt", "Log entry format:  logfmt or json")
+	configurationFile        = flag.String("config", "", "YAML configuration file.  Settings are taken from flags, then environment variables, then this file, then flag defaults.")
 
-	Log *log.Logger = log.New(os.Stdout, "stashkins ", log.Ldate|log.Ltime|log.Lshortfile)
+	Log = stashkins.Log
+
+	webhookSecretValue string
+
+	metrics = stashkins.NewMetrics()
 
 	stashParams   stashkins.WebClientParams
 	jenkinsParams stashkins.WebClientParams
@@ -43,10 +99,23 @@ var (
 	buildInfo string
 )
 
-func init() {
+// configure parses the command line and loads the configuration file and environment beneath it.  It is called from main
+// rather than init so that test binaries, whose flags these are not, may be built from this package.
+func configure() {
 	flag.Parse()
-	stashParams = stashkins.WebClientParams{URL: *stashBaseURL, UserName: *userName, Password: *password}
-	jenkinsParams = stashkins.WebClientParams{URL: *jenkinsBaseURL, UserName: *userName, Password: *password}
+	if err := loadConfiguration(flag.CommandLine, os.Getenv); err != nil {
+		Log.WithError(err).Fatal("Cannot load configuration")
+	}
+	if err := stashkins.ConfigureLogging(*logLevel, *logFormat); err != nil {
+		Log.WithError(err).Fatal("Cannot configure logging")
+	}
+	configureClients()
+}
+
+// configureClients builds the client parameters from the current settings.
+func configureClients() {
+	stashParams = stashkins.WebClientParams{URL: *stashBaseURL, UserName: firstNonEmpty(*stashUserName, *userName), Password: firstNonEmpty(*stashPassword, *password), Timeout: *requestTimeout}
+	jenkinsParams = stashkins.WebClientParams{URL: *jenkinsBaseURL, UserName: firstNonEmpty(*jenkinsUserName, *userName), Password: firstNonEmpty(*jenkinsPassword, *password), Timeout: *requestTimeout}
 	nexusParams = stashkins.MavenRepositoryParams{
 		WebClientParams: stashkins.WebClientParams{
 			URL:      *mavenBaseURL,
@@ -58,24 +127,39 @@ func init() {
 }
 
 func main() {
-	Log.Printf("%s\n", buildInfo)
+	configure()
+	if flag.Arg(0) == "render" {
+		// The job's configuration alone goes to standard output.
+		Log.Out = os.Stderr
+	}
+	Log.WithField("build", buildInfo).Info("Stashkins")
 	if *versionFlag {
 		os.Exit(0)
 	}
 
-	// Setup a lock file so consecutive runs do not overlap
-	if runtime.GOOS == "linux" {
+	switch flag.Arg(0) {
+	case "":
+	case "lint":
+		os.Exit(lint(flag.Args()[1:]))
+	case "render":
+		os.Exit(render(flag.Args()[1:]))
+	default:
+		Log.WithField("command", flag.Arg(0)).Fatal("Unknown command")
+	}
+
+	// Setup a lock file so consecutive runs do not overlap.  A daemon does not overlap with itself.
+	if runtime.GOOS == "linux" && !*daemon {
 		// https://github.com/golang/go/issues/8456
 		lock, err := os.OpenFile("/var/lock/stashkins.lock", os.O_CREATE|os.O_EXCL, 0666)
 		if err != nil {
-			Log.Println(err)
+			Log.WithError(err).Error("Cannot create lock file")
 			return
 		}
 		defer lock.Close()
 
 		err = syscall.Flock(int(lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
 		if err != nil {
-			Log.Printf("Error acquiring lock on %s: %v\n", lock.Name(), err)
+			Log.WithError(err).WithField("file", lock.Name()).Error("Cannot acquire lock")
 			return
 		}
 
@@ -91,25 +175,99 @@ func main() {
 
 		defer func(f *os.File) {
 			if err := syscall.Flock(int(f.Fd()), syscall.LOCK_UN); err != nil {
-				Log.Printf("Error releasing lock on %s: %v\n", f.Name(), err)
+				Log.WithError(err).WithField("file", f.Name()).Error("Cannot release lock")
 				return
 			}
 			if err := os.Remove(f.Name()); err != nil {
-				Log.Printf("Error removing lock file %s: %v\n", f.Name(), err)
+				Log.WithError(err).WithField("file", f.Name()).Error("Cannot remove lock file")
 			}
 		}(lock)
 	}
 
-	Log.Println("Stashkins __begin")
+	Log.Info("Stashkins __begin")
+
+	if err := resolveCredentials(); err != nil {
+		Log.WithError(err).Error("Cannot resolve credentials")
+		return
+	}
 
 	if err := validateCommandLineArguments(); err != nil {
-		Log.Println(err)
+		Log.WithError(err).Error("Invalid arguments")
 		return
 	}
 
+	templateCloneDirectory, err := ioutil.TempDir("", "stashkins-templates-")
+	if err != nil {
+		Log.WithError(err).Fatal("Cannot create template clone directory")
+	}
+	defer func() {
+		os.RemoveAll(templateCloneDirectory)
+	}()
+
+	if *daemon {
+		runDaemon(templateCloneDirectory)
+	} else {
+		if *metricsTextfile != "" {
+			if err := metrics.LoadLastSuccess(*metricsTextfile); err != nil {
+				Log.WithError(err).WithField("file", *metricsTextfile).Warn("Cannot read previous metrics")
+			}
+		}
+		reconcile(templateCloneDirectory, nil, nil)
+	}
+	Log.Info("Stashkins has finished (__finish).")
+}
+
+// reconcileMutex keeps reconciliations from overlapping.
+var reconcileMutex sync.Mutex
+
+// intervalGuard is the deletion guard of a daemon's current interval, made by its scheduled reconciliation, or by the first
+// webhook reconciliation should that come first.  reconcileMutex guards it.
+var intervalGuard *stashkins.DeletionGuard
+
+// reconcile runs one reconciliation of every template for which include returns true, or of every template if include is
+// nil.  Templates are cloned into, or pulled if already cloned into, templateCloneDirectory.  Once stop is closed no
+// further repositories are started.  A report is written, and the run recorded in the run metrics, only when every
+// template is reconciled.
+func reconcile(templateCloneDirectory string, stop <-chan struct{}, include func(stashkins.JobTemplate) bool) {
+	reconcileMutex.Lock()
+	defer reconcileMutex.Unlock()
+
+	started := time.Now()
+	succeeded := false
+	if include == nil && !*dryRun {
+		defer func() {
+			metrics.ObserveRun(started, time.Now(), succeeded)
+			if *metricsTextfile != "" {
+				if err := metrics.WriteTextfile(*metricsTextfile); err != nil {
+					Log.WithError(err).WithField("file", *metricsTextfile).Error("Cannot write metrics")
+				}
+			}
+		}()
+	}
+
 	branchOperations := stashkins.NewBranchOperations(*managedBranchPrefixes)
+	branchOperations.Mainlines, _ = stashkins.ParseMainlineBranches(*mainlineBranches)
+	branchOperations.Rules, _ = stashkins.ParseBranchRules(*branchRules)
+	branchOperations.StaleDays = *staleBranchDays
 
-	skins := stashkins.NewStashkins(stashParams, jenkinsParams, nexusParams, branchOperations)
+	skins := stashkins.NewStashkins(stashParams, jenkinsParams, nexusParams, branchOperations).WithMetrics(metrics).WithConcurrencyLimits(stashkins.ConcurrencyLimits{
+		Workers: *workers,
+		Stash:   *stashConcurrency,
+		Jenkins: *jenkinsConcurrency,
+		Nexus:   *nexusConcurrency,
+	}).WithStop(stop)
+	skins.Options = stashkins.ReconcileOptions{
+		RepairDrift:          *repairDrift,
+		JenkinsJobsDirectory: *jenkinsJobsDirectory,
+		Retirement: stashkins.RetirementPolicy{
+			Archive:       *archiveObsoleteJobs,
+			RetentionDays: *archiveRetentionDays,
+		},
+		Tags: stashkins.TagPolicy{
+			Pattern: *tagPattern,
+			Keep:    *tagJobsKeep,
+		},
+	}
 
 	var jobSummaries []jenkins.JobSummary
 
@@ -117,68 +275,369 @@ func main() {
 	if *jenkinsJobsDirectory == "" {
 		jobSummaries, err = skins.JobSummariesOverHTTP()
 		if err != nil {
-			Log.Printf("main: Cannot get Jenkins job summaries over HTTP: %#v\n", err)
+			Log.WithError(err).Error("Cannot get Jenkins job summaries over HTTP")
 			return
 		}
 	} else {
 		jobSummaries, err = skins.JobSummariesFromFilesystem(*jenkinsJobsDirectory)
 		if err != nil {
-			Log.Printf("main: Cannot get Jenkins job summaries from filesystem: %#v\n", err)
+			Log.WithError(err).Error("Cannot get Jenkins job summaries from filesystem")
 			return
 		}
 	}
-	Log.Printf("Found %d Jenkins job summaries\n", len(jobSummaries))
+	Log.WithField("count", len(jobSummaries)).Info("Found Jenkins job summaries")
+	jobIndex := stashkins.NewJobIndex(jobSummaries)
 
-	templateCloneDirectory, err := ioutil.TempDir("", "stashkins-templates-")
+	source, err := templateSource()
 	if err != nil {
-		Log.Fatalln(err)
+		Log.WithError(err).Error("Invalid template source")
+		return
 	}
-	defer func() {
-		os.RemoveAll(templateCloneDirectory)
-	}()
-
-	jobTemplates, err := stashkins.Templates(*jobTemplateRepositoryURL, *jobTemplateBranch, templateCloneDirectory)
+	jobTemplates, err := stashkins.Templates(source, templateCloneDirectory)
 	if err != nil {
-		Log.Printf("main: cannot fetch job templates:  %v\n", err)
+		Log.WithError(err).Error("Cannot fetch job templates")
 		return
 	}
-	Log.Printf("Found %d Jenkins job templates\n", len(jobTemplates))
+	Log.WithField("count", len(jobTemplates)).Info("Found Jenkins job templates")
 
+	// Every enabled template is resolved, even for a reconciliation of some of them, since the deletion guard is measured
+	// against them all.  Only the templates include selects are reconciled, and only their skipping is logged.
+	settings := make(map[string]repositorySettings, len(jobTemplates))
+	providers := make(map[string]stashkins.SCMProvider)
+	enabledTemplates := make([]stashkins.JobTemplate, 0, len(jobTemplates))
+	includedTemplates := make([]stashkins.JobTemplate, 0, len(jobTemplates))
+	collisions := jobNamePrefixCollisions(jobTemplates)
 	for _, jobTemplate := range jobTemplates {
-		var jobAspect stashkins.Aspect
+		included := include == nil || include(jobTemplate)
+		log := Log.WithFields(logrus.Fields{"project": jobTemplate.ProjectKey, "slug": jobTemplate.Slug})
+		if err := collisions[jobTemplate.ProjectKey+"/"+jobTemplate.Slug]; err != nil {
+			if included {
+				log.WithError(err).Error("Skipping repository whose job names collide with another's")
+			}
+			continue
+		}
+		repositorySettings, err := settingsFor(jobTemplate)
+		if _, ok := err.(settingsFileError); ok {
+			if included {
+				log.WithError(err).Error("Skipping repository with invalid settings file")
+			}
+			continue
+		}
+		if err != nil {
+			Log.WithError(err).Error("Invalid project settings")
+			return
+		}
+		if !repositorySettings.enabled {
+			if included {
+				log.Info("Skipping repository disabled by configuration")
+			}
+			continue
+		}
+		if _, present := providers[repositorySettings.scmKey()]; !present {
+			provider, err := newSCMProvider(repositorySettings)
+			if err != nil {
+				Log.WithError(err).Error("Invalid SCM provider settings")
+				return
+			}
+			providers[repositorySettings.scmKey()] = provider
+		}
+		settings[jobTemplate.ProjectKey+"/"+jobTemplate.Slug] = repositorySettings
+		enabledTemplates = append(enabledTemplates, jobTemplate)
+		if included {
+			includedTemplates = append(includedTemplates, jobTemplate)
+		}
+	}
+	jobTemplates = includedTemplates
+
+	setup := func(jobTemplate stashkins.JobTemplate) (stashkins.DefaultStashkins, stashkins.Aspect) {
+		repositorySettings := settings[jobTemplate.ProjectKey+"/"+jobTemplate.Slug]
+		return repositorySetup(skins, repositorySettings, providers[repositorySettings.scmKey()], jobTemplate)
+	}
+
+	// A daemon's webhook reconciliations share the guard of the scheduled reconciliation before them, so that the per-run
+	// limits bound the deletions of the whole interval rather than of each repository reconciled alone.
+	skins.Options.DeletionGuard = intervalGuard
+	if include == nil || intervalGuard == nil {
+		skins.Options.DeletionGuard = stashkins.NewDeletionGuard(stashkins.DeletionLimits{
+			MaxPerRepository:        *maxDeletesPerRepository,
+			MaxPercentPerRepository: *maxDeletePercentPerRepo,
+			MaxPerRun:               *maxDeletesPerRun,
+			MaxPercentPerRun:        *maxDeletePercentPerRun,
+		}, jobIndex, enabledTemplates, setup)
+		if *daemon && !*dryRun {
+			intervalGuard = skins.Options.DeletionGuard
+		}
+	}
+
+	if *dryRun {
+		plans, errs := skins.PlanAll(jobIndex, jobTemplates, setup)
+		for i, plan := range plans {
+			if errs[i] != nil {
+				Log.WithError(errs[i]).WithFields(logrus.Fields{"project": jobTemplates[i].ProjectKey, "slug": jobTemplates[i].Slug}).Warn("Cannot plan jobs")
+				continue
+			}
+			fmt.Print(plan)
+		}
+		return
+	}
+
+	report := stashkins.Report{Started: time.Now()}
+	report.Repositories = skins.ReconcileAll(jobIndex, jobTemplates, setup)
+	report.Finished = time.Now()
+	succeeded = stashkins.RunSucceeded(report.Repositories)
+
+	if *reportFile != "" && include == nil {
+		if err := writeReport(report, *reportFile, *reportFormat); err != nil {
+			Log.WithError(err).WithField("file", *reportFile).Error("Cannot write report")
+		}
+	}
+}
+
+// repositorySetup returns the Stashkins, derived from skins, and the Aspect with which a repository having the given settings
+// is reconciled.  The Aspect is nil if the template is of no known job type.
+func repositorySetup(skins stashkins.DefaultStashkins, repositorySettings repositorySettings, provider stashkins.SCMProvider, jobTemplate stashkins.JobTemplate) (stashkins.DefaultStashkins, stashkins.Aspect) {
+	branchOperations := stashkins.NewBranchOperations(repositorySettings.managedBranchPrefixes)
+	branchOperations.Mainlines = repositorySettings.mainlineBranches
+	branchOperations.Rules = repositorySettings.branchRules
+	branchOperations.StaleDays = repositorySettings.staleBranchDays
+
+	repositorySkins := skins.WithBranchOperations(branchOperations).WithSCMProvider(provider)
+	repositorySkins.Options.RepairDrift = repositorySettings.repairDrift
+	repositorySkins.Options.Retirement = stashkins.RetirementPolicy{
+		Archive:       repositorySettings.archiveObsoleteJobs,
+		RetentionDays: repositorySettings.archiveRetentionDays,
+	}
+	repositorySkins.Options.Tags = stashkins.TagPolicy{
+		Pattern: repositorySettings.tagPattern,
+		Keep:    repositorySettings.tagJobsKeep,
+	}
+	repositorySkins.Options.JobNamePrefix = repositorySettings.jobNamePrefix
+	repositorySkins.Options.Parameters = repositorySettings.parameters
 
-		switch jobTemplate.JobType {
-		case jenkins.Maven:
-			jobAspect = stashkins.NewMavenAspect(nexusParams, skins.NexusClient, branchOperations)
-		case jenkins.Freestyle:
-			jobAspect = stashkins.NewFreestyleAspect()
+	switch repositorySettings.jobType(jobTemplate) {
+	case jenkins.Maven:
+		params := nexusParams
+		params.FeatureBranchRepositoryGroupID = repositorySettings.mavenRepositoryGroupID
+		return repositorySkins, stashkins.NewMavenAspect(params, skins.NexusClient, branchOperations)
+	case jenkins.Freestyle:
+		return repositorySkins, stashkins.NewFreestyleAspect()
+	}
+	return repositorySkins, nil
+}
+
+func writeReport(report stashkins.Report, fileName, format string) error {
+	if fileName == "-" {
+		return report.Write(os.Stdout, format)
+	}
+
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	if err := report.Write(f, format); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// resolveCredentials replaces credential references in the client parameters with the secrets they refer to, asking the
+// credential helper for any still missing.
+func resolveCredentials() error {
+	var err error
+	if stashParams, err = stashParams.ResolveCredentials(*credentialHelper, "stash"); err != nil {
+		return err
+	}
+	if jenkinsParams, err = jenkinsParams.ResolveCredentials(*credentialHelper, "jenkins"); err != nil {
+		return err
+	}
+	if nexusParams.WebClientParams, err = nexusParams.WebClientParams.ResolveCredentials(*credentialHelper, "maven-repo"); err != nil {
+		return err
+	}
+	if webhookSecretValue, err = stashkins.ResolveCredential(*webhookSecret); err != nil {
+		return fmt.Errorf("cannot resolve webhook secret: %v", err)
+	}
+	return nil
+}
+
+// newSCMProvider returns the provider of a repository having the given settings.
+func newSCMProvider(repositorySettings repositorySettings) (stashkins.SCMProvider, error) {
+	params, err := scmParams(repositorySettings)
+	if err != nil {
+		return nil, err
+	}
+	return stashkins.NewSCMProvider(repositorySettings.scmProvider, params, *scmCloneProtocol)
+}
+
+// scmParams returns how to reach the SCM provider of a repository having the given settings.  Its API is at scm-base-url,
+// or at stash-rest-base-url if that is empty.  It authenticates with scm-username and scm-password if either is given, and
+// otherwise with the Stash credentials.
+func scmParams(repositorySettings repositorySettings) (stashkins.WebClientParams, error) {
+	params := stashParams
+	params.URL = firstNonEmpty(repositorySettings.scmBaseURL, *stashBaseURL)
+	if repositorySettings.scmUserName == "" && repositorySettings.scmPassword == "" {
+		return params, nil
+	}
+
+	var err error
+	if params.UserName, err = stashkins.ResolveCredential(repositorySettings.scmUserName); err != nil {
+		return params, fmt.Errorf("cannot resolve scm-username: %v", err)
+	}
+	if params.Password, err = stashkins.ResolveCredential(repositorySettings.scmPassword); err != nil {
+		return params, fmt.Errorf("cannot resolve scm-password: %v", err)
+	}
+	return params, nil
+}
+
+// templateSource returns the configured source of job templates.
+func templateSource() (stashkins.TemplateSource, error) {
+	switch *jobTemplateSource {
+	case stashkins.TemplateSourceGit:
+		if *jobTemplateRepositoryURL == "" {
+			return nil, errors.New("job-template-repository-url is required for the git template source")
+		}
+		password, err := stashkins.ResolveCredential(*jobTemplatePassword)
+		if err != nil {
+			return nil, fmt.Errorf("cannot resolve job-template-repository-password: %v", err)
+		}
+		passphrase, err := stashkins.ResolveCredential(*jobTemplateSSHPassphrase)
+		if err != nil {
+			return nil, fmt.Errorf("cannot resolve job-template-repository-ssh-key-passphrase: %v", err)
 		}
+		return stashkins.GitTemplateSource{
+			URL:    *jobTemplateRepositoryURL,
+			Branch: *jobTemplateBranch,
+			Ref:    *jobTemplateRef,
+			Depth:  *jobTemplateDepth,
+			Auth: stashkins.GitAuth{
+				Username:         *jobTemplateUserName,
+				Password:         password,
+				SSHKeyFile:       *jobTemplateSSHKey,
+				SSHKeyPassphrase: passphrase,
+			},
+		}, nil
+	case stashkins.TemplateSourceDirectory:
+		return stashkins.DirectoryTemplateSource{Path: *jobTemplatePath}, nil
+	case stashkins.TemplateSourceArchive:
+		return stashkins.ArchiveTemplateSource{Path: *jobTemplatePath}, nil
+	}
+	return nil, fmt.Errorf("unsupported job-template-source %s", *jobTemplateSource)
+}
+
+// templateSourceKey identifies the configured source of job templates, so that a change of source may be noticed.
+func templateSourceKey() string {
+	return strings.Join([]string{*jobTemplateSource, *jobTemplateRepositoryURL, *jobTemplateBranch, *jobTemplateRef, strconv.Itoa(*jobTemplateDepth), *jobTemplatePath}, "#")
+}
 
-		Log.Printf("Reconciling jobs for %s/%s\n", jobTemplate.ProjectKey, jobTemplate.Slug)
-		if err := skins.ReconcileJobs(jobSummaries, jobTemplate, jobAspect); err != nil {
-			Log.Printf("main: warning: while reconciling jobs for %s/%s: %v\n", jobTemplate.ProjectKey, jobTemplate.Slug, err)
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
 		}
 	}
-	Log.Println("Stashkins has finished (__finish).")
+	return ""
 }
 
 func validateCommandLineArguments() error {
-	if *userName == "" || *password == "" {
-		return errors.New("username and password are required")
+	scm := repositorySettings{scmProvider: *scmProvider, scmBaseURL: *scmBaseURL, scmUserName: *scmUserName, scmPassword: *scmPassword}
+	if _, err := newSCMProvider(scm); err != nil {
+		return err
 	}
 
-	if *jobTemplateRepositoryURL == "" {
-		return errors.New("template-repository-url is required")
+	scmLogin, _ := scmParams(scm)
+	switch *scmProvider {
+	case stashkins.SCMStash, stashkins.SCMBitbucketServer:
+		if scmLogin.UserName == "" || scmLogin.Password == "" {
+			return errors.New("Stash credentials are required:  scm-username and scm-password, stash-username and stash-password, username and password, or credential-helper")
+		}
+	default:
+		if scmLogin.Password == "" {
+			return fmt.Errorf("An access token is required for %s:  scm-password, stash-password, password, or credential-helper", *scmProvider)
+		}
+	}
+
+	if jenkinsParams.UserName == "" || jenkinsParams.Password == "" {
+		return errors.New("Jenkins credentials are required:  jenkins-username and jenkins-password, username and password, or credential-helper")
+	}
+
+	switch *jobTemplateSource {
+	case stashkins.TemplateSourceGit:
+		if *jobTemplateRepositoryURL == "" {
+			return errors.New("template-repository-url is required")
+		}
+		if *jobTemplateDepth < 0 {
+			return errors.New("job-template-repository-depth cannot be negative")
+		}
+	case stashkins.TemplateSourceDirectory, stashkins.TemplateSourceArchive:
+		if *jobTemplatePath == "" {
+			return fmt.Errorf("job-template-path is required for the %s template source", *jobTemplateSource)
+		}
+	default:
+		return fmt.Errorf("unsupported job-template-source %s", *jobTemplateSource)
 	}
 
 	if *mavenRepositoryGroupID == "" {
 		return errors.New("maven-repo-repository-groupID is required")
 	}
 
-	if *mavenUsername == "" || *mavenPassword == "" || *mavenRepositoryGroupID == "" {
+	if nexusParams.UserName == "" || nexusParams.Password == "" || *mavenRepositoryGroupID == "" {
 		return errors.New("maven-repo-username, maven-repo-password, and maven-repo-repository-groupID are required")
 	}
 
+	for _, v := range []int{*maxDeletesPerRepository, *maxDeletePercentPerRepo, *maxDeletesPerRun, *maxDeletePercentPerRun} {
+		if v < 0 {
+			return errors.New("deletion limits must not be negative")
+		}
+	}
+
+	if *workers < 1 {
+		return errors.New("workers must be at least 1")
+	}
+
+	if *archiveRetentionDays < 0 {
+		return errors.New("archive-retention-days must not be negative")
+	}
+
+	if err := (stashkins.TagPolicy{Pattern: *tagPattern, Keep: *tagJobsKeep}).Validate(); err != nil {
+		return err
+	}
+
+	if _, err := stashkins.ParseMainlineBranches(*mainlineBranches); err != nil {
+		return err
+	}
+
+	if _, err := stashkins.ParseBranchRules(*branchRules); err != nil {
+		return err
+	}
+
+	if *staleBranchDays < 0 {
+		return errors.New("stale-branch-days must not be negative")
+	}
+
+	if _, err := stashkins.ParseParameters(*parameters); err != nil {
+		return err
+	}
+
+	if *webhookAddress != "" && !*daemon {
+		return errors.New("webhook-address requires daemon")
+	}
+
+	if *metricsAddress != "" && !*daemon {
+		return errors.New("metrics-address requires daemon.  Use metrics-textfile when running once.")
+	}
+
+	if *interval <= 0 {
+		return errors.New("interval must be positive")
+	}
+
+	if *requestTimeout <= 0 {
+		return errors.New("request-timeout must be positive")
+	}
+
+	if *reportFormat != "json" && *reportFormat != "yaml" {
+		return fmt.Errorf("report-format must be json or yaml: %s\n", *reportFormat)
+	}
+
 	if *jenkinsJobsDirectory != "" && !strings.HasPrefix(*jenkinsJobsDirectory, "/") {
 		return fmt.Errorf("jenkins-jobs-directory must be specified with an absolute path: %s\n", *jenkinsJobsDirectory)
 	}
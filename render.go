@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xoom/stashkins/stashkins"
+)
+
+// render prints the configuration of the job reconciliation would create for one branch of one repository, or of its
+// release job, and returns the exit status.  Templates come from the template repository or a local checkout of it.  The
+// repository's clone URLs and head commits are sample values unless -scm asks to read them from its SCM provider.  Nothing
+// is changed.
+func render(args []string) int {
+	fs := flag.NewFlagSet("render", flag.ContinueOnError)
+	project := fs.String("project", "", "Project key of the repository")
+	slug := fs.String("slug", "", "Slug of the repository")
+	branch := fs.String("branch", "", "Branch whose continuous job to render, such as feature/PROJ-999")
+	release := fs.Bool("release", false, "Render the release job instead of a continuous job")
+	dir := fs.String("dir", "", "Local checkout of the template repository.  If omitted, templates come from the configured template source.")
+	online := fs.Bool("scm", false, "Read the repository's clone URLs and branch heads from its SCM provider.  If omitted, sample values are used and no credentials are needed.")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *project == "" || *slug == "" || (*branch == "") == !*release {
+		Log.Error("render requires -project, -slug, and either -branch or -release")
+		return 2
+	}
+
+	jobTemplate, err := renderTemplate(*dir, *project, *slug)
+	if err != nil {
+		Log.WithError(err).Error("Cannot load job template")
+		return 1
+	}
+
+	repositorySettings, err := settingsFor(jobTemplate)
+	if err != nil {
+		Log.WithError(err).Error("Invalid project settings")
+		return 1
+	}
+	var provider stashkins.SCMProvider
+	if *online {
+		if err := resolveCredentials(); err != nil {
+			Log.WithError(err).Error("Cannot resolve credentials")
+			return 1
+		}
+		if provider, err = newSCMProvider(repositorySettings); err != nil {
+			Log.WithError(err).Error("Invalid SCM provider settings")
+			return 1
+		}
+	}
+
+	skins, aspect := repositorySetup(stashkins.DefaultStashkins{}, repositorySettings, provider, jobTemplate)
+	jobName, config, err := skins.RenderJob(jobTemplate, aspect, *branch, *release)
+	if err != nil {
+		Log.WithError(err).WithFields(logrus.Fields{"project": jobTemplate.ProjectKey, "slug": jobTemplate.Slug, "branch": *branch}).Error("Cannot render job")
+		return 1
+	}
+	Log.WithField("job", jobName).Info("Rendered job")
+	if _, err := os.Stdout.Write(config); err != nil {
+		return 1
+	}
+	return 0
+}
+
+// renderTemplate loads the templates from dir, or if dir is empty from the configured template source, and returns those of
+// the given repository.
+func renderTemplate(dir, projectKey, slug string) (stashkins.JobTemplate, error) {
+	var jobTemplates []stashkins.JobTemplate
+	var err error
+	if dir != "" {
+		jobTemplates, err = stashkins.TemplatesFromDirectory(dir)
+	} else {
+		var source stashkins.TemplateSource
+		if source, err = templateSource(); err != nil {
+			return stashkins.JobTemplate{}, err
+		}
+		var cloneDirectory string
+		if cloneDirectory, err = ioutil.TempDir("", "stashkins-templates-"); err != nil {
+			return stashkins.JobTemplate{}, err
+		}
+		defer os.RemoveAll(cloneDirectory)
+		jobTemplates, err = stashkins.Templates(source, cloneDirectory)
+	}
+	if err != nil {
+		return stashkins.JobTemplate{}, err
+	}
+
+	for _, jobTemplate := range jobTemplates {
+		if strings.EqualFold(jobTemplate.ProjectKey, projectKey) && strings.EqualFold(jobTemplate.Slug, slug) {
+			return jobTemplate, nil
+		}
+	}
+	return stashkins.JobTemplate{}, errors.New("no templates for " + projectKey + "/" + slug)
+}
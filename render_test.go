@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "templates-")
+	if err != nil {
+		t.Fatalf("Unexpected error creating temp dir: %v\n", err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "PROJ", "code", "continuous-template.xml")
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if err := ioutil.WriteFile(file, []byte("<project>{{.JobName}}</project>"), 0644); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	jobTemplate, err := renderTemplate(dir, "PROJ", "Code")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if jobTemplate.ProjectKey != "proj" || jobTemplate.Slug != "code" || len(jobTemplate.ContinuousJobTemplate) == 0 {
+		t.Fatalf("Want the continuous template of proj/code but got %+v\n", jobTemplate)
+	}
+
+	if _, err := renderTemplate(dir, "PROJ", "other"); err == nil {
+		t.Fatalf("Want an error for a repository without templates\n")
+	}
+
+	// Without -scm no credentials or SCM provider are needed.
+	if status := render([]string{"-project", "PROJ", "-slug", "code", "-branch", "feature/PROJ-1", "-dir", dir}); status != 0 {
+		t.Fatalf("Want 0 but got %d\n", status)
+	}
+
+	for _, args := range [][]string{{"-project", "PROJ", "-slug", "code"}, {"-project", "PROJ", "-slug", "code", "-branch", "develop", "-release"}} {
+		if status := render(append(args, "-dir", dir)); status != 2 {
+			t.Fatalf("Want 2 for %v but got %d\n", args, status)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package stashkins
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/xoom/stash"
+)
+
+// bitbucketPageLimit is the number of branches, pull requests or tags requested per page.  Bitbucket Server caps pages at its configured maximum.
+const bitbucketPageLimit = 100
+
+// bitbucketServerProvider reads repositories over the Bitbucket Server REST API 1.0, which Stash also serves, following
+// branch pages to the end.
+type bitbucketServerProvider struct {
+	api           restClient
+	cloneProtocol string
+}
+
+type bitbucketRepository struct {
+	Links struct {
+		Clone []struct {
+			Href string `json:"href"`
+			Name string `json:"name"`
+		} `json:"clone"`
+	} `json:"links"`
+}
+
+type bitbucketBranchPage struct {
+	Values        []bitbucketBranch `json:"values"`
+	IsLastPage    bool              `json:"isLastPage"`
+	NextPageStart int               `json:"nextPageStart"`
+}
+
+// bitbucketBranch is a branch as listed with details, whose metadata holds its latest commit.  Stash and Bitbucket Server
+// name the latest commit and its metadata differently.
+type bitbucketBranch struct {
+	stash.Branch
+	LatestCommit    string `json:"latestCommit"`
+	LatestChangeset string `json:"latestChangeset"`
+	Metadata        struct {
+		BitbucketLatestCommit bitbucketCommitMetadata `json:"com.atlassian.bitbucket.server.bitbucket-branch:latest-commit-metadata"`
+		StashLatestCommit     bitbucketCommitMetadata `json:"com.atlassian.stash.stash-branch-utils:latest-changeset-metadata"`
+	} `json:"metadata"`
+}
+
+// bitbucketCommitMetadata holds commit times in milliseconds since the epoch.
+type bitbucketCommitMetadata struct {
+	AuthorTimestamp    int64 `json:"authorTimestamp"`
+	CommitterTimestamp int64 `json:"committerTimestamp"`
+}
+
+type bitbucketPullRequestPage struct {
+	Values []struct {
+		ID     int    `json:"id"`
+		Title  string `json:"title"`
+		Author struct {
+			User struct {
+				Name string `json:"name"`
+			} `json:"user"`
+		} `json:"author"`
+		FromRef stash.Branch `json:"fromRef"`
+		ToRef   stash.Branch `json:"toRef"`
+	} `json:"values"`
+	IsLastPage    bool `json:"isLastPage"`
+	NextPageStart int  `json:"nextPageStart"`
+}
+
+type bitbucketTagPage struct {
+	Values []struct {
+		DisplayID    string `json:"displayId"`
+		LatestCommit string `json:"latestCommit"`
+	} `json:"values"`
+	IsLastPage    bool `json:"isLastPage"`
+	NextPageStart int  `json:"nextPageStart"`
+}
+
+func (b bitbucketServerProvider) Repository(projectKey, slug string) (SCMRepository, error) {
+	var repository bitbucketRepository
+	if _, err := b.api.get("/rest/api/1.0/projects/"+b.repositoryPath(projectKey, slug), &repository); err != nil {
+		return SCMRepository{}, err
+	}
+
+	// Bitbucket Server names the HTTPS clone link http.
+	linkName := "ssh"
+	if b.cloneProtocol == CloneHTTPS {
+		linkName = "http"
+	}
+	scmRepository := SCMRepository{ProjectKey: projectKey, Slug: slug}
+	for _, link := range repository.Links.Clone {
+		if link.Name == linkName {
+			scmRepository.CloneURL = link.Href
+		}
+		if link.Name == "http" {
+			scmRepository.HTTPCloneURL = link.Href
+		}
+	}
+	if scmRepository.CloneURL != "" {
+		return scmRepository, nil
+	}
+	return SCMRepository{}, fmt.Errorf("stashkins.bitbucketServerProvider repository %s/%s has no %s clone link", projectKey, slug, linkName)
+}
+
+func (b bitbucketServerProvider) Branches(projectKey, slug string) (map[string]stash.Branch, error) {
+	values, err := b.branches(projectKey, slug, false)
+	if err != nil {
+		return nil, err
+	}
+	branches := make(map[string]stash.Branch)
+	for _, branch := range values {
+		branches[branch.DisplayID] = branch.Branch
+	}
+	return branches, nil
+}
+
+func (b bitbucketServerProvider) BranchHeads(projectKey, slug string) (map[string]BranchHead, error) {
+	values, err := b.branches(projectKey, slug, true)
+	if err != nil {
+		return nil, err
+	}
+	heads := make(map[string]BranchHead)
+	for _, branch := range values {
+		head := BranchHead{Commit: branch.LatestCommit}
+		if head.Commit == "" {
+			head.Commit = branch.LatestChangeset
+		}
+		var millis int64
+		for _, metadata := range []bitbucketCommitMetadata{branch.Metadata.BitbucketLatestCommit, branch.Metadata.StashLatestCommit} {
+			if metadata.CommitterTimestamp != 0 {
+				millis = metadata.CommitterTimestamp
+			} else if millis == 0 {
+				millis = metadata.AuthorTimestamp
+			}
+		}
+		if millis != 0 {
+			head.Time = time.Unix(0, millis*int64(time.Millisecond))
+		}
+		heads[branch.DisplayID] = head
+	}
+	return heads, nil
+}
+
+// branches lists the repository's branches, with their latest commit metadata if details is set.
+func (b bitbucketServerProvider) branches(projectKey, slug string, details bool) ([]bitbucketBranch, error) {
+	branches := make([]bitbucketBranch, 0)
+	for start := 0; ; {
+		var page bitbucketBranchPage
+		path := fmt.Sprintf("/rest/api/1.0/projects/%s/branches?details=%t&start=%d&limit=%d", b.repositoryPath(projectKey, slug), details, start, bitbucketPageLimit)
+		if _, err := b.api.get(path, &page); err != nil {
+			return nil, err
+		}
+		branches = append(branches, page.Values...)
+		if page.IsLastPage || page.NextPageStart <= start {
+			return branches, nil
+		}
+		start = page.NextPageStart
+	}
+}
+
+func (b bitbucketServerProvider) PullRequests(projectKey, slug string) ([]PullRequest, error) {
+	pullRequests := make([]PullRequest, 0)
+	for start := 0; ; {
+		var page bitbucketPullRequestPage
+		path := fmt.Sprintf("/rest/api/1.0/projects/%s/pull-requests?state=OPEN&start=%d&limit=%d", b.repositoryPath(projectKey, slug), start, bitbucketPageLimit)
+		if _, err := b.api.get(path, &page); err != nil {
+			return nil, err
+		}
+		for _, pr := range page.Values {
+			pullRequests = append(pullRequests, PullRequest{
+				ID:           pr.ID,
+				Title:        pr.Title,
+				Author:       pr.Author.User.Name,
+				SourceBranch: pr.FromRef.DisplayID,
+				TargetBranch: pr.ToRef.DisplayID,
+				MergeRef:     fmt.Sprintf("refs/pull-requests/%d/merge", pr.ID),
+			})
+		}
+		if page.IsLastPage || page.NextPageStart <= start {
+			return pullRequests, nil
+		}
+		start = page.NextPageStart
+	}
+}
+
+func (b bitbucketServerProvider) Tags(projectKey, slug string) ([]Tag, error) {
+	tags := make([]Tag, 0)
+	for start := 0; ; {
+		var page bitbucketTagPage
+		path := fmt.Sprintf("/rest/api/1.0/projects/%s/tags?start=%d&limit=%d", b.repositoryPath(projectKey, slug), start, bitbucketPageLimit)
+		if _, err := b.api.get(path, &page); err != nil {
+			return nil, err
+		}
+		for _, tag := range page.Values {
+			tags = append(tags, Tag{Name: tag.DisplayID, Commit: tag.LatestCommit})
+		}
+		if page.IsLastPage || page.NextPageStart <= start {
+			return tags, nil
+		}
+		start = page.NextPageStart
+	}
+}
+
+func (b bitbucketServerProvider) repositoryPath(projectKey, slug string) string {
+	return url.PathEscape(projectKey) + "/repos/" + url.PathEscape(slug)
+}
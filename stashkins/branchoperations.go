@@ -2,11 +2,74 @@ package stashkins
 
 import (
 	"fmt"
+	"path"
+	"regexp"
 	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
 )
 
 type BranchOperations struct {
 	ManagedPrefixes []string
+
+	// Mainlines are the long-lived branches.  Nil means develop, deploying to the snapshots repository.
+	Mainlines []MainlineBranch
+
+	// Rules select feature branches ahead of ManagedPrefixes.  The first rule matching a branch decides whether it is
+	// managed.
+	Rules []BranchRule
+
+	// StaleDays, if positive, skips feature branches whose latest commit is more than this many days old.
+	StaleDays int
+}
+
+// A BranchRule includes or excludes the branches matching its pattern.
+type BranchRule struct {
+	Include bool
+	Pattern string
+	regexp  *regexp.Regexp
+}
+
+// MainlineBranch is a long-lived branch, or a path.Match pattern of them such as release/*, whose continuous jobs deploy to
+// a shared snapshot repository rather than to a per-branch one.
+type MainlineBranch struct {
+	Pattern            string
+	SnapshotRepository string
+}
+
+var defaultMainlines = []MainlineBranch{{Pattern: "develop", SnapshotRepository: "snapshots"}}
+
+// ParseMainlineBranches parses a comma separated list of mainline branches, each a branch name or pattern optionally
+// followed by = and the ID of its snapshot repository, as in main=snapshots,release/*=release-snapshots.  The snapshot
+// repository defaults to snapshots.  The first entry names the branch the release job builds, so it must not be a pattern.
+func ParseMainlineBranches(mainlineBranches string) ([]MainlineBranch, error) {
+	mainlines := make([]MainlineBranch, 0)
+	for _, v := range strings.Split(mainlineBranches, ",") {
+		candidate := strings.TrimSpace(v)
+		if candidate == "" {
+			continue
+		}
+		mainline := MainlineBranch{Pattern: candidate, SnapshotRepository: "snapshots"}
+		if i := strings.Index(candidate, "="); i >= 0 {
+			mainline.Pattern = strings.TrimSpace(candidate[:i])
+			mainline.SnapshotRepository = strings.TrimSpace(candidate[i+1:])
+		}
+		if mainline.Pattern == "" || mainline.SnapshotRepository == "" {
+			return nil, fmt.Errorf("invalid mainline branch %s: want branch or branch=repository", candidate)
+		}
+		if _, err := path.Match(mainline.Pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid mainline branch pattern %s: %v", mainline.Pattern, err)
+		}
+		mainlines = append(mainlines, mainline)
+	}
+	if len(mainlines) == 0 {
+		return nil, fmt.Errorf("no mainline branches in %q", mainlineBranches)
+	}
+	if strings.ContainsAny(mainlines[0].Pattern, `*?[\`) {
+		return nil, fmt.Errorf("first mainline branch %s must name a branch, not a pattern", mainlines[0].Pattern)
+	}
+	return mainlines, nil
 }
 
 func NewBranchOperations(managedPrefixes string) BranchOperations {
@@ -15,21 +78,63 @@ func NewBranchOperations(managedPrefixes string) BranchOperations {
 	for _, v := range t {
 		candidate := strings.TrimSpace(v)
 		if candidate == "" {
-			Log.Printf("In managed prefixes [%v], candidate has zero length.  Skipping.\n", managedPrefixes)
+			Log.WithField("managed_prefixes", managedPrefixes).Warn("Skipping empty managed branch prefix")
 			continue
 		}
 		if !strings.HasSuffix(candidate, "/") {
-			Log.Printf("In managed prefixes [%v], candidate '%s' is missing trailing /.  Skipping.", managedPrefixes, candidate)
+			Log.WithFields(logrus.Fields{"managed_prefixes": managedPrefixes, "prefix": candidate}).Warn("Skipping managed branch prefix missing trailing /")
 			continue
 		}
 		prefixes = append(prefixes, candidate)
 	}
 	if len(prefixes) == 0 {
-		Log.Printf("Managed branch prefixes length is zero.\n")
+		Log.Warn("No managed branch prefixes")
 	}
 	return BranchOperations{ManagedPrefixes: prefixes}
 }
 
+// ParseBranchRules parses a comma separated list of branch rules.  Each rule is + to include or - to exclude, followed by a
+// glob, in which * matches any run of characters including /, or by a regular expression between slashes.  For example,
+// -dependabot/*,-experiment/*,+/^(bug|hot)fix// excludes dependabot/ and experiment/ branches and includes bugfix/ and
+// hotfix/ branches.  A rule without + or - includes.
+func ParseBranchRules(rules string) ([]BranchRule, error) {
+	branchRules := make([]BranchRule, 0)
+	for _, v := range strings.Split(rules, ",") {
+		candidate := strings.TrimSpace(v)
+		if candidate == "" {
+			continue
+		}
+		rule := BranchRule{Include: true, Pattern: candidate}
+		switch candidate[0] {
+		case '-':
+			rule.Include = false
+			fallthrough
+		case '+':
+			rule.Pattern = strings.TrimSpace(candidate[1:])
+		}
+		if rule.Pattern == "" {
+			return nil, fmt.Errorf("invalid branch rule %s: no pattern", candidate)
+		}
+
+		// A glob matches the whole branch name.  A regular expression matches anywhere unless anchored.
+		expression := "^" + strings.Replace(strings.Replace(regexp.QuoteMeta(rule.Pattern), `\*`, ".*", -1), `\?`, ".", -1) + "$"
+		if len(rule.Pattern) > 1 && strings.HasPrefix(rule.Pattern, "/") && strings.HasSuffix(rule.Pattern, "/") {
+			expression = rule.Pattern[1 : len(rule.Pattern)-1]
+		}
+		re, err := regexp.Compile(expression)
+		if err != nil {
+			return nil, fmt.Errorf("invalid branch rule %s: %v", candidate, err)
+		}
+		rule.regexp = re
+		branchRules = append(branchRules, rule)
+	}
+	return branchRules, nil
+}
+
+func (r BranchRule) matches(branchName string) bool {
+	return r.regexp != nil && r.regexp.MatchString(branchName)
+}
+
 func (c BranchOperations) suffixer(branchDisplayID string) (string, string) {
 	// For a branch with Stash displayID feature/12, branchBaseName will be "feature" and branchSuffix will be "-12".
 	// For a branch with Stash displayID develop, branchBaseName will be develop and branchSuffix will be an empty string.
@@ -50,14 +155,44 @@ func (c BranchOperations) suffixer(branchDisplayID string) (string, string) {
 	return prefix, "-" + suffix
 }
 
+func (c BranchOperations) mainlines() []MainlineBranch {
+	if c.Mainlines == nil {
+		return defaultMainlines
+	}
+	return c.Mainlines
+}
+
+// mainline returns the first mainline matching branchName.
+func (c BranchOperations) mainline(branchName string) (MainlineBranch, bool) {
+	for _, mainline := range c.mainlines() {
+		if matched, _ := path.Match(mainline.Pattern, branchName); matched {
+			return mainline, true
+		}
+	}
+	return MainlineBranch{}, false
+}
+
+// releaseBranch is the branch the release job builds, the first mainline.
+func (c BranchOperations) releaseBranch() string {
+	return c.mainlines()[0].Pattern
+}
+
 func (c BranchOperations) isBranchManaged(stashBranch string) bool {
-	return stashBranch == "develop" || c.isFeatureBranch(stashBranch)
+	_, mainline := c.mainline(stashBranch)
+	return mainline || c.isFeatureBranch(stashBranch)
 }
 
+// isFeatureBranch reports whether branchName is selected by the first rule it matches or, if it matches none, has a managed
+// prefix.  Mainlines are never feature branches.
 func (c BranchOperations) isFeatureBranch(branchName string) bool {
-	if strings.Contains(branchName, "*") {
+	if _, mainline := c.mainline(branchName); mainline {
 		return false
 	}
+	for _, rule := range c.Rules {
+		if rule.matches(branchName) {
+			return rule.Include
+		}
+	}
 	for _, managedPrefix := range c.ManagedPrefixes {
 		if strings.HasPrefix(branchName, managedPrefix) {
 			return true
@@ -66,6 +201,18 @@ func (c BranchOperations) isFeatureBranch(branchName string) bool {
 	return false
 }
 
+// isStale reports whether a feature branch's latest commit, at lastCommit, is more than StaleDays old at now.  Mainlines
+// are never stale.
+func (c BranchOperations) isStale(branchName string, lastCommit, now time.Time) bool {
+	if c.StaleDays <= 0 || lastCommit.IsZero() {
+		return false
+	}
+	if _, mainline := c.mainline(branchName); mainline {
+		return false
+	}
+	return now.Sub(lastCommit) > time.Duration(c.StaleDays)*24*time.Hour
+}
+
 func (c BranchOperations) stripLeadingOrigin(branch string) string {
 	if strings.HasPrefix(branch, "origin/") {
 		return branch[len("origin/"):]
@@ -2,6 +2,7 @@ package stashkins
 
 import (
 	"testing"
+	"time"
 )
 
 func TestNewBranchOperations(t *testing.T) {
@@ -38,6 +39,88 @@ func TestBranchIsManaged(t *testing.T) {
 	}
 }
 
+func TestMainlineBranches(t *testing.T) {
+	mainlines, err := ParseMainlineBranches("main, release/*=release-snapshots")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	s := BranchOperations{ManagedPrefixes: []string{"feature/", "release/"}, Mainlines: mainlines}
+
+	if s.releaseBranch() != "main" {
+		t.Fatalf("Want main but got %s\n", s.releaseBranch())
+	}
+	for _, branch := range []string{"main", "release/1.2", "feature/1"} {
+		if !s.isBranchManaged(branch) {
+			t.Fatalf("want %s managed == true but got false\n", branch)
+		}
+	}
+	if s.isBranchManaged("develop") {
+		t.Fatalf("want develop managed == false but got true\n")
+	}
+	if s.isFeatureBranch("release/1.2") {
+		t.Fatalf("want release/1.2 feature == false but got true\n")
+	}
+	if mainline, _ := s.mainline("release/1.2"); mainline.SnapshotRepository != "release-snapshots" {
+		t.Fatalf("Want release-snapshots but got %s\n", mainline.SnapshotRepository)
+	}
+
+	for _, spec := range []string{"", "release/*", "main,=snapshots", "main=", "main,release/[="} {
+		if _, err := ParseMainlineBranches(spec); err == nil {
+			t.Fatalf("Want an error for %q\n", spec)
+		}
+	}
+}
+
+func TestBranchRules(t *testing.T) {
+	rules, err := ParseBranchRules("-dependabot/*, -experiment/*, +/^(bug|hot)fix//, release-?")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if len(rules) != 4 {
+		t.Fatalf("Want 4 but got %d\n", len(rules))
+	}
+	s := BranchOperations{ManagedPrefixes: []string{"feature/", "dependabot/"}, Rules: rules}
+
+	for _, branch := range []string{"feature/1", "bugfix/1", "hotfix/a/b", "release-1"} {
+		if !s.isFeatureBranch(branch) {
+			t.Fatalf("want %s feature == true but got false\n", branch)
+		}
+	}
+	for _, branch := range []string{"dependabot/npm/left-pad", "experiment/1", "release-10", "mybugfix/1", "develop"} {
+		if s.isFeatureBranch(branch) {
+			t.Fatalf("want %s feature == false but got true\n", branch)
+		}
+	}
+
+	for _, spec := range []string{"+", "-/(/"} {
+		if _, err := ParseBranchRules(spec); err == nil {
+			t.Fatalf("Want an error for %q\n", spec)
+		}
+	}
+}
+
+func TestIsStale(t *testing.T) {
+	now := time.Date(2020, 1, 31, 0, 0, 0, 0, time.UTC)
+	s := BranchOperations{ManagedPrefixes: []string{"feature/"}, StaleDays: 30}
+
+	if !s.isStale("feature/1", now.AddDate(0, 0, -31), now) {
+		t.Fatalf("want feature/1 stale == true but got false\n")
+	}
+	if s.isStale("feature/1", now.AddDate(0, 0, -29), now) {
+		t.Fatalf("want feature/1 stale == false but got true\n")
+	}
+	if s.isStale("develop", now.AddDate(0, 0, -31), now) {
+		t.Fatalf("want develop stale == false but got true\n")
+	}
+	if s.isStale("feature/1", time.Time{}, now) {
+		t.Fatalf("want feature/1 without a commit time stale == false but got true\n")
+	}
+	s.StaleDays = 0
+	if s.isStale("feature/1", now.AddDate(-1, 0, 0), now) {
+		t.Fatalf("want feature/1 stale == false without a filter but got true\n")
+	}
+}
+
 func TestIsFeatureBranch(t *testing.T) {
 	s := BranchOperations{ManagedPrefixes: []string{"feature/", "hotfix/"}}
 
@@ -20,7 +20,7 @@ func TestCalculateMissingJobs(t *testing.T) {
 		},
 	}
 
-	missingJobs := DefaultStashkins{}.calculateMissingCIJobs(specCIJobs, jobSummaries)
+	missingJobs := DefaultStashkins{}.calculateMissingCIJobs(specCIJobs, NewJobIndex(jobSummaries))
 	if len(missingJobs) != 1 {
 		t.Fatalf("Want 1 but got %d\n", len(missingJobs))
 	}
@@ -36,7 +36,7 @@ func TestCalculateObsoleteJobs(t *testing.T) {
 	}
 
 	skins := DefaultStashkins{}
-	oldJobs := skins.calculateObsoleteCIJobs(specCIJobs, "proj", "somelib", jobSummaries)
+	oldJobs := skins.calculateObsoleteCIJobs(specCIJobs, "proj", "somelib", NewJobIndex(jobSummaries))
 	if len(oldJobs) != 2 {
 		t.Fatalf("Want 2 but got %d\n", len(oldJobs))
 	}
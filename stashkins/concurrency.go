@@ -0,0 +1,289 @@
+package stashkins
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xoom/jenkins"
+	"github.com/xoom/maventools"
+	"github.com/xoom/stash"
+)
+
+// ConcurrencyLimits bound how many repositories are reconciled at once and how many requests may be in flight against
+// each backend.  A zero backend limit means no limit.
+type ConcurrencyLimits struct {
+	Workers int
+	Stash   int
+	Jenkins int
+	Nexus   int
+}
+
+// ErrStopped is the error for a repository that was not reconciled because the run was stopped.
+var ErrStopped = errors.New("not reconciled: stopped")
+
+// MavenRepositoryClient is the subset of the Nexus client used by MavenAspect.
+type MavenRepositoryClient interface {
+	RepositoryExists(repositoryID maventools.RepositoryID) (bool, error)
+	CreateSnapshotRepository(repositoryID maventools.RepositoryID) (int, error)
+	DeleteRepository(repositoryID maventools.RepositoryID) (int, error)
+	AddRepositoryToGroup(repositoryID maventools.RepositoryID, groupID maventools.GroupID) (int, error)
+}
+
+// A semaphore bounds concurrent access to a backend.  A nil semaphore never blocks.
+type semaphore chan struct{}
+
+func newSemaphore(n int) semaphore {
+	if n <= 0 {
+		return nil
+	}
+	return make(semaphore, n)
+}
+
+func (s semaphore) acquire() {
+	if s != nil {
+		s <- struct{}{}
+	}
+}
+
+func (s semaphore) release() {
+	if s != nil {
+		<-s
+	}
+}
+
+type limitedSCM struct {
+	SCMProvider
+	limit semaphore
+}
+
+func (l limitedSCM) Repository(projectKey, slug string) (SCMRepository, error) {
+	l.limit.acquire()
+	defer l.limit.release()
+	return l.SCMProvider.Repository(projectKey, slug)
+}
+
+func (l limitedSCM) Branches(projectKey, slug string) (map[string]stash.Branch, error) {
+	l.limit.acquire()
+	defer l.limit.release()
+	return l.SCMProvider.Branches(projectKey, slug)
+}
+
+func (l limitedSCM) BranchHeads(projectKey, slug string) (map[string]BranchHead, error) {
+	l.limit.acquire()
+	defer l.limit.release()
+	return l.SCMProvider.BranchHeads(projectKey, slug)
+}
+
+func (l limitedSCM) PullRequests(projectKey, slug string) ([]PullRequest, error) {
+	l.limit.acquire()
+	defer l.limit.release()
+	return l.SCMProvider.PullRequests(projectKey, slug)
+}
+
+func (l limitedSCM) Tags(projectKey, slug string) ([]Tag, error) {
+	l.limit.acquire()
+	defer l.limit.release()
+	return l.SCMProvider.Tags(projectKey, slug)
+}
+
+type limitedJenkins struct {
+	jenkins.Jenkins
+	limit semaphore
+}
+
+func (l limitedJenkins) CreateJob(jobName, jobConfigXML string) error {
+	l.limit.acquire()
+	defer l.limit.release()
+	return l.Jenkins.CreateJob(jobName, jobConfigXML)
+}
+
+func (l limitedJenkins) DeleteJob(jobName string) error {
+	l.limit.acquire()
+	defer l.limit.release()
+	return l.Jenkins.DeleteJob(jobName)
+}
+
+type limitedNexus struct {
+	MavenRepositoryClient
+	limit semaphore
+}
+
+func (l limitedNexus) RepositoryExists(repositoryID maventools.RepositoryID) (bool, error) {
+	l.limit.acquire()
+	defer l.limit.release()
+	return l.MavenRepositoryClient.RepositoryExists(repositoryID)
+}
+
+func (l limitedNexus) CreateSnapshotRepository(repositoryID maventools.RepositoryID) (int, error) {
+	l.limit.acquire()
+	defer l.limit.release()
+	return l.MavenRepositoryClient.CreateSnapshotRepository(repositoryID)
+}
+
+func (l limitedNexus) DeleteRepository(repositoryID maventools.RepositoryID) (int, error) {
+	l.limit.acquire()
+	defer l.limit.release()
+	return l.MavenRepositoryClient.DeleteRepository(repositoryID)
+}
+
+func (l limitedNexus) AddRepositoryToGroup(repositoryID maventools.RepositoryID, groupID maventools.GroupID) (int, error) {
+	l.limit.acquire()
+	defer l.limit.release()
+	return l.MavenRepositoryClient.AddRepositoryToGroup(repositoryID, groupID)
+}
+
+// WithConcurrencyLimits returns a copy of c whose SCM provider, Jenkins and Nexus clients admit no more than the given
+// number of concurrent requests.  The Stash limit applies to whichever SCM provider is in use.  The copy shares its limits
+// with every Aspect built from its NexusClient, and with every SCM provider later given to WithSCMProvider.
+func (c DefaultStashkins) WithConcurrencyLimits(limits ConcurrencyLimits) DefaultStashkins {
+	c.workers = limits.Workers
+	if limits.Stash > 0 {
+		c.scmLimit = newSemaphore(limits.Stash)
+		c.scm = limitedSCM{SCMProvider: c.scm, limit: c.scmLimit}
+	}
+	if limits.Jenkins > 0 {
+		c.jenkinsLimit = newSemaphore(limits.Jenkins)
+		c.jenkinsClient = limitedJenkins{Jenkins: c.jenkinsClient, limit: c.jenkinsLimit}
+	}
+	if limits.Nexus > 0 {
+		c.NexusClient = limitedNexus{MavenRepositoryClient: c.NexusClient, limit: newSemaphore(limits.Nexus)}
+	}
+	return c
+}
+
+// WithSCMProvider returns a copy of c that reads repositories and branches from p, recording requests in c's metrics and
+// within c's concurrency limits.
+func (c DefaultStashkins) WithSCMProvider(p SCMProvider) DefaultStashkins {
+	if c.metrics != nil {
+		p = instrumentedSCM{SCMProvider: p, backend: scmBackend(p), metrics: c.metrics}
+	}
+	if c.scmLimit != nil {
+		p = limitedSCM{SCMProvider: p, limit: c.scmLimit}
+	}
+	c.scm = p
+	return c
+}
+
+// WithBranchOperations returns a copy of c that manages branches according to branchOperations.
+func (c DefaultStashkins) WithBranchOperations(branchOperations BranchOperations) DefaultStashkins {
+	c.branchOperations = branchOperations
+	return c
+}
+
+// WithStop returns a copy of c that stops between repositories once stop is closed.  Repositories already being reconciled
+// are finished, and the rest are reported with ErrStopped.
+func (c DefaultStashkins) WithStop(stop <-chan struct{}) DefaultStashkins {
+	c.stop = stop
+	return c
+}
+
+// stopped reports whether the stop channel, if any, has been closed.
+func (c DefaultStashkins) stopped() bool {
+	select {
+	case <-c.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// forEachTemplate calls f for every template on a pool of worker goroutines.  done is called in template order, each call as
+// soon as f has returned for that template and all templates before it.
+func (c DefaultStashkins) forEachTemplate(jobTemplates []JobTemplate, f func(i int, jobTemplate JobTemplate), done func(i int)) {
+	workers := c.workers
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(jobTemplates) {
+		workers = len(jobTemplates)
+	}
+
+	indexes := make(chan int)
+	finished := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				f(i, jobTemplates[i])
+				finished <- i
+			}
+		}()
+	}
+
+	go func() {
+		for i := range jobTemplates {
+			indexes <- i
+		}
+		close(indexes)
+		wg.Wait()
+		close(finished)
+	}()
+
+	complete := make([]bool, len(jobTemplates))
+	next := 0
+	for i := range finished {
+		complete[i] = true
+		for next < len(complete) && complete[next] {
+			done(next)
+			next++
+		}
+	}
+}
+
+// A TemplateSetup supplies the Stashkins and Aspect with which a template is reconciled, so that settings may differ from
+// one repository to the next.  The Stashkins returned is usually derived from the one doing the reconciling.
+type TemplateSetup func(JobTemplate) (DefaultStashkins, Aspect)
+
+// ReconcileAll reconciles every template, concurrently if the worker limit allows.  setup supplies the Stashkins and Aspect
+// for each template.  Reports are returned, and a summary of each is logged, in template order regardless of completion order.
+func (c DefaultStashkins) ReconcileAll(jobIndex JobIndex, jobTemplates []JobTemplate, setup TemplateSetup) []RepositoryReport {
+	reports := make([]RepositoryReport, len(jobTemplates))
+	errs := make([]error, len(jobTemplates))
+
+	c.forEachTemplate(jobTemplates, func(i int, jobTemplate JobTemplate) {
+		if c.stopped() {
+			reports[i], errs[i] = NewRepositoryReport(jobTemplate), ErrStopped
+			reports[i].Error = ErrStopped.Error()
+			return
+		}
+		repositoryLog(jobTemplate).Info("Reconciling jobs")
+		skins, aspect := setup(jobTemplate)
+		reports[i], errs[i] = skins.ReconcileJobs(jobIndex, jobTemplate, aspect)
+	}, func(i int) {
+		r := reports[i]
+		c.metrics.ObserveReport(r)
+		log := repositoryLog(jobTemplates[i]).WithFields(logrus.Fields{
+			"done":    r.Count("", "", StatusDone),
+			"skipped": r.Count("", "", StatusSkipped),
+			"failed":  r.Count("", "", StatusFailed),
+		})
+		if errs[i] != nil {
+			log.WithError(errs[i]).Warn("Reconciled jobs with errors")
+		} else {
+			log.Info("Reconciled jobs")
+		}
+	})
+
+	return reports
+}
+
+// PlanAll plans every template, concurrently if the worker limit allows.  Plans and errors are returned in template order.
+func (c DefaultStashkins) PlanAll(jobIndex JobIndex, jobTemplates []JobTemplate, setup TemplateSetup) ([]Plan, []error) {
+	plans := make([]Plan, len(jobTemplates))
+	errs := make([]error, len(jobTemplates))
+
+	c.forEachTemplate(jobTemplates, func(i int, jobTemplate JobTemplate) {
+		if c.stopped() {
+			errs[i] = ErrStopped
+			return
+		}
+		skins, aspect := setup(jobTemplate)
+		plans[i], errs[i] = skins.PlanJobs(jobIndex, jobTemplate, aspect)
+	}, func(i int) {})
+
+	return plans, errs
+}
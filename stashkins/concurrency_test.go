@@ -0,0 +1,90 @@
+package stashkins
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestForEachTemplateOrder(t *testing.T) {
+	jobTemplates := make([]JobTemplate, 20)
+	skins := DefaultStashkins{workers: 4}
+
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	order := make([]int, 0)
+
+	skins.forEachTemplate(jobTemplates, func(i int, jobTemplate JobTemplate) {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+
+		// finish out of order
+		time.Sleep(time.Duration(len(jobTemplates)-i) * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+	}, func(i int) {
+		order = append(order, i)
+	})
+
+	if maxRunning > 4 {
+		t.Fatalf("Want at most 4 concurrent workers but got %d\n", maxRunning)
+	}
+	if len(order) != len(jobTemplates) {
+		t.Fatalf("Want %d but got %d\n", len(jobTemplates), len(order))
+	}
+	for i, v := range order {
+		if i != v {
+			t.Fatalf("Want done called in template order but got %v\n", order)
+		}
+	}
+}
+
+func TestForEachTemplateNoTemplates(t *testing.T) {
+	DefaultStashkins{workers: 4}.forEachTemplate(nil, func(i int, jobTemplate JobTemplate) {
+		t.Fatal("Unexpected call")
+	}, func(i int) {
+		t.Fatal("Unexpected call")
+	})
+}
+
+func TestSemaphore(t *testing.T) {
+	var s semaphore
+	s.acquire()
+	s.release()
+
+	s = newSemaphore(1)
+	s.acquire()
+	select {
+	case s <- struct{}{}:
+		t.Fatal("Want semaphore to be full")
+	default:
+	}
+	s.release()
+}
+
+func TestReconcileAllStopped(t *testing.T) {
+	stop := make(chan struct{})
+	close(stop)
+	skins := DefaultStashkins{workers: 2}.WithStop(stop)
+
+	jobTemplates := []JobTemplate{JobTemplate{ProjectKey: "proj", Slug: "a"}, JobTemplate{ProjectKey: "proj", Slug: "b"}}
+	reports := skins.ReconcileAll(NewJobIndex(nil), jobTemplates, func(JobTemplate) (DefaultStashkins, Aspect) {
+		t.Fatalf("Want no repository reconciled after stop\n")
+		return skins, nil
+	})
+
+	if len(reports) != 2 {
+		t.Fatalf("Want 2 but got %d\n", len(reports))
+	}
+	for i, report := range reports {
+		if report.Slug != jobTemplates[i].Slug || report.Error != ErrStopped.Error() {
+			t.Fatalf("Want %s stopped but got %+v\n", jobTemplates[i].Slug, report)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package stashkins
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Credential references let a username or password name where the secret is kept instead of holding the secret itself:
+//
+//	env:NAME      the value of environment variable NAME
+//	file:PATH     the contents of the file at PATH, such as a mounted secret, less any trailing newline
+//	exec:COMMAND  the standard output of COMMAND run by sh -c, less any trailing newline
+//
+// Any other value is taken literally.
+const (
+	envCredentialPrefix  = "env:"
+	fileCredentialPrefix = "file:"
+	execCredentialPrefix = "exec:"
+)
+
+// ResolveCredential returns the secret a credential reference refers to.
+func ResolveCredential(reference string) (string, error) {
+	switch {
+	case strings.HasPrefix(reference, envCredentialPrefix):
+		name := reference[len(envCredentialPrefix):]
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("stashkins.ResolveCredential: environment variable %s is not set", name)
+		}
+		return value, nil
+	case strings.HasPrefix(reference, fileCredentialPrefix):
+		data, err := ioutil.ReadFile(reference[len(fileCredentialPrefix):])
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case strings.HasPrefix(reference, execCredentialPrefix):
+		data, err := runCredentialCommand(reference[len(execCredentialPrefix):])
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	return reference, nil
+}
+
+// ResolveCredentials returns a copy of p with its username and password references resolved.  If either is still empty
+// and helper is set, the credential helper command is asked for both.  The helper is run by sh -c with service, one of
+// stash, jenkins or maven-repo, as its argument, and answers on standard output with username=<name> and
+// password=<secret> lines, as git credential helpers do.  Values already given take precedence over the helper's.
+func (p WebClientParams) ResolveCredentials(helper, service string) (WebClientParams, error) {
+	var err error
+	if p.UserName, err = ResolveCredential(p.UserName); err != nil {
+		return p, fmt.Errorf("cannot resolve %s username: %v", service, err)
+	}
+	if p.Password, err = ResolveCredential(p.Password); err != nil {
+		return p, fmt.Errorf("cannot resolve %s password: %v", service, err)
+	}
+
+	if helper == "" || (p.UserName != "" && p.Password != "") {
+		return p, nil
+	}
+
+	data, err := runCredentialCommand(helper + " " + service)
+	if err != nil {
+		return p, fmt.Errorf("credential helper failed for %s: %v", service, err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch parts[0] {
+		case "username":
+			if p.UserName == "" {
+				p.UserName = parts[1]
+			}
+		case "password":
+			if p.Password == "" {
+				p.Password = parts[1]
+			}
+		}
+	}
+	return p, scanner.Err()
+}
+
+// runCredentialCommand runs command and returns its standard output.  The command is not logged, as its arguments may be
+// sensitive, and its standard error is passed through so the helper can prompt or complain.
+func runCredentialCommand(command string) ([]byte, error) {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stderr = os.Stderr
+	return cmd.Output()
+}
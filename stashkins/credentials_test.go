@@ -0,0 +1,66 @@
+package stashkins
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestResolveCredential(t *testing.T) {
+	os.Setenv("STASHKINS_TEST_SECRET", "from-env")
+	defer os.Unsetenv("STASHKINS_TEST_SECRET")
+
+	f, err := ioutil.TempFile("", "stashkins-secret-")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("from-file\n")
+	f.Close()
+
+	for reference, want := range map[string]string{
+		"literal":                   "literal",
+		"env:STASHKINS_TEST_SECRET": "from-env",
+		"file:" + f.Name():          "from-file",
+		"exec:echo from-exec":       "from-exec",
+	} {
+		got, err := ResolveCredential(reference)
+		if err != nil {
+			t.Fatalf("Unexpected error resolving %s: %v\n", reference, err)
+		}
+		if got != want {
+			t.Fatalf("Want %s but got %s\n", want, got)
+		}
+	}
+
+	for _, reference := range []string{"env:STASHKINS_TEST_NO_SUCH_SECRET", "file:/no/such/file", "exec:exit 1"} {
+		if _, err := ResolveCredential(reference); err == nil {
+			t.Fatalf("Want an error resolving %s\n", reference)
+		}
+	}
+}
+
+func TestResolveCredentialsWithHelper(t *testing.T) {
+	helper := `f() { echo "username=$1-user"; echo "password=$1-secret"; }; f`
+
+	params, err := WebClientParams{URL: "http://example.com"}.ResolveCredentials(helper, "jenkins")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if params.UserName != "jenkins-user" || params.Password != "jenkins-secret" {
+		t.Fatalf("Want jenkins-user and jenkins-secret but got %s and %s\n", params.UserName, params.Password)
+	}
+
+	params, err = WebClientParams{UserName: "given"}.ResolveCredentials(helper, "stash")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if params.UserName != "given" || params.Password != "stash-secret" {
+		t.Fatalf("Want given and stash-secret but got %s and %s\n", params.UserName, params.Password)
+	}
+
+	params, err = WebClientParams{UserName: "given", Password: "secret"}.ResolveCredentials("exit 1", "stash")
+	if err != nil {
+		t.Fatalf("Want the helper not to run when credentials are given, but got %v\n", err)
+	}
+}
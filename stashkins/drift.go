@@ -0,0 +1,287 @@
+package stashkins
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// maxReportedChanges bounds the number of differences recorded per drifted job.
+const maxReportedChanges = 20
+
+// driftProbeCommit stands in for every branch's head commit to find the elements of a rendered job that depend on it.
+const driftProbeCommit = "0000000000000000000000000000000000000000"
+
+// A JobDrift records an existing job whose configuration no longer matches its rendered template.
+type JobDrift struct {
+	JobName string
+	Branch  string
+	Changes []string
+	config  []byte // the rendered configuration with which to repair the job
+}
+
+type xmlLeaf struct {
+	path  string
+	value string
+}
+
+type byPath []xmlLeaf
+
+func (p byPath) Len() int           { return len(p) }
+func (p byPath) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p byPath) Less(i, j int) bool { return p[i].path < p[j].path }
+
+// flattenXML reduces an XML document to an ordered list of element paths and their values.  Repeated sibling elements are
+// indexed.  Processing instructions, comments, whitespace-only text, and the plugin attribute Jenkins adds when it saves a
+// job are ignored.
+func flattenXML(document []byte) ([]xmlLeaf, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(xml10(document)))
+
+	leaves := make([]xmlLeaf, 0)
+	path := make([]string, 0)
+	siblings := []map[string]int{make(map[string]int)}
+	text := make([]string, 0)
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			counts := siblings[len(siblings)-1]
+			name := fmt.Sprintf("%s[%d]", t.Name.Local, counts[t.Name.Local])
+			counts[t.Name.Local]++
+
+			path = append(path, name)
+			siblings = append(siblings, make(map[string]int))
+			text = append(text, "")
+
+			attrs := make([]xmlLeaf, 0)
+			for _, a := range t.Attr {
+				if a.Name.Local == "plugin" {
+					continue
+				}
+				attrs = append(attrs, xmlLeaf{path: "/" + strings.Join(path, "/") + "@" + a.Name.Local, value: a.Value})
+			}
+			sort.Sort(byPath(attrs))
+			leaves = append(leaves, attrs...)
+		case xml.CharData:
+			if len(text) > 0 {
+				text[len(text)-1] += string(t)
+			}
+		case xml.EndElement:
+			leaves = append(leaves, xmlLeaf{path: "/" + strings.Join(path, "/"), value: strings.TrimSpace(text[len(text)-1])})
+			path = path[:len(path)-1]
+			siblings = siblings[:len(siblings)-1]
+			text = text[:len(text)-1]
+		}
+	}
+	return leaves, nil
+}
+
+// xml10 returns the document declared as XML 1.0, which newer versions of Jenkins save as XML 1.1 and which is all the
+// xml package reads.  Job configurations use nothing in which the two differ.
+func xml10(document []byte) []byte {
+	for _, declaration := range []string{`<?xml version='1.1'`, `<?xml version="1.1"`} {
+		if bytes.HasPrefix(document, []byte(declaration)) {
+			return append([]byte(`<?xml version='1.0'`), document[len(declaration):]...)
+		}
+	}
+	return document
+}
+
+// configChanges compares the current configuration of a job to its desired configuration and returns a description of each
+// difference, except at the ignored element paths.  No differences means the job has not drifted.  Jenkins adds default
+// elements and attributes when it saves a job, so those the template does not render are not differences, unless the
+// template renders fewer of a repeated element, in which case the extra ones are removed.
+func configChanges(current, desired []byte, ignored map[string]bool) ([]string, error) {
+	currentLeaves, err := flattenXML(current)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse current job configuration: %v", err)
+	}
+	desiredLeaves, err := flattenXML(desired)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse rendered job configuration: %v", err)
+	}
+
+	currentValues := make(map[string]string)
+	for _, leaf := range currentLeaves {
+		currentValues[leaf.path] = leaf.value
+	}
+	desiredValues := make(map[string]string)
+	for _, leaf := range desiredLeaves {
+		desiredValues[leaf.path] = leaf.value
+	}
+
+	changes := make([]string, 0)
+	for _, leaf := range desiredLeaves {
+		if ignored[leaf.path] {
+			continue
+		}
+		if value, present := currentValues[leaf.path]; !present {
+			changes = append(changes, fmt.Sprintf("added %s", leaf.path))
+		} else if value != leaf.value {
+			changes = append(changes, fmt.Sprintf("changed %s", leaf.path))
+		}
+	}
+	for _, leaf := range currentLeaves {
+		if ignored[leaf.path] || strings.Contains(leaf.path, "@") {
+			continue
+		}
+		if _, present := desiredValues[leaf.path]; !present && renderedSibling(leaf.path, desiredValues) {
+			changes = append(changes, fmt.Sprintf("removed %s", leaf.path))
+		}
+	}
+
+	if len(changes) > maxReportedChanges {
+		n := len(changes)
+		changes = append(changes[:maxReportedChanges], fmt.Sprintf("and %d more", n-maxReportedChanges))
+	}
+	return changes, nil
+}
+
+// renderedSibling reports whether values holds the first of the repeated elements of which the element at path is one.
+func renderedSibling(path string, values map[string]string) bool {
+	i := strings.LastIndex(path, "[")
+	if i < 0 {
+		return false
+	}
+	_, present := values[path[:i]+"[0]"]
+	return present
+}
+
+// detectDrift renders the template for every existing job in the plan and compares it to the job's current configuration.
+// A branch's head commit moves with every push, which is not drift, so elements rendered from LatestCommit are left out of
+// the comparison; a job that has drifted otherwise is repaired with the current commit.  Tag jobs are compared in full,
+// since a tag's commit does not move.  It makes only read calls to Jenkins.
+func (c DefaultStashkins) detectDrift(plan Plan, jobIndex JobIndex, jobTemplate JobTemplate, jobAspect Aspect, state scmState) []JobDrift {
+	missing := make(map[string]bool)
+	for _, job := range plan.MissingJobs {
+		missing[job.JobName] = true
+	}
+
+	// Rendering again with every head commit replaced shows which elements depend on it.
+	probeState := state
+	if state.heads != nil {
+		probeState.heads = make(map[string]BranchHead, len(state.heads))
+		for name, head := range state.heads {
+			head.Commit = driftProbeCommit
+			probeState.heads[name] = head
+		}
+	}
+
+	drifted := make([]JobDrift, 0)
+	check := func(jobName, description, branch string, data []byte) {
+		model := c.jobModel(jobAspect, jobName, description, branch, jobTemplate, state)
+		probe := c.jobModel(jobAspect, jobName, description, branch, jobTemplate, probeState)
+		c.checkDrift(&drifted, jobTemplate, jobName, branch, data, model, probe)
+	}
+
+	for _, specJob := range plan.SpecJobs {
+		if missing[specJob.JobName] {
+			continue
+		}
+		check(specJob.JobName, c.continuousJobDescription(jobTemplate, specJob.Branch), specJob.Branch.DisplayID, jobTemplate.ContinuousJobTemplate)
+	}
+
+	missingPullRequests := make(map[string]bool)
+	for _, job := range plan.MissingPullRequestJobs {
+		missingPullRequests[job.JobName] = true
+	}
+	for _, job := range plan.PullRequestJobs {
+		if missingPullRequests[job.JobName] {
+			continue
+		}
+		model := c.pullRequestModel(job, jobTemplate, state)
+		probe := c.pullRequestModel(job, jobTemplate, probeState)
+		c.checkDrift(&drifted, jobTemplate, job.JobName, job.PullRequest.SourceBranch, jobTemplate.PullRequestJobTemplate, model, probe)
+	}
+
+	missingTags := make(map[string]bool)
+	for _, job := range plan.MissingTagJobs {
+		missingTags[job.JobName] = true
+	}
+	for _, job := range plan.TagJobs {
+		if missingTags[job.JobName] {
+			continue
+		}
+		c.checkDrift(&drifted, jobTemplate, job.JobName, "", jobTemplate.TagJobTemplate, c.tagModel(job, jobTemplate, state), nil)
+	}
+
+	if plan.ReleaseJob == "" && len(jobTemplate.ReleaseJobTemplate) > 0 && !c.shouldCreateReleaseJob(jobTemplate.ProjectKey, jobTemplate.Slug, jobIndex) {
+		check(c.canonicalReleaseJobName(jobTemplate.ProjectKey, jobTemplate.Slug), c.releaseJobDescription(jobTemplate), c.branchOperations.releaseBranch(), jobTemplate.ReleaseJobTemplate)
+	}
+
+	return drifted
+}
+
+// checkDrift renders a job's template with model and, if the job's current configuration differs, appends a JobDrift to
+// drifted.  Elements that render differently with probe, a model differing only in its head commit, are not compared.  probe
+// may be nil.  Jobs that cannot be checked are logged and skipped.
+func (c DefaultStashkins) checkDrift(drifted *[]JobDrift, jobTemplate JobTemplate, jobName, branch string, data []byte, model, probe interface{}) {
+	desired, err := c.renderJob(data, jobName, model)
+	if err != nil {
+		jobLog(jobTemplate, jobName, branch).WithError(err).Warn("Cannot render template to check drift")
+		return
+	}
+	ignored := make(map[string]bool)
+	if probe != nil {
+		probed, err := c.renderJob(data, jobName, probe)
+		if err == nil {
+			ignored, err = differingPaths(desired, probed)
+		}
+		if err != nil {
+			jobLog(jobTemplate, jobName, branch).WithError(err).Warn("Cannot render template to check drift")
+			return
+		}
+	}
+	current, err := c.jobConfig(jobName)
+	if err != nil {
+		jobLog(jobTemplate, jobName, branch).WithError(err).Warn("Cannot fetch configuration to check drift")
+		return
+	}
+	changes, err := configChanges(current, desired, ignored)
+	if err != nil {
+		jobLog(jobTemplate, jobName, branch).WithError(err).Warn("Cannot compare configuration to check drift")
+		return
+	}
+	if len(changes) > 0 {
+		*drifted = append(*drifted, JobDrift{JobName: jobName, Branch: branch, Changes: changes, config: desired})
+	}
+}
+
+// differingPaths returns the element paths whose values differ between two renderings of a job, or that only one has.
+func differingPaths(a, b []byte) (map[string]bool, error) {
+	aLeaves, err := flattenXML(a)
+	if err != nil {
+		return nil, err
+	}
+	bLeaves, err := flattenXML(b)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(aLeaves))
+	for _, leaf := range aLeaves {
+		values[leaf.path] = leaf.value
+	}
+	paths := make(map[string]bool)
+	for _, leaf := range bLeaves {
+		if value, present := values[leaf.path]; !present || value != leaf.value {
+			paths[leaf.path] = true
+		}
+		delete(values, leaf.path)
+	}
+	for path := range values {
+		paths[path] = true
+	}
+	return paths, nil
+}
@@ -0,0 +1,241 @@
+package stashkins
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xoom/jenkins"
+	"github.com/xoom/stash"
+)
+
+func TestConfigChangesIgnoresJenkinsFormatting(t *testing.T) {
+	desired := []byte(`<project>
+  <description>continuous</description>
+  <scm class="hudson.plugins.git.GitSCM">
+    <branch>feature/1</branch>
+  </scm>
+</project>`)
+	current := []byte(`<?xml version='1.0' encoding='UTF-8'?>
+<project>
+  <!-- saved by Jenkins -->
+  <description>continuous</description>
+  <scm class="hudson.plugins.git.GitSCM" plugin="git@2.4.0"><branch>feature/1</branch></scm>
+</project>`)
+
+	changes, err := configChanges(current, desired, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if len(changes) != 0 {
+		t.Fatalf("Want no changes but got %+v\n", changes)
+	}
+}
+
+func TestConfigChangesIgnoresJenkinsDefaults(t *testing.T) {
+	desired := []byte(`<project>
+  <description>continuous</description>
+  <scm class="hudson.plugins.git.GitSCM">
+    <branches><hudson.plugins.git.BranchSpec><name>feature/1</name></hudson.plugins.git.BranchSpec></branches>
+  </scm>
+  <builders><hudson.tasks.Shell><command>make</command></hudson.tasks.Shell></builders>
+</project>`)
+	current := []byte(`<?xml version='1.1' encoding='UTF-8'?>
+<project>
+  <actions/>
+  <description>continuous</description>
+  <keepDependencies>false</keepDependencies>
+  <properties/>
+  <scm class="hudson.plugins.git.GitSCM" plugin="git@4.11.0">
+    <configVersion>2</configVersion>
+    <branches>
+      <hudson.plugins.git.BranchSpec>
+        <name>feature/1</name>
+      </hudson.plugins.git.BranchSpec>
+    </branches>
+    <doGenerateSubmoduleConfigurations>false</doGenerateSubmoduleConfigurations>
+    <submoduleCfg class="list"/>
+    <extensions/>
+  </scm>
+  <canRoam>true</canRoam>
+  <disabled>false</disabled>
+  <blockBuildWhenDownstreamBuilding>false</blockBuildWhenDownstreamBuilding>
+  <blockBuildWhenUpstreamBuilding>false</blockBuildWhenUpstreamBuilding>
+  <triggers/>
+  <concurrentBuild>false</concurrentBuild>
+  <builders>
+    <hudson.tasks.Shell>
+      <command>make</command>
+      <configuredLocalRules/>
+    </hudson.tasks.Shell>
+  </builders>
+  <publishers/>
+  <buildWrappers/>
+</project>`)
+
+	changes, err := configChanges(current, desired, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if len(changes) != 0 {
+		t.Fatalf("Want no changes but got %+v\n", changes)
+	}
+}
+
+func TestConfigChanges(t *testing.T) {
+	desired := []byte(`<project><description>new</description><builders><shell>a</shell></builders><publishers><mailer>x</mailer></publishers></project>`)
+	current := []byte(`<project><description>old</description><builders><shell>a</shell><shell>b</shell></builders><disabled>false</disabled></project>`)
+
+	changes, err := configChanges(current, desired, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	want := map[string]bool{
+		"changed /project[0]/description[0]":        true,
+		"added /project[0]/publishers[0]":           true,
+		"added /project[0]/publishers[0]/mailer[0]": true,
+		"removed /project[0]/builders[0]/shell[1]":  true,
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("Want %d changes but got %+v\n", len(want), changes)
+	}
+	for _, change := range changes {
+		if !want[change] {
+			t.Fatalf("Unexpected change %s\n", change)
+		}
+	}
+
+	if _, err := configChanges([]byte("<project>"), desired, nil); err == nil {
+		t.Fatal("Expecting an error for malformed current configuration")
+	}
+}
+
+func TestDetectDriftIgnoresLatestCommit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jobs-")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	defer os.RemoveAll(dir)
+
+	jobName := "proj-slug-continuous-feature-1"
+	if err := os.MkdirAll(filepath.Join(dir, jobName), 0755); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	writeConfig := func(config string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, jobName, "config.xml"), []byte(config), 0644); err != nil {
+			t.Fatalf("Unexpected error: %v\n", err)
+		}
+	}
+
+	skins := DefaultStashkins{branchOperations: NewBranchOperations("feature/"), Options: ReconcileOptions{JenkinsJobsDirectory: dir}}
+	jobTemplate := JobTemplate{ProjectKey: "proj", Slug: "slug", ContinuousJobTemplate: []byte(`<project><description>{{.BranchName}}</description><commit>{{.LatestCommit}}</commit></project>`)}
+	branch := map[string]stash.Branch{"feature/1": stash.Branch{DisplayID: "feature/1"}}
+	plan := skins.plan(NewJobIndex([]jenkins.JobSummary{jenkins.JobSummary{JobDescriptor: jenkins.JobDescriptor{Name: jobName}}}), jobTemplate, branch, nil)
+	state := scmState{heads: map[string]BranchHead{"feature/1": BranchHead{Commit: "new"}}}
+
+	writeConfig(`<project><description>feature/1</description><commit>old</commit></project>`)
+	if drifted := skins.detectDrift(plan, NewJobIndex(nil), jobTemplate, NewFreestyleAspect(), state); len(drifted) != 0 {
+		t.Fatalf("Want no drift from a new commit but got %+v\n", drifted)
+	}
+
+	writeConfig(`<project><description>edited</description><commit>old</commit></project>`)
+	drifted := skins.detectDrift(plan, NewJobIndex(nil), jobTemplate, NewFreestyleAspect(), state)
+	if len(drifted) != 1 || len(drifted[0].Changes) != 1 || drifted[0].Changes[0] != "changed /project[0]/description[0]" {
+		t.Fatalf("Want only the description changed but got %+v\n", drifted)
+	}
+	if !strings.Contains(string(drifted[0].config), "<commit>new</commit>") {
+		t.Fatalf("Want the repair to carry the new commit but got %s\n", drifted[0].config)
+	}
+}
+
+func TestJobConfigFromFilesystem(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jobs-")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.MkdirAll(filepath.Join(dir, "proj-slug-continuous-develop"), 0755); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "proj-slug-continuous-develop", "config.xml"), []byte("<project/>"), 0644); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	skins := DefaultStashkins{Options: ReconcileOptions{JenkinsJobsDirectory: dir}}
+	data, err := skins.jobConfig("proj-slug-continuous-develop")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if string(data) != "<project/>" {
+		t.Fatalf("Want <project/> but got %s\n", string(data))
+	}
+}
+
+func TestJobConfigOverHTTP(t *testing.T) {
+	var posted bool
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Basic dTpw" {
+			t.Fatalf("Want  Basic dTpw but found %s\n", r.Header.Get("Authorization"))
+		}
+		if r.URL.Path == "/crumbIssuer/api/json" {
+			http.SetCookie(w, &http.Cookie{Name: "JSESSIONID", Value: "session"})
+			w.Write([]byte(`{"crumb":"abc","crumbRequestField":"Jenkins-Crumb"}`))
+			return
+		}
+		if r.URL.Path != "/job/proj-slug-continuous-develop/config.xml" {
+			t.Fatalf("Unexpected URL path %s\n", r.URL.Path)
+		}
+		switch r.Method {
+		case "GET":
+			w.Write([]byte("<project/>"))
+		case "POST":
+			if r.Header.Get("Jenkins-Crumb") != "abc" {
+				t.Fatalf("Want crumb abc but found %s\n", r.Header.Get("Jenkins-Crumb"))
+			}
+			if cookie, err := r.Cookie("JSESSIONID"); err != nil || cookie.Value != "session" {
+				t.Fatalf("Want the crumb's session cookie but got %v, %v\n", cookie, err)
+			}
+			posted = true
+		default:
+			t.Fatalf("Unexpected method %s\n", r.Method)
+		}
+	}))
+	defer testServer.Close()
+
+	skins := DefaultStashkins{jenkinsParams: WebClientParams{URL: testServer.URL, UserName: "u", Password: "p"}}
+	data, err := skins.jobConfig("proj-slug-continuous-develop")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if string(data) != "<project/>" {
+		t.Fatalf("Want <project/> but got %s\n", string(data))
+	}
+
+	if err := skins.updateJobConfig("proj-slug-continuous-develop", []byte("<project/>")); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if !posted {
+		t.Fatal("Want configuration to be posted")
+	}
+}
+
+func TestJobConfigTimesOut(t *testing.T) {
+	release := make(chan struct{})
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer testServer.Close()
+	defer close(release)
+
+	skins := DefaultStashkins{jenkinsParams: WebClientParams{URL: testServer.URL, Timeout: 50 * time.Millisecond}}
+	if _, err := skins.jobConfig("proj-slug-continuous-develop"); err == nil {
+		t.Fatal("Want an error from a Jenkins that does not respond")
+	}
+}
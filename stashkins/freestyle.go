@@ -24,3 +24,15 @@ func (fs FreestyleAspect) PostJobCreateTasks(jobName, jobDescription, gitReposit
 func (fs FreestyleAspect) PostJobDeleteTasks(jobName, jobDescription, gitRepositoryURL string, templateRecord JobTemplate) error {
 	return nil
 }
+
+func (fs FreestyleAspect) PlanJobCreateTasks(jobName, gitRepositoryURL, branch string, templateRecord JobTemplate) []string {
+	return nil
+}
+
+func (fs FreestyleAspect) PlanJobDeleteTasks(jobName, gitRepositoryURL, branch string, templateRecord JobTemplate) []string {
+	return nil
+}
+
+func (fs FreestyleAspect) Resources(branch string, templateRecord JobTemplate) []Resource {
+	return nil
+}
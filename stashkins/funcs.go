@@ -0,0 +1,57 @@
+package stashkins
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"text/template"
+)
+
+// templateFuncs are the functions available to job templates:
+//
+//	xml           escapes text for inclusion in config.xml: {{xml .Description}}
+//	lower, upper  change case: {{lower .ProjectKey}}
+//	replace       replaces every old with new: {{replace "/" "-" .BranchName}}
+//	trim          trims leading and trailing white space
+//	trimPrefix    removes a prefix, if present: {{trimPrefix "feature/" .BranchName}}
+//	trimSuffix    removes a suffix, if present
+//	contains, hasPrefix, hasSuffix
+//	              test for a substring, prefix or suffix: {{if hasPrefix "hotfix/" .BranchName}}
+//	branchBaseName, branchSuffix
+//	              split a branch name as job names do: {{branchSuffix "feature/PROJ-999"}} is -PROJ-999
+//	md5, sha1, sha256
+//	              hex encoded digests: {{sha1 .BranchName}}
+//	default       a value, or the default if the value is empty: {{default "builds@example.com" .Parameters.notify}}
+//
+// Functions taking the value to operate on take it last, so they may end a pipeline: {{.BranchName | lower | xml}}.
+var templateFuncs = template.FuncMap{
+	"xml":        xmlEscape,
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+	"replace":    func(old, new, s string) string { return strings.Replace(s, old, new, -1) },
+	"trim":       strings.TrimSpace,
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+	"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+	"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+	"branchBaseName": func(branch string) string {
+		baseName, _ := BranchOperations{}.suffixer(branch)
+		return baseName
+	},
+	"branchSuffix": func(branch string) string {
+		_, suffix := BranchOperations{}.suffixer(branch)
+		return suffix
+	},
+	"md5":    func(s string) string { sum := md5.Sum([]byte(s)); return hex.EncodeToString(sum[:]) },
+	"sha1":   func(s string) string { sum := sha1.Sum([]byte(s)); return hex.EncodeToString(sum[:]) },
+	"sha256": func(s string) string { sum := sha256.Sum256([]byte(s)); return hex.EncodeToString(sum[:]) },
+	"default": func(d string, v interface{}) string {
+		if s, ok := v.(string); ok && s != "" {
+			return s
+		}
+		return d
+	},
+}
@@ -0,0 +1,84 @@
+package stashkins
+
+import "testing"
+
+func TestTemplateFuncs(t *testing.T) {
+	model := struct {
+		BranchName string
+		Parameters map[string]string
+	}{"feature/PROJ-999/Fix", map[string]string{"team": "platform"}}
+
+	var tests = []struct {
+		template string
+		want     string
+	}{
+		{`{{xml "a < b & c"}}`, "a &lt; b &amp; c"},
+		{`{{.BranchName | lower}}`, "feature/proj-999/fix"},
+		{`{{upper .BranchName}}`, "FEATURE/PROJ-999/FIX"},
+		{`{{replace "/" "-" .BranchName}}`, "feature-PROJ-999-Fix"},
+		{`{{trim "  x  "}}`, "x"},
+		{`{{trimPrefix "feature/" .BranchName}}`, "PROJ-999/Fix"},
+		{`{{trimSuffix "/Fix" .BranchName}}`, "feature/PROJ-999"},
+		{`{{if hasPrefix "feature/" .BranchName}}yes{{end}}`, "yes"},
+		{`{{if hasSuffix "Fix" .BranchName}}yes{{end}}`, "yes"},
+		{`{{if contains "PROJ" .BranchName}}yes{{end}}`, "yes"},
+		{`{{branchBaseName .BranchName}}`, "feature"},
+		{`{{branchSuffix .BranchName}}`, "-PROJ-999-Fix"},
+		{`{{branchSuffix "develop"}}`, ""},
+		{`{{md5 "abc"}}`, "900150983cd24fb0d6963f7d28e17f72"},
+		{`{{sha1 "abc"}}`, "a9993e364706816aba3e25717850c26c9cd0d89d"},
+		{`{{sha256 "abc"}}`, "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{`{{default "none" .Parameters.team}}`, "platform"},
+		{`{{default "none" .Parameters.notify}}`, "none"},
+	}
+	for _, test := range tests {
+		data, err := DefaultStashkins{}.renderJob([]byte(test.template), "jobName", model)
+		if err != nil {
+			t.Fatalf("Unexpected error rendering %s: %v\n", test.template, err)
+		}
+		if string(data) != test.want {
+			t.Fatalf("Want %s from %s but got %s\n", test.want, test.template, string(data))
+		}
+	}
+}
+
+func TestParseParameters(t *testing.T) {
+	parameters, err := ParseParameters(" team=platform, notify = builds@example.com,empty=,,")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	want := map[string]string{"team": "platform", "notify": "builds@example.com", "empty": ""}
+	if len(parameters) != len(want) {
+		t.Fatalf("Want %v but got %v\n", want, parameters)
+	}
+	for name, value := range want {
+		if parameters[name] != value {
+			t.Fatalf("Want %s=%s but got %s\n", name, value, parameters[name])
+		}
+	}
+
+	for _, spec := range []string{"team", "=platform"} {
+		if _, err := ParseParameters(spec); err == nil {
+			t.Fatalf("Want an error for %s\n", spec)
+		}
+	}
+}
+
+func TestJobModel(t *testing.T) {
+	skins := DefaultStashkins{Options: ReconcileOptions{Parameters: map[string]string{"team": "platform"}}}
+	state := scmState{
+		repository: SCMRepository{CloneURL: "ssh://git@example.com/proj/slug.git", HTTPCloneURL: "https://example.com/scm/proj/slug.git"},
+		heads:      map[string]BranchHead{"feature/PROJ-999": {Commit: "abc123"}},
+	}
+	jobTemplate := JobTemplate{ProjectKey: "proj", Slug: "slug"}
+
+	model := skins.jobModel(FreestyleAspect{}, "jobName", "description", "feature/PROJ-999", jobTemplate, state)
+	data, err := skins.renderJob([]byte("{{.ProjectKey}} {{.Slug}} {{.BranchBaseName}}{{.BranchSuffix}} {{.HTTPRepositoryURL}} {{.LatestCommit}} {{.Parameters.team}} {{.RepositoryURL}}"), "jobName", model)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	want := "proj slug feature-PROJ-999 https://example.com/scm/proj/slug.git abc123 platform ssh://git@example.com/proj/slug.git"
+	if string(data) != want {
+		t.Fatalf("Want %s but got %s\n", want, string(data))
+	}
+}
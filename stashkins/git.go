@@ -1,46 +1,243 @@
 package stashkins
 
 import (
+	"encoding/hex"
+	"fmt"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	git "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/cache"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/client"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/server"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/ssh"
+	"gopkg.in/src-d/go-git.v4/storage/filesystem"
 )
 
-// Clone the repository with native git and checkout the given branch to the given directory.
-func cloneTemplates(repositoryURL, branch, dir string) error {
-	if exists, err := dirExists(dir + "/.git"); err == nil && !exists {
-		return clone(repositoryURL, branch, dir)
-	} else {
-		if err != nil {
+// GitAuth holds the credentials with which the template repository is fetched.  Which apply depends on the repository URL's
+// protocol.
+type GitAuth struct {
+	// Username and Password authenticate http and https URLs.  Password may be an access token.
+	Username string
+	Password string
+
+	// SSHKeyFile is a private key authenticating ssh URLs, decrypted with SSHKeyPassphrase if encrypted.  Without it the
+	// keys of the running ssh-agent are used.
+	SSHKeyFile       string
+	SSHKeyPassphrase string
+}
+
+func init() {
+	// go-git runs git-upload-pack to read file:// repositories.  Read them in process, so that no git executable is needed.
+	client.InstallProtocol("file", server.NewClient(localLoader{}))
+}
+
+// localLoader loads the repository at a file:// endpoint, bare or with a working tree.
+type localLoader struct{}
+
+func (localLoader) Load(ep *transport.Endpoint) (storer.Storer, error) {
+	for _, dir := range []string{filepath.Join(ep.Path, git.GitDirName), ep.Path} {
+		if _, err := os.Stat(filepath.Join(dir, "HEAD")); err == nil {
+			return filesystem.NewStorage(osfs.New(dir), cache.NewObjectLRUDefault()), nil
+		}
+	}
+	return nil, transport.ErrRepositoryNotFound
+}
+
+// Clone the repository and checkout the given branch, or ref if set, to the given directory.  If the directory already
+// holds a full clone, it is updated instead.  A depth greater than zero limits the history fetched to that many commits.
+func cloneTemplates(repositoryURL, branch, ref string, depth int, auth GitAuth, dir string) error {
+	method, err := auth.method(repositoryURL)
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(repositoryURL, "file://") {
+		// The in process server cannot make shallow packs, and a local repository costs nothing to clone in full.
+		depth = 0
+	}
+
+	exists, err := dirExists(filepath.Join(dir, git.GitDirName))
+	if err != nil {
+		return err
+	}
+	if exists && depth > 0 {
+		// go-git cannot fetch into a shallow clone, since it looks for history the clone does not have, but a shallow clone
+		// costs no more to make again than to update.
+		if err := os.RemoveAll(dir); err != nil {
 			return err
 		}
+		exists = false
+	}
+	if !exists {
+		if ref != "" {
+			return cloneRef(repositoryURL, ref, depth, method, dir)
+		}
+		return clone(repositoryURL, branch, depth, method, dir)
+	}
+	if ref != "" {
+		return checkoutRef(ref, depth, method, dir)
+	}
+	return pull(branch, depth, method, dir)
+}
+
+func clone(repositoryURL, branch string, depth int, auth transport.AuthMethod, dir string) error {
+	Log.WithField("url", repositoryURL).WithField("branch", branch).Debug("Cloning template repository")
+	_, err := git.PlainClone(dir, false, &git.CloneOptions{
+		URL:           repositoryURL,
+		Auth:          auth,
+		ReferenceName: plumbing.NewBranchReferenceName(branch),
+		SingleBranch:  true,
+		Depth:         depth,
+	})
+	if err != nil {
+		return fmt.Errorf("stashkins.clone %s branch %s: %v", repositoryURL, branch, err)
 	}
-	return pull(dir)
+	return nil
 }
 
-func clone(repositoryURL, branch, dir string) error {
-	return executeShellCommand("git", []string{"clone", "--branch", branch, repositoryURL, dir})
+// cloneRef clones the repository's branches and tags and checks out a tag or commit.  With a depth, a commit must be within
+// that many commits of a branch or tag to be found.
+func cloneRef(repositoryURL, ref string, depth int, auth transport.AuthMethod, dir string) error {
+	Log.WithField("url", repositoryURL).WithField("ref", ref).Debug("Cloning template repository")
+	repo, err := git.PlainClone(dir, false, &git.CloneOptions{
+		URL:        repositoryURL,
+		Auth:       auth,
+		NoCheckout: true,
+		Depth:      depth,
+		Tags:       git.AllTags,
+	})
+	if err != nil {
+		return fmt.Errorf("stashkins.cloneRef %s: %v", repositoryURL, err)
+	}
+	return checkout(repo, ref)
 }
 
-func pull(dir string) error {
-	err := os.Chdir(dir)
+// checkoutRef fetches the clone's branches and tags, in case ref is new, and checks ref out.
+func checkoutRef(ref string, depth int, auth transport.AuthMethod, dir string) error {
+	repo, err := git.PlainOpen(dir)
 	if err != nil {
 		return err
 	}
-	return executeShellCommand("git", []string{"pull"})
+	err = repo.Fetch(&git.FetchOptions{Auth: auth, Depth: depth, Tags: git.AllTags, Force: true})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return fmt.Errorf("stashkins.checkoutRef fetch: %v", err)
+	}
+	return checkout(repo, ref)
 }
 
-func executeShellCommand(commandName string, args []string) error {
-	Log.Printf("Executing %s %+v\n", commandName, args)
-	command := exec.Command(commandName, args...)
-	var stdOutErr []byte
-	var err error
-	stdOutErr, err = command.CombinedOutput()
+// pull brings the clone's branch up to date with the remote, replacing it should the remote branch have been rewritten.
+// go-git's own pull refuses any update that is not a fast-forward, so the branch is fetched and the clone reset to it.
+func pull(branch string, depth int, auth transport.AuthMethod, dir string) error {
+	repo, err := git.PlainOpen(dir)
 	if err != nil {
 		return err
 	}
-	Log.Printf("%v\n", string(stdOutErr))
+	remoteBranch := plumbing.NewRemoteReferenceName(git.DefaultRemoteName, branch)
+	err = repo.Fetch(&git.FetchOptions{
+		Auth:     auth,
+		RefSpecs: []config.RefSpec{config.RefSpec(fmt.Sprintf("+%s:%s", plumbing.NewBranchReferenceName(branch), remoteBranch))},
+		Depth:    depth,
+		Force:    true,
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return fmt.Errorf("stashkins.pull branch %s: %v", branch, err)
+	}
 
-	return nil
+	remote, err := repo.Reference(remoteBranch, true)
+	if err != nil {
+		return fmt.Errorf("stashkins.pull branch %s: %v", branch, err)
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	return worktree.Reset(&git.ResetOptions{Commit: remote.Hash(), Mode: git.HardReset})
+}
+
+// checkout detaches the worktree at ref, discarding any changes to it.
+func checkout(repo *git.Repository, ref string) error {
+	hash, err := resolveRef(repo, ref)
+	if err != nil {
+		return err
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	Log.WithField("ref", ref).WithField("commit", hash.String()).Debug("Checking out template repository")
+	return worktree.Checkout(&git.CheckoutOptions{Hash: hash, Force: true})
+}
+
+// resolveRef returns the commit a branch, tag or commit, full or abbreviated, names.
+func resolveRef(repo *git.Repository, ref string) (plumbing.Hash, error) {
+	if hash, err := repo.ResolveRevision(plumbing.Revision(ref)); err == nil {
+		return *hash, nil
+	}
+	if hash, err := repo.ResolveRevision(plumbing.Revision("refs/remotes/" + git.DefaultRemoteName + "/" + ref)); err == nil {
+		return *hash, nil
+	}
+
+	ref = strings.ToLower(ref)
+	if _, err := hex.DecodeString(ref + strings.Repeat("0", len(ref)%2)); err != nil || len(ref) < 4 {
+		return plumbing.ZeroHash, fmt.Errorf("stashkins.resolveRef %s: %v", ref, plumbing.ErrReferenceNotFound)
+	}
+	commits, err := repo.CommitObjects()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	var found []plumbing.Hash
+	err = commits.ForEach(func(c *object.Commit) error {
+		if strings.HasPrefix(c.Hash.String(), ref) {
+			found = append(found, c.Hash)
+		}
+		return nil
+	})
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	switch len(found) {
+	case 0:
+		return plumbing.ZeroHash, fmt.Errorf("stashkins.resolveRef %s: %v", ref, plumbing.ErrReferenceNotFound)
+	case 1:
+		return found[0], nil
+	}
+	return plumbing.ZeroHash, fmt.Errorf("stashkins.resolveRef %s is ambiguous", ref)
+}
+
+// method returns the go-git authentication for repositoryURL, or nil if go-git's default is to be used:  none for http
+// and https, and the ssh-agent for ssh.
+func (a GitAuth) method(repositoryURL string) (transport.AuthMethod, error) {
+	endpoint, err := transport.NewEndpoint(repositoryURL)
+	if err != nil {
+		return nil, err
+	}
+	switch endpoint.Protocol {
+	case "http", "https":
+		if a.Username != "" || a.Password != "" {
+			return &http.BasicAuth{Username: a.Username, Password: a.Password}, nil
+		}
+	case "ssh":
+		if a.SSHKeyFile != "" {
+			user := endpoint.User
+			if user == "" {
+				user = "git"
+			}
+			keys, err := ssh.NewPublicKeysFromFile(user, a.SSHKeyFile, a.SSHKeyPassphrase)
+			if err != nil {
+				return nil, fmt.Errorf("stashkins.GitAuth cannot read ssh key %s: %v", a.SSHKeyFile, err)
+			}
+			return keys, nil
+		}
+	}
+	return nil, nil
 }
 
 func dirExists(dirPath string) (bool, error) {
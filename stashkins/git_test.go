@@ -0,0 +1,236 @@
+package stashkins
+
+import (
+	"io/ioutil"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	git "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+)
+
+func TestGitTemplateSource(t *testing.T) {
+	sourceDir, err := ioutil.TempDir("", "git-source-")
+	if err != nil {
+		t.Fatalf("Unexpected error creating temp dir: %v\n", err)
+	}
+	defer os.RemoveAll(sourceDir)
+
+	repo, err := git.PlainInit(sourceDir, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	commit := func(description string) string {
+		if err := ioutil.WriteFile(filepath.Join(sourceDir, "continuous-template.xml"), []byte(description), 0644); err != nil {
+			t.Fatalf("Unexpected error: %v\n", err)
+		}
+		if _, err := worktree.Add("continuous-template.xml"); err != nil {
+			t.Fatalf("Unexpected error: %v\n", err)
+		}
+		hash, err := worktree.Commit(description, &git.CommitOptions{Author: &object.Signature{Name: "a", Email: "a@example.com", When: time.Now()}})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v\n", err)
+		}
+		return hash.String()
+	}
+	template := func(dir string) string {
+		data, err := ioutil.ReadFile(filepath.Join(dir, "continuous-template.xml"))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v\n", err)
+		}
+		return string(data)
+	}
+
+	first := commit("first")
+	if _, err := repo.CreateTag("v1", plumbing.NewHash(first), nil); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	second := commit("second")
+
+	workDir, err := ioutil.TempDir("", "git-clone-")
+	if err != nil {
+		t.Fatalf("Unexpected error creating temp dir: %v\n", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	// follow the branch, then pull a new commit
+	following := GitTemplateSource{URL: "file://" + sourceDir, Branch: "master", Depth: 1}
+	branchDir := filepath.Join(workDir, "branch")
+	if _, err := following.Fetch(branchDir); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if got := template(branchDir); got != "second" {
+		t.Fatalf("Want second but got %s\n", got)
+	}
+	commit("third")
+	if _, err := following.Fetch(branchDir); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if got := template(branchDir); got != "third" {
+		t.Fatalf("Want third but got %s\n", got)
+	}
+
+	// pin a tag, then an abbreviated commit in the same clone
+	pinnedDir := filepath.Join(workDir, "pinned")
+	if _, err := (GitTemplateSource{URL: "file://" + sourceDir, Branch: "master", Ref: "v1"}).Fetch(pinnedDir); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if got := template(pinnedDir); got != "first" {
+		t.Fatalf("Want first but got %s\n", got)
+	}
+	if _, err := (GitTemplateSource{URL: "file://" + sourceDir, Branch: "master", Ref: second[:8]}).Fetch(pinnedDir); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if got := template(pinnedDir); got != "second" {
+		t.Fatalf("Want second but got %s\n", got)
+	}
+	if _, err := (GitTemplateSource{URL: "file://" + sourceDir, Branch: "master", Ref: first}).Fetch(pinnedDir); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if got := template(pinnedDir); got != "first" {
+		t.Fatalf("Want first but got %s\n", got)
+	}
+
+	if _, err := (GitTemplateSource{URL: "file://" + sourceDir, Branch: "master", Ref: "v9"}).Fetch(filepath.Join(workDir, "missing")); err == nil {
+		t.Fatalf("Expected error for a ref that does not exist\n")
+	}
+
+	// rewrite the branch, as a force push would, then pull the rewritten branch
+	if err := worktree.Reset(&git.ResetOptions{Commit: plumbing.NewHash(first), Mode: git.HardReset}); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	commit("rewritten")
+	if _, err := following.Fetch(branchDir); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if got := template(branchDir); got != "rewritten" {
+		t.Fatalf("Want rewritten but got %s\n", got)
+	}
+}
+
+// TestGitTemplateSourceShallow follows a branch with a shallow clone served by git http-backend, since the in process
+// file:// server cannot make shallow packs.  Each fetch makes the shallow clone again.
+func TestGitTemplateSourceShallow(t *testing.T) {
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git is not installed")
+	}
+
+	rootDir, err := ioutil.TempDir("", "git-http-")
+	if err != nil {
+		t.Fatalf("Unexpected error creating temp dir: %v\n", err)
+	}
+	defer os.RemoveAll(rootDir)
+
+	sourceDir := filepath.Join(rootDir, "templates")
+	repo, err := git.PlainInit(sourceDir, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	commit := func(description string) plumbing.Hash {
+		if err := ioutil.WriteFile(filepath.Join(sourceDir, "continuous-template.xml"), []byte(description), 0644); err != nil {
+			t.Fatalf("Unexpected error: %v\n", err)
+		}
+		if _, err := worktree.Add("continuous-template.xml"); err != nil {
+			t.Fatalf("Unexpected error: %v\n", err)
+		}
+		hash, err := worktree.Commit(description, &git.CommitOptions{Author: &object.Signature{Name: "a", Email: "a@example.com", When: time.Now()}})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v\n", err)
+		}
+		return hash
+	}
+	first := commit("first")
+	commit("second")
+
+	server := httptest.NewServer(&cgi.Handler{
+		Path: gitPath,
+		Args: []string{"http-backend"},
+		Env:  []string{"GIT_PROJECT_ROOT=" + rootDir, "GIT_HTTP_EXPORT_ALL=1"},
+	})
+	defer server.Close()
+
+	workDir, err := ioutil.TempDir("", "git-clone-")
+	if err != nil {
+		t.Fatalf("Unexpected error creating temp dir: %v\n", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	following := GitTemplateSource{URL: server.URL + "/templates", Branch: "master", Depth: 1}
+	for _, want := range []string{"second", "third", "rewritten"} {
+		switch want {
+		case "third":
+			commit("third")
+		case "rewritten":
+			// rewrite the branch, as a force push would
+			if err := worktree.Reset(&git.ResetOptions{Commit: first, Mode: git.HardReset}); err != nil {
+				t.Fatalf("Unexpected error: %v\n", err)
+			}
+			commit("rewritten")
+		}
+		dir, err := following.Fetch(workDir)
+		if err != nil {
+			t.Fatalf("Unexpected error fetching %s: %v\n", want, err)
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, "continuous-template.xml"))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v\n", err)
+		}
+		if string(data) != want {
+			t.Fatalf("Want %s but got %s\n", want, string(data))
+		}
+	}
+
+	clone, err := git.PlainOpen(workDir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if _, err := clone.CommitObject(first); err == nil {
+		t.Fatalf("Want a shallow clone without the first commit\n")
+	}
+}
+
+func TestGitAuthMethod(t *testing.T) {
+	method, err := GitAuth{Username: "u", Password: "p"}.method("https://stash.example.com/scm/ci/templates.git")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if basic, ok := method.(*http.BasicAuth); !ok || basic.Username != "u" || basic.Password != "p" {
+		t.Fatalf("Want basic auth u:p but got %#v\n", method)
+	}
+
+	method, err = GitAuth{Username: "u", Password: "p"}.method("ssh://git@stash.example.com:7999/ci/templates.git")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if method != nil {
+		t.Fatalf("Want the ssh-agent default but got %#v\n", method)
+	}
+
+	if _, err := (GitAuth{SSHKeyFile: "/no/such/key"}).method("ssh://git@stash.example.com:7999/ci/templates.git"); err == nil {
+		t.Fatalf("Expected error for a missing ssh key\n")
+	}
+
+	method, err = GitAuth{}.method("https://stash.example.com/scm/ci/templates.git")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if method != nil {
+		t.Fatalf("Want no authentication but got %#v\n", method)
+	}
+}
@@ -0,0 +1,130 @@
+package stashkins
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/xoom/stash"
+)
+
+// giteaPageLimit is the number of branches, pull requests or tags requested per page.  Gitea caps pages at its configured
+// maximum, MAX_RESPONSE_ITEMS, which is 50 by default but may be less.
+const giteaPageLimit = 50
+
+// giteaProvider reads repositories over the Gitea REST API v1.  Pages are followed as long as the Link or X-Total-Count
+// headers say more remain, or, from older Gitea releases that send neither, until an empty page.
+type giteaProvider struct {
+	api           restClient
+	cloneProtocol string
+}
+
+type giteaBranch struct {
+	Name   string `json:"name"`
+	Commit struct {
+		ID        string    `json:"id"`
+		Timestamp time.Time `json:"timestamp"`
+	} `json:"commit"`
+}
+
+func (g giteaProvider) Repository(projectKey, slug string) (SCMRepository, error) {
+	var repository gitHubRepository
+	if _, err := g.api.get("/api/v1/repos/"+pathEscape(projectKey, slug), &repository); err != nil {
+		return SCMRepository{}, err
+	}
+	cloneURL := repository.SSHURL
+	if g.cloneProtocol == CloneHTTPS {
+		cloneURL = repository.CloneURL
+	}
+	return SCMRepository{ProjectKey: projectKey, Slug: slug, CloneURL: cloneURL, HTTPCloneURL: repository.CloneURL}, nil
+}
+
+func (g giteaProvider) Branches(projectKey, slug string) (map[string]stash.Branch, error) {
+	values, err := g.branches(projectKey, slug)
+	if err != nil {
+		return nil, err
+	}
+	branches := make(map[string]stash.Branch)
+	for _, branch := range values {
+		b := branchFromName(branch.Name)
+		branches[b.DisplayID] = b
+	}
+	return branches, nil
+}
+
+func (g giteaProvider) BranchHeads(projectKey, slug string) (map[string]BranchHead, error) {
+	values, err := g.branches(projectKey, slug)
+	if err != nil {
+		return nil, err
+	}
+	heads := make(map[string]BranchHead)
+	for _, branch := range values {
+		heads[branch.Name] = BranchHead{Commit: branch.Commit.ID, Time: branch.Commit.Timestamp}
+	}
+	return heads, nil
+}
+
+func (g giteaProvider) branches(projectKey, slug string) ([]giteaBranch, error) {
+	branches := make([]giteaBranch, 0)
+	for page := 1; ; page++ {
+		var values []giteaBranch
+		header, err := g.api.get(fmt.Sprintf("/api/v1/repos/%s/branches?page=%d&limit=%d", pathEscape(projectKey, slug), page, giteaPageLimit), &values)
+		if err != nil {
+			return nil, err
+		}
+		branches = append(branches, values...)
+		if !giteaMorePages(header, len(values), len(branches)) {
+			return branches, nil
+		}
+	}
+}
+
+func (g giteaProvider) PullRequests(projectKey, slug string) ([]PullRequest, error) {
+	pullRequests := make([]PullRequest, 0)
+	for page := 1; ; page++ {
+		var values []gitHubPullRequest
+		header, err := g.api.get(fmt.Sprintf("/api/v1/repos/%s/pulls?state=open&page=%d&limit=%d", pathEscape(projectKey, slug), page, giteaPageLimit), &values)
+		if err != nil {
+			return nil, err
+		}
+		for _, pr := range values {
+			pullRequests = append(pullRequests, pr.pullRequest("refs/pull/%d/head"))
+		}
+		if !giteaMorePages(header, len(values), len(pullRequests)) {
+			return pullRequests, nil
+		}
+	}
+}
+
+func (g giteaProvider) Tags(projectKey, slug string) ([]Tag, error) {
+	tags := make([]Tag, 0)
+	for page := 1; ; page++ {
+		var values []gitHubTag
+		header, err := g.api.get(fmt.Sprintf("/api/v1/repos/%s/tags?page=%d&limit=%d", pathEscape(projectKey, slug), page, giteaPageLimit), &values)
+		if err != nil {
+			return nil, err
+		}
+		for _, tag := range values {
+			tags = append(tags, Tag{Name: tag.Name, Commit: tag.Commit.SHA})
+		}
+		if !giteaMorePages(header, len(values), len(tags)) {
+			return tags, nil
+		}
+	}
+}
+
+// giteaMorePages reports whether a list has pages after one holding count items, with fetched items read so far.  A short
+// page is not the last, since Gitea may cap pages below giteaPageLimit.
+func giteaMorePages(header http.Header, count, fetched int) bool {
+	if count == 0 {
+		return false
+	}
+	if header.Get("Link") != "" {
+		return nextLink(header) != ""
+	}
+	if total, err := strconv.Atoi(header.Get("X-Total-Count")); err == nil {
+		return fetched < total
+	}
+	return true
+}
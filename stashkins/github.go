@@ -0,0 +1,204 @@
+package stashkins
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/xoom/stash"
+)
+
+// gitHubProvider reads repositories over the GitHub REST API v3, at https://api.github.com or a GitHub Enterprise
+// https://<host>/api/v3, following branch pages by their Link headers.  Branch heads come from the GraphQL API beside it,
+// which gives a page of branches with their commit dates in one request.
+type gitHubProvider struct {
+	api           restClient
+	cloneProtocol string
+}
+
+// gitHubRepository is also the shape of a Gitea repository.
+type gitHubRepository struct {
+	SSHURL   string `json:"ssh_url"`
+	CloneURL string `json:"clone_url"`
+}
+
+type gitHubBranch struct {
+	Name   string `json:"name"`
+	Commit struct {
+		SHA string `json:"sha"`
+	} `json:"commit"`
+}
+
+// gitHubBranchHeadsQuery lists a page of branches with their head commits.
+const gitHubBranchHeadsQuery = `query($owner: String!, $name: String!, $after: String) {
+  repository(owner: $owner, name: $name) {
+    refs(refPrefix: "refs/heads/", first: 100, after: $after) {
+      nodes { name target { oid ... on Commit { committedDate } } }
+      pageInfo { hasNextPage endCursor }
+    }
+  }
+}`
+
+type gitHubBranchHeads struct {
+	Data struct {
+		Repository *struct {
+			Refs struct {
+				Nodes []struct {
+					Name   string `json:"name"`
+					Target struct {
+						OID           string    `json:"oid"`
+						CommittedDate time.Time `json:"committedDate"`
+					} `json:"target"`
+				} `json:"nodes"`
+				PageInfo struct {
+					HasNextPage bool   `json:"hasNextPage"`
+					EndCursor   string `json:"endCursor"`
+				} `json:"pageInfo"`
+			} `json:"refs"`
+		} `json:"repository"`
+	} `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// gitHubPullRequest is also the shape of a Gitea pull request.
+type gitHubPullRequest struct {
+	Number int    `json:"number"`
+	Title  string `json:"title"`
+	User   struct {
+		Login string `json:"login"`
+	} `json:"user"`
+	Head struct {
+		Ref string `json:"ref"`
+	} `json:"head"`
+	Base struct {
+		Ref string `json:"ref"`
+	} `json:"base"`
+}
+
+// gitHubTag is also the shape of a Gitea tag.
+type gitHubTag struct {
+	Name   string `json:"name"`
+	Commit struct {
+		SHA string `json:"sha"`
+	} `json:"commit"`
+}
+
+func (pr gitHubPullRequest) pullRequest(mergeRef string) PullRequest {
+	return PullRequest{
+		ID:           pr.Number,
+		Title:        pr.Title,
+		Author:       pr.User.Login,
+		SourceBranch: pr.Head.Ref,
+		TargetBranch: pr.Base.Ref,
+		MergeRef:     fmt.Sprintf(mergeRef, pr.Number),
+	}
+}
+
+func (g gitHubProvider) Repository(projectKey, slug string) (SCMRepository, error) {
+	var repository gitHubRepository
+	if _, err := g.api.get("/repos/"+pathEscape(projectKey, slug), &repository); err != nil {
+		return SCMRepository{}, err
+	}
+	cloneURL := repository.SSHURL
+	if g.cloneProtocol == CloneHTTPS {
+		cloneURL = repository.CloneURL
+	}
+	return SCMRepository{ProjectKey: projectKey, Slug: slug, CloneURL: cloneURL, HTTPCloneURL: repository.CloneURL}, nil
+}
+
+func (g gitHubProvider) Branches(projectKey, slug string) (map[string]stash.Branch, error) {
+	values, err := g.branches(projectKey, slug)
+	if err != nil {
+		return nil, err
+	}
+	branches := make(map[string]stash.Branch)
+	for _, branch := range values {
+		b := branchFromName(branch.Name)
+		branches[b.DisplayID] = b
+	}
+	return branches, nil
+}
+
+// BranchHeads pages through branches over the GraphQL API, since REST branch lists carry no dates and would take a request
+// per branch to give them.
+func (g gitHubProvider) BranchHeads(projectKey, slug string) (map[string]BranchHead, error) {
+	heads := make(map[string]BranchHead)
+	variables := map[string]interface{}{"owner": projectKey, "name": slug}
+	for {
+		var page gitHubBranchHeads
+		if _, err := g.api.post(g.graphQLPath(), map[string]interface{}{"query": gitHubBranchHeadsQuery, "variables": variables}, &page); err != nil {
+			return nil, err
+		}
+		if len(page.Errors) > 0 {
+			return nil, fmt.Errorf("stashkins.gitHubProvider cannot list branch heads for %s/%s: %s", projectKey, slug, page.Errors[0].Message)
+		}
+		if page.Data.Repository == nil {
+			return nil, fmt.Errorf("stashkins.gitHubProvider cannot find repository %s/%s", projectKey, slug)
+		}
+		refs := page.Data.Repository.Refs
+		for _, ref := range refs.Nodes {
+			heads[ref.Name] = BranchHead{Commit: ref.Target.OID, Time: ref.Target.CommittedDate}
+		}
+		if !refs.PageInfo.HasNextPage {
+			return heads, nil
+		}
+		variables["after"] = refs.PageInfo.EndCursor
+	}
+}
+
+// graphQLPath returns the GraphQL endpoint beside the REST API, https://api.github.com/graphql or for GitHub Enterprise
+// https://<host>/api/graphql.
+func (g gitHubProvider) graphQLPath() string {
+	if strings.HasSuffix(g.api.baseURL, "/api/v3") {
+		return strings.TrimSuffix(g.api.baseURL, "/v3") + "/graphql"
+	}
+	return g.api.baseURL + "/graphql"
+}
+
+func (g gitHubProvider) branches(projectKey, slug string) ([]gitHubBranch, error) {
+	branches := make([]gitHubBranch, 0)
+	for path := "/repos/" + pathEscape(projectKey, slug) + "/branches?per_page=100"; path != ""; {
+		var page []gitHubBranch
+		header, err := g.api.get(path, &page)
+		if err != nil {
+			return nil, err
+		}
+		branches = append(branches, page...)
+		path = nextLink(header)
+	}
+	return branches, nil
+}
+
+func (g gitHubProvider) PullRequests(projectKey, slug string) ([]PullRequest, error) {
+	pullRequests := make([]PullRequest, 0)
+	for path := "/repos/" + pathEscape(projectKey, slug) + "/pulls?state=open&per_page=100"; path != ""; {
+		var page []gitHubPullRequest
+		header, err := g.api.get(path, &page)
+		if err != nil {
+			return nil, err
+		}
+		for _, pr := range page {
+			pullRequests = append(pullRequests, pr.pullRequest("refs/pull/%d/merge"))
+		}
+		path = nextLink(header)
+	}
+	return pullRequests, nil
+}
+
+func (g gitHubProvider) Tags(projectKey, slug string) ([]Tag, error) {
+	tags := make([]Tag, 0)
+	for path := "/repos/" + pathEscape(projectKey, slug) + "/tags?per_page=100"; path != ""; {
+		var page []gitHubTag
+		header, err := g.api.get(path, &page)
+		if err != nil {
+			return nil, err
+		}
+		for _, tag := range page {
+			tags = append(tags, Tag{Name: tag.Name, Commit: tag.Commit.SHA})
+		}
+		path = nextLink(header)
+	}
+	return tags, nil
+}
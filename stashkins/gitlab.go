@@ -0,0 +1,142 @@
+package stashkins
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/xoom/stash"
+)
+
+// gitLabProvider reads projects over the GitLab REST API v4, following branch pages by their X-Next-Page headers.  The
+// project key is the group path, which may name a subgroup, as in platform/services.
+type gitLabProvider struct {
+	api           restClient
+	cloneProtocol string
+}
+
+type gitLabProject struct {
+	SSHURL  string `json:"ssh_url_to_repo"`
+	HTTPURL string `json:"http_url_to_repo"`
+}
+
+type gitLabBranch struct {
+	Name   string `json:"name"`
+	Commit struct {
+		ID            string    `json:"id"`
+		CommittedDate time.Time `json:"committed_date"`
+	} `json:"commit"`
+}
+
+type gitLabMergeRequest struct {
+	IID    int    `json:"iid"`
+	Title  string `json:"title"`
+	Author struct {
+		Username string `json:"username"`
+	} `json:"author"`
+	SourceBranch string `json:"source_branch"`
+	TargetBranch string `json:"target_branch"`
+}
+
+type gitLabTag struct {
+	Name   string `json:"name"`
+	Commit struct {
+		ID string `json:"id"`
+	} `json:"commit"`
+}
+
+func (g gitLabProvider) Repository(projectKey, slug string) (SCMRepository, error) {
+	var project gitLabProject
+	if _, err := g.api.get("/api/v4/projects/"+g.projectID(projectKey, slug), &project); err != nil {
+		return SCMRepository{}, err
+	}
+	cloneURL := project.SSHURL
+	if g.cloneProtocol == CloneHTTPS {
+		cloneURL = project.HTTPURL
+	}
+	return SCMRepository{ProjectKey: projectKey, Slug: slug, CloneURL: cloneURL, HTTPCloneURL: project.HTTPURL}, nil
+}
+
+func (g gitLabProvider) Branches(projectKey, slug string) (map[string]stash.Branch, error) {
+	values, err := g.branches(projectKey, slug)
+	if err != nil {
+		return nil, err
+	}
+	branches := make(map[string]stash.Branch)
+	for _, branch := range values {
+		b := branchFromName(branch.Name)
+		branches[b.DisplayID] = b
+	}
+	return branches, nil
+}
+
+func (g gitLabProvider) BranchHeads(projectKey, slug string) (map[string]BranchHead, error) {
+	values, err := g.branches(projectKey, slug)
+	if err != nil {
+		return nil, err
+	}
+	heads := make(map[string]BranchHead)
+	for _, branch := range values {
+		heads[branch.Name] = BranchHead{Commit: branch.Commit.ID, Time: branch.Commit.CommittedDate}
+	}
+	return heads, nil
+}
+
+func (g gitLabProvider) branches(projectKey, slug string) ([]gitLabBranch, error) {
+	branches := make([]gitLabBranch, 0)
+	for page := "1"; page != ""; {
+		var values []gitLabBranch
+		header, err := g.api.get(fmt.Sprintf("/api/v4/projects/%s/repository/branches?per_page=100&page=%s", g.projectID(projectKey, slug), page), &values)
+		if err != nil {
+			return nil, err
+		}
+		branches = append(branches, values...)
+		page = header.Get("X-Next-Page")
+	}
+	return branches, nil
+}
+
+// PullRequests returns the project's open merge requests, identified by their project-scoped IIDs.
+func (g gitLabProvider) PullRequests(projectKey, slug string) ([]PullRequest, error) {
+	pullRequests := make([]PullRequest, 0)
+	for page := "1"; page != ""; {
+		var values []gitLabMergeRequest
+		header, err := g.api.get(fmt.Sprintf("/api/v4/projects/%s/merge_requests?state=opened&per_page=100&page=%s", g.projectID(projectKey, slug), page), &values)
+		if err != nil {
+			return nil, err
+		}
+		for _, mr := range values {
+			pullRequests = append(pullRequests, PullRequest{
+				ID:           mr.IID,
+				Title:        mr.Title,
+				Author:       mr.Author.Username,
+				SourceBranch: mr.SourceBranch,
+				TargetBranch: mr.TargetBranch,
+				MergeRef:     fmt.Sprintf("refs/merge-requests/%d/merge", mr.IID),
+			})
+		}
+		page = header.Get("X-Next-Page")
+	}
+	return pullRequests, nil
+}
+
+func (g gitLabProvider) Tags(projectKey, slug string) ([]Tag, error) {
+	tags := make([]Tag, 0)
+	for page := "1"; page != ""; {
+		var values []gitLabTag
+		header, err := g.api.get(fmt.Sprintf("/api/v4/projects/%s/repository/tags?per_page=100&page=%s", g.projectID(projectKey, slug), page), &values)
+		if err != nil {
+			return nil, err
+		}
+		for _, tag := range values {
+			tags = append(tags, Tag{Name: tag.Name, Commit: tag.Commit.ID})
+		}
+		page = header.Get("X-Next-Page")
+	}
+	return tags, nil
+}
+
+// projectID is the URL encoded project path GitLab accepts in place of a numeric project ID.
+func (g gitLabProvider) projectID(projectKey, slug string) string {
+	return url.PathEscape(projectKey + "/" + slug)
+}
@@ -0,0 +1,84 @@
+package stashkins
+
+import (
+	"fmt"
+	"sync"
+)
+
+// DeletionLimits bound how many obsolete jobs may be deleted.  A zero value disables that limit.  Percentages are of the
+// jobs that exist in the CI, pull request and tag job namespaces of a repository, or of all templated repositories for the
+// per-run limit.
+type DeletionLimits struct {
+	MaxPerRepository        int
+	MaxPercentPerRepository int
+	MaxPerRun               int
+	MaxPercentPerRun        int
+}
+
+// A DeletionGuard refuses to delete a repository's obsolete jobs when doing so would exceed the configured limits.  It
+// protects against a flaky or truncated branch listing from Stash, which makes every job in a namespace look obsolete.
+// A DeletionGuard is shared by all repositories in a run and is safe for concurrent use.
+type DeletionGuard struct {
+	limits       DeletionLimits
+	runNamespace int
+
+	mu         sync.Mutex
+	runDeleted int
+}
+
+// NewDeletionGuard returns a guard for one run over the given templates.  The job index determines the size of the
+// run-wide job namespace against which MaxPercentPerRun is measured.  setup, if not nil, supplies the Stashkins naming each
+// template's jobs.
+func NewDeletionGuard(limits DeletionLimits, jobIndex JobIndex, jobTemplates []JobTemplate, setup TemplateSetup) *DeletionGuard {
+	n := 0
+	for _, jobTemplate := range jobTemplates {
+		skins := DefaultStashkins{}
+		if setup != nil {
+			skins, _ = setup(jobTemplate)
+		}
+		n += skins.existingJobCount(jobIndex, jobTemplate.ProjectKey, jobTemplate.Slug)
+	}
+	return &DeletionGuard{limits: limits, runNamespace: n}
+}
+
+// existingJobCount counts the jobs that exist in a repository's CI, pull request and tag job namespaces, against which the
+// percentage limits are measured.
+func (c DefaultStashkins) existingJobCount(jobIndex JobIndex, projectKey, slug string) int {
+	return len(jobIndex.inNameSpace(c.cIJobNameSpace(projectKey, slug))) +
+		len(jobIndex.inNameSpace(c.pullRequestJobNameSpace(projectKey, slug))) +
+		len(jobIndex.inNameSpace(c.tagJobNameSpace(projectKey, slug)))
+}
+
+// reserve admits or refuses the deletion of all obsolete CI, pull request and tag jobs in the plan.  Deletions are never partially
+// admitted.  An admitted reservation counts against the run-wide limits.  A nil guard admits everything.
+func (g *DeletionGuard) reserve(plan Plan) error {
+	if g == nil {
+		return nil
+	}
+
+	n := len(plan.ObsoleteJobs) + len(plan.ObsoletePullRequestJobs) + len(plan.ObsoleteTagJobs)
+	if n == 0 {
+		return nil
+	}
+
+	namespace := plan.ExistingJobs
+	if g.limits.MaxPerRepository > 0 && n > g.limits.MaxPerRepository {
+		return fmt.Errorf("refusing to delete %d jobs for %s/%s: exceeds the per-repository limit of %d", n, plan.ProjectKey, plan.Slug, g.limits.MaxPerRepository)
+	}
+	if g.limits.MaxPercentPerRepository > 0 && n*100 > g.limits.MaxPercentPerRepository*namespace {
+		return fmt.Errorf("refusing to delete %d of %d jobs for %s/%s: exceeds the per-repository limit of %d%%", n, namespace, plan.ProjectKey, plan.Slug, g.limits.MaxPercentPerRepository)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.limits.MaxPerRun > 0 && g.runDeleted+n > g.limits.MaxPerRun {
+		return fmt.Errorf("refusing to delete %d jobs for %s/%s: %d already deleted this run and the per-run limit is %d", n, plan.ProjectKey, plan.Slug, g.runDeleted, g.limits.MaxPerRun)
+	}
+	if g.limits.MaxPercentPerRun > 0 && (g.runDeleted+n)*100 > g.limits.MaxPercentPerRun*g.runNamespace {
+		return fmt.Errorf("refusing to delete %d jobs for %s/%s: %d already deleted this run of %d and the per-run limit is %d%%", n, plan.ProjectKey, plan.Slug, g.runDeleted, g.runNamespace, g.limits.MaxPercentPerRun)
+	}
+
+	g.runDeleted += n
+	return nil
+}
@@ -0,0 +1,127 @@
+package stashkins
+
+import (
+	"testing"
+
+	"github.com/xoom/jenkins"
+	"github.com/xoom/stash"
+)
+
+// guardPlan returns a plan to delete obsolete jobs of a repository that has existing jobs besides.
+func guardPlan(obsolete, existing int) Plan {
+	plan := Plan{ProjectKey: "proj", Slug: "slug", ExistingJobs: obsolete + existing}
+	for i := 0; i < obsolete; i++ {
+		plan.ObsoleteJobs = append(plan.ObsoleteJobs, JobDescriptorNG{})
+	}
+	return plan
+}
+
+func TestDeletionGuardPerRepository(t *testing.T) {
+	guard := &DeletionGuard{limits: DeletionLimits{MaxPerRepository: 3, MaxPercentPerRepository: 50}}
+
+	if err := guard.reserve(guardPlan(3, 7)); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if err := guard.reserve(guardPlan(4, 20)); err == nil {
+		t.Fatal("Expecting refusal above the per-repository count")
+	}
+	if err := guard.reserve(guardPlan(2, 1)); err == nil {
+		t.Fatal("Expecting refusal above the per-repository percentage")
+	}
+}
+
+func TestDeletionGuardCountsPullRequestJobs(t *testing.T) {
+	guard := &DeletionGuard{limits: DeletionLimits{MaxPerRepository: 3}}
+
+	plan := guardPlan(2, 5)
+	plan.ObsoletePullRequestJobs = []PullRequestJobDescriptor{PullRequestJobDescriptor{JobName: "proj-slug-pullrequest-1"}, PullRequestJobDescriptor{JobName: "proj-slug-pullrequest-2"}}
+	if err := guard.reserve(plan); err == nil {
+		t.Fatal("Expecting refusal above the per-repository count with pull request jobs")
+	}
+
+	plan = guardPlan(0, 0)
+	plan.ObsoletePullRequestJobs = []PullRequestJobDescriptor{PullRequestJobDescriptor{JobName: "proj-slug-pullrequest-1"}}
+	plan.PullRequestJobs = []PullRequestJobDescriptor{PullRequestJobDescriptor{JobName: "proj-slug-pullrequest-2"}}
+	plan.ExistingJobs = 2
+	guard = &DeletionGuard{limits: DeletionLimits{MaxPercentPerRepository: 40}}
+	if err := guard.reserve(plan); err == nil {
+		t.Fatal("Expecting refusal above the per-repository percentage of pull request jobs")
+	}
+}
+
+func TestDeletionGuardCountsTagJobs(t *testing.T) {
+	guard := &DeletionGuard{limits: DeletionLimits{MaxPerRepository: 2}}
+
+	plan := guardPlan(1, 5)
+	plan.ObsoletePullRequestJobs = []PullRequestJobDescriptor{PullRequestJobDescriptor{JobName: "proj-slug-pullrequest-1"}}
+	plan.ObsoleteTagJobs = []TagJobDescriptor{TagJobDescriptor{JobName: "proj-slug-tagrelease-v1.0.0"}}
+	if err := guard.reserve(plan); err == nil {
+		t.Fatal("Expecting refusal above the per-repository count with tag jobs")
+	}
+}
+
+func TestDeletionGuardCountsStaleBranchJobs(t *testing.T) {
+	jobSummaries := []jenkins.JobSummary{
+		jenkins.JobSummary{JobDescriptor: jenkins.JobDescriptor{Name: "proj-slug-continuous-feature-1"}},
+		jenkins.JobSummary{JobDescriptor: jenkins.JobDescriptor{Name: "proj-slug-continuous-feature-2"}},
+		jenkins.JobSummary{JobDescriptor: jenkins.JobDescriptor{Name: "proj-slug-continuous-feature-3"}},
+		jenkins.JobSummary{JobDescriptor: jenkins.JobDescriptor{Name: "proj-slug-continuous-feature-4"}},
+	}
+	branches := map[string]stash.Branch{"feature/1": stash.Branch{DisplayID: "feature/1"}}
+	stale := map[string]stash.Branch{"feature/2": stash.Branch{DisplayID: "feature/2"}, "feature/3": stash.Branch{DisplayID: "feature/3"}}
+	jobTemplate := JobTemplate{ProjectKey: "proj", Slug: "slug", ContinuousJobTemplate: []byte("<project/>")}
+
+	// The jobs of stale branches are kept, and so count among the repository's jobs:  deleting one of four is 25%.
+	skins := DefaultStashkins{branchOperations: NewBranchOperations("feature/")}
+	plan := skins.plan(NewJobIndex(jobSummaries), jobTemplate, branches, stale)
+	if plan.ExistingJobs != 4 || len(plan.ObsoleteJobs) != 1 {
+		t.Fatalf("Want 4 existing jobs and 1 obsolete but got %d and %+v\n", plan.ExistingJobs, plan.ObsoleteJobs)
+	}
+	guard := &DeletionGuard{limits: DeletionLimits{MaxPercentPerRepository: 30}}
+	if err := guard.reserve(plan); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+}
+
+func TestDeletionGuardPerRun(t *testing.T) {
+	guard := &DeletionGuard{limits: DeletionLimits{MaxPerRun: 5}}
+
+	if err := guard.reserve(guardPlan(3, 0)); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if err := guard.reserve(guardPlan(3, 0)); err == nil {
+		t.Fatal("Expecting refusal above the per-run count")
+	}
+	if err := guard.reserve(guardPlan(2, 0)); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+}
+
+func TestDeletionGuardPerRunPercent(t *testing.T) {
+	jobSummaries := []jenkins.JobSummary{
+		jenkins.JobSummary{JobDescriptor: jenkins.JobDescriptor{Name: "proj-slug-continuous-develop"}},
+		jenkins.JobSummary{JobDescriptor: jenkins.JobDescriptor{Name: "proj-slug-continuous-feature-1"}},
+		jenkins.JobSummary{JobDescriptor: jenkins.JobDescriptor{Name: "proj-other-continuous-develop"}},
+		jenkins.JobSummary{JobDescriptor: jenkins.JobDescriptor{Name: "proj-other-continuous-feature-1"}},
+		jenkins.JobSummary{JobDescriptor: jenkins.JobDescriptor{Name: "unmanaged"}},
+	}
+	templates := []JobTemplate{JobTemplate{ProjectKey: "proj", Slug: "slug"}, JobTemplate{ProjectKey: "proj", Slug: "other"}}
+
+	guard := NewDeletionGuard(DeletionLimits{MaxPercentPerRun: 50}, NewJobIndex(jobSummaries), templates, nil)
+	if guard.runNamespace != 4 {
+		t.Fatalf("Want 4 but got %d\n", guard.runNamespace)
+	}
+	if err := guard.reserve(guardPlan(2, 0)); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if err := guard.reserve(guardPlan(1, 0)); err == nil {
+		t.Fatal("Expecting refusal above the per-run percentage")
+	}
+}
+
+func TestNilDeletionGuard(t *testing.T) {
+	var guard *DeletionGuard
+	if err := guard.reserve(guardPlan(100, 0)); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+}
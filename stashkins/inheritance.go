@@ -0,0 +1,166 @@
+package stashkins
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/sirupsen/logrus"
+)
+
+// A templateSource is a repository's template of one kind, composed from the repository's own template file and any
+// project and global defaults.  file names the most specific of those files.
+type templateSource struct {
+	projectKey string
+	slug       string
+	file       string
+	data       []byte
+}
+
+// templateLevels are the files of one kind of template found in a template repository, by level.
+type templateLevels struct {
+	global       string            // at the root of the template repository
+	projects     map[string]string // in a project-key directory, keyed by project key
+	repositories map[string]string // in a project-key/slug directory, keyed by project-key/slug
+}
+
+// classifyTemplates sorts files found under dir by the level at which they apply.  A file directly in dir is the global
+// default, one in dir/project-key the project default, and any other is a repository's own, as projectCoordinates
+// determines.
+func classifyTemplates(dir string, files []string) templateLevels {
+	levels := templateLevels{projects: make(map[string]string), repositories: make(map[string]string)}
+	for _, file := range files {
+		relative, err := filepath.Rel(dir, file)
+		if err != nil {
+			Log.WithError(err).WithField("file", file).Warn("Skipping template")
+			continue
+		}
+		parts := strings.Split(filepath.ToSlash(relative), "/")
+		switch len(parts) {
+		case 1:
+			levels.global = file
+		case 2:
+			levels.projects[strings.ToLower(parts[0])] = file
+		default:
+			projectKey, slug, err := projectCoordinates(file)
+			if err != nil {
+				Log.WithError(err).WithField("file", file).Warn("Skipping template")
+				continue
+			}
+			levels.repositories[projectKey+"/"+slug] = file
+		}
+	}
+	return levels
+}
+
+// files are the repository level files.
+func (levels templateLevels) files() []string {
+	files := make([]string, 0, len(levels.repositories))
+	for _, file := range levels.repositories {
+		files = append(files, file)
+	}
+	return files
+}
+
+// resolve composes the template of this kind for each of the given repositories, keyed by project-key/slug.  Repositories
+// for which no level provides a template are omitted, as are those whose files cannot be read or composed.
+func (levels templateLevels) resolve(repositories map[string][2]string) []templateSource {
+	sources := make([]templateSource, 0)
+	for key, coordinates := range repositories {
+		source, found, err := levels.source(key, coordinates)
+		if err != nil {
+			Log.WithError(err).WithFields(logrus.Fields{"project": source.projectKey, "slug": source.slug, "file": source.file}).Warn("Skipping template that cannot be composed")
+			continue
+		}
+		if found {
+			sources = append(sources, source)
+		}
+	}
+	return sources
+}
+
+// source composes the template of this kind for the repository keyed by project-key/slug from the repository's file, its
+// project's default and the global default, most specific first.  found is false if no level provides a template.  The
+// source's file is set even when an error is returned.
+func (levels templateLevels) source(key string, coordinates [2]string) (source templateSource, found bool, err error) {
+	source = templateSource{projectKey: coordinates[0], slug: coordinates[1]}
+
+	files := make([]string, 0, 3)
+	for _, file := range []string{levels.global, levels.projects[source.projectKey], levels.repositories[key]} {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	if len(files) == 0 {
+		return source, false, nil
+	}
+	source.file = files[len(files)-1]
+
+	layers := make([][]byte, 0, len(files))
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return source, true, err
+		}
+		layers = append(layers, data)
+	}
+
+	if source.data, err = composeTemplates(layers...); err != nil {
+		return source, true, err
+	}
+	return source, true, nil
+}
+
+// composeTemplates composes template layers, least specific first, into one template.  The body of the template is that of
+// the most specific layer with a body.  Templates named by define or block in any layer are kept, the most specific
+// definition winning, so a layer consisting only of defines overrides just those blocks of the layers before it.  A single
+// layer is returned unchanged.
+func composeTemplates(layers ...[]byte) ([]byte, error) {
+	if len(layers) == 1 {
+		return layers[0], nil
+	}
+
+	var body string
+	definitions := make(map[string]string)
+	for i, layer := range layers {
+		name := "layer" + strconv.Itoa(i)
+		t, err := template.New(name).Funcs(templateFuncs).Parse(string(layer))
+		if err != nil {
+			return nil, err
+		}
+		for _, associated := range t.Templates() {
+			if associated.Tree == nil || associated.Tree.Root == nil {
+				continue
+			}
+			if associated.Name() == name {
+				if !parse.IsEmptyTree(associated.Tree.Root) {
+					body = associated.Tree.Root.String()
+				}
+				continue
+			}
+			definitions[associated.Name()] = associated.Tree.Root.String()
+		}
+	}
+	if body == "" {
+		return nil, fmt.Errorf("stashkins.composeTemplates no layer has a body outside define")
+	}
+
+	names := make([]string, 0, len(definitions))
+	for name := range definitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b bytes.Buffer
+	b.WriteString(body)
+	for _, name := range names {
+		fmt.Fprintf(&b, "{{define %s}}%s{{end}}", strconv.Quote(name), definitions[name])
+	}
+	return b.Bytes(), nil
+}
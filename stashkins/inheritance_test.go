@@ -0,0 +1,111 @@
+package stashkins
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"text/template"
+)
+
+func render(t *testing.T, data []byte) string {
+	tmpl, err := template.New("test").Parse(string(data))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, struct{ BranchName string }{"feature/1"}); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	return b.String()
+}
+
+func TestComposeTemplates(t *testing.T) {
+	global := []byte(`<project><scm>{{block "scm" .}}git {{.BranchName}}{{end}}</scm><builders>{{block "builders" .}}make{{end}}</builders></project>`)
+	project := []byte(`{{define "builders"}}mvn deploy{{end}}`)
+	repository := []byte(`{{define "scm"}}svn {{.BranchName}}{{end}}`)
+
+	if data, _ := composeTemplates(global); !bytes.Equal(data, global) {
+		t.Fatalf("Want a single layer unchanged but got %s\n", data)
+	}
+
+	var tests = []struct {
+		layers [][]byte
+		want   string
+	}{
+		{[][]byte{global, project}, "<project><scm>git feature/1</scm><builders>mvn deploy</builders></project>"},
+		{[][]byte{global, project, repository}, "<project><scm>svn feature/1</scm><builders>mvn deploy</builders></project>"},
+		{[][]byte{global, []byte(`<project>{{template "builders" .}}</project>`)}, "<project>make</project>"},
+	}
+	for _, test := range tests {
+		data, err := composeTemplates(test.layers...)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v\n", err)
+		}
+		if got := render(t, data); got != test.want {
+			t.Fatalf("Want %s but got %s\n", test.want, got)
+		}
+	}
+
+	if _, err := composeTemplates(project, repository); err == nil {
+		t.Fatalf("Want an error for layers without a body\n")
+	}
+	if _, err := composeTemplates(global, []byte(`{{define "scm"}}{{end`)); err == nil {
+		t.Fatalf("Want an error for a malformed layer\n")
+	}
+}
+
+func TestResolveTemplates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "templates-")
+	if err != nil {
+		t.Fatalf("Unexpected error creating temp dir: %v\n", err)
+	}
+	defer os.RemoveAll(dir)
+
+	contents := map[string]string{
+		"continuous-template.xml":                  `<project>{{block "builders" .}}make{{end}}</project>`,
+		"PROJ/continuous-template.xml":             `{{define "builders"}}mvn{{end}}`,
+		"PROJ/custom/continuous-template.xml":      `<project>custom</project>`,
+		"PROJ/inherited/stashkins.yaml":            `enabled: true`,
+		"OTHER/inherited/continuous-template.xml":  `{{define "builders"}}gradle{{end}}`,
+		"OTHER/defaulted/pullrequest-template.xml": `<project/>`,
+	}
+	files := make([]string, 0)
+	for name, content := range contents {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatalf("Unexpected error: %v\n", err)
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatalf("Unexpected error: %v\n", err)
+		}
+		if filepath.Base(file) == "continuous-template.xml" {
+			files = append(files, file)
+		}
+	}
+
+	repositories := map[string][2]string{
+		"proj/custom":     {"proj", "custom"},
+		"proj/inherited":  {"proj", "inherited"},
+		"other/inherited": {"other", "inherited"},
+		"other/defaulted": {"other", "defaulted"},
+	}
+	want := map[string]string{
+		"proj/custom":     "<project>custom</project>",
+		"proj/inherited":  "<project>mvn</project>",
+		"other/inherited": "<project>gradle</project>",
+		"other/defaulted": "<project>make</project>",
+	}
+
+	sources := classifyTemplates(dir, files).resolve(repositories)
+	if len(sources) != len(want) {
+		t.Fatalf("Want %d but got %d\n", len(want), len(sources))
+	}
+	for _, source := range sources {
+		key := source.projectKey + "/" + source.slug
+		if got := render(t, source.data); got != want[key] {
+			t.Fatalf("Want %s for %s but got %s\n", want[key], key, got)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package stashkins
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultRequestTimeout bounds each request made over HTTP where WebClientParams give no timeout.
+const DefaultRequestTimeout = time.Minute
+
+// httpClient returns a client whose requests time out per the params, so that a server that stops responding cannot hold up
+// a reconciliation indefinitely.
+func (p WebClientParams) httpClient() *http.Client {
+	timeout := p.Timeout
+	if timeout == 0 {
+		timeout = DefaultRequestTimeout
+	}
+	return &http.Client{Timeout: timeout}
+}
+
+// jobConfig returns the config.xml of an existing job.  If a Jenkins jobs directory is configured the file is read from the
+// Jenkins master filesystem, otherwise it is fetched over HTTP.
+func (c DefaultStashkins) jobConfig(jobName string) ([]byte, error) {
+	if c.Options.JenkinsJobsDirectory != "" {
+		return ioutil.ReadFile(filepath.Join(c.Options.JenkinsJobsDirectory, jobName, "config.xml"))
+	}
+	return c.jenkinsRequest("GET", jobPath(jobName)+"/config.xml", nil)
+}
+
+// updateJobConfig replaces the config.xml of an existing job.
+func (c DefaultStashkins) updateJobConfig(jobName string, config []byte) error {
+	_, err := c.jenkinsRequest("POST", jobPath(jobName)+"/config.xml", config)
+	return err
+}
+
+// jenkinsRequest makes an authenticated request against the Jenkins master and returns the response body.  Any non-2xx
+// response is an error.  Requests other than GET carry a crumb, which Jenkins requires of them when CSRF protection is on.
+func (c DefaultStashkins) jenkinsRequest(method, path string, body []byte) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, strings.TrimSuffix(c.jenkinsParams.URL, "/")+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(c.jenkinsParams.UserName, c.jenkinsParams.Password)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/xml")
+	}
+	if method != "GET" {
+		if err := c.addCrumb(req); err != nil {
+			return nil, err
+		}
+	}
+
+	c.jenkinsLimit.acquire()
+	defer c.jenkinsLimit.release()
+
+	start := time.Now()
+	data, err := doRequest(c.jenkinsParams.httpClient(), req)
+	c.metrics.observeRequest(backendJenkins, strings.ToLower(method)+"_"+jenkinsOperation(path), start, err)
+	return data, err
+}
+
+// A crumb is the token Jenkins requires of requests that change anything when CSRF protection is on.
+type crumb struct {
+	Crumb             string `json:"crumb"`
+	CrumbRequestField string `json:"crumbRequestField"`
+}
+
+// addCrumb adds a crumb from the Jenkins crumb issuer to req, with the session cookies Jenkins binds the crumb to.  Nothing
+// is added if Jenkins has no crumb issuer, as when CSRF protection is off.
+func (c DefaultStashkins) addCrumb(req *http.Request) error {
+	crumbReq, err := http.NewRequest("GET", strings.TrimSuffix(c.jenkinsParams.URL, "/")+"/crumbIssuer/api/json", nil)
+	if err != nil {
+		return err
+	}
+	crumbReq.SetBasicAuth(c.jenkinsParams.UserName, c.jenkinsParams.Password)
+
+	c.jenkinsLimit.acquire()
+	defer c.jenkinsLimit.release()
+
+	start := time.Now()
+	resp, err := c.jenkinsParams.httpClient().Do(crumbReq)
+	if err != nil {
+		c.metrics.observeRequest(backendJenkins, "get_crumb", start, err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		c.metrics.observeRequest(backendJenkins, "get_crumb", start, nil)
+		return nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		err = fmt.Errorf("stashkins.addCrumb %s returned HTTP status %d", crumbReq.URL.Path, resp.StatusCode)
+		c.metrics.observeRequest(backendJenkins, "get_crumb", start, err)
+		return err
+	}
+
+	var issued crumb
+	err = json.NewDecoder(resp.Body).Decode(&issued)
+	c.metrics.observeRequest(backendJenkins, "get_crumb", start, err)
+	if err != nil {
+		return fmt.Errorf("cannot decode Jenkins crumb: %v", err)
+	}
+
+	req.Header.Set(issued.CrumbRequestField, issued.Crumb)
+	for _, cookie := range resp.Cookies() {
+		req.AddCookie(cookie)
+	}
+	return nil
+}
+
+func doRequest(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("stashkins.jenkinsRequest %s %s returned HTTP status %d", req.Method, req.URL.Path, resp.StatusCode)
+	}
+	return data, nil
+}
+
+// jenkinsOperation names the job operation a request path addresses, such as config.xml or disable, for use as a metric
+// label.  Job names are left out to bound the number of label values.
+func jenkinsOperation(path string) string {
+	operation := path[strings.LastIndex(path, "/")+1:]
+	if i := strings.Index(operation, "?"); i >= 0 {
+		operation = operation[:i]
+	}
+	return operation
+}
+
+func jobPath(jobName string) string {
+	return "/job/" + url.PathEscape(jobName)
+}
@@ -0,0 +1,86 @@
+package stashkins
+
+import (
+	"strings"
+
+	"github.com/xoom/jenkins"
+)
+
+const (
+	ciNameSpaceDelimiter          = "-continuous-"
+	pullRequestNameSpaceDelimiter = "-pullrequest-"
+	tagNameSpaceDelimiter         = "-tagrelease-"
+)
+
+// nameSpaceDelimiters end the job namespaces a JobIndex indexes.
+var nameSpaceDelimiters = []string{ciNameSpaceDelimiter, pullRequestNameSpaceDelimiter, tagNameSpaceDelimiter}
+
+// A JobIndex indexes Jenkins job summaries by name and by CI, pull request and tag job namespace, so that reconciling a
+// repository costs time proportional to that repository's jobs rather than to every job on the Jenkins master.  Build one
+// per run.  A JobIndex is read-only once built and safe for concurrent use.
+type JobIndex struct {
+	names      map[string]bool
+	namespaces map[string][]string // CI, pull request or tag job namespace -> names of the jobs in it, in job summary order
+	archived   map[string][]string // CI job namespace -> names of archived jobs whose original name was in it
+}
+
+func NewJobIndex(jobSummaries []jenkins.JobSummary) JobIndex {
+	index := JobIndex{
+		names:      make(map[string]bool, len(jobSummaries)),
+		namespaces: make(map[string][]string),
+		archived:   make(map[string][]string),
+	}
+
+	for _, jobSummary := range jobSummaries {
+		name := jobSummary.JobDescriptor.Name
+		index.names[name] = true
+
+		if ciJobName, _, ok := parseArchivedJobName(name); ok {
+			for _, namespace := range candidateNameSpaces(ciJobName) {
+				index.archived[namespace] = append(index.archived[namespace], name)
+			}
+			continue
+		}
+		for _, namespace := range candidateNameSpaces(name) {
+			index.namespaces[namespace] = append(index.namespaces[namespace], name)
+		}
+	}
+	return index
+}
+
+// candidateNameSpaces returns every CI, pull request or tag job namespace a job name could belong to.  Usually there is one, but
+// a project key or slug may itself contain a namespace delimiter.
+func candidateNameSpaces(jobName string) []string {
+	namespaces := make([]string, 0, 1)
+	for _, delimiter := range nameSpaceDelimiters {
+		for i := 0; ; {
+			j := strings.Index(jobName[i:], delimiter)
+			if j < 0 {
+				break
+			}
+			i += j + len(delimiter)
+			namespaces = append(namespaces, jobName[:i])
+		}
+	}
+	return namespaces
+}
+
+// Exists reports whether a job of the given name exists.
+func (x JobIndex) Exists(jobName string) bool {
+	return x.names[jobName]
+}
+
+// Len returns the number of indexed jobs.
+func (x JobIndex) Len() int {
+	return len(x.names)
+}
+
+// inNameSpace returns the names of the jobs in the given CI, pull request or tag job namespace.
+func (x JobIndex) inNameSpace(namespace string) []string {
+	return x.namespaces[namespace]
+}
+
+// archivedFromNameSpace returns the names of the archived jobs whose original name was in the given CI job namespace.
+func (x JobIndex) archivedFromNameSpace(namespace string) []string {
+	return x.archived[namespace]
+}
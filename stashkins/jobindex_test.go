@@ -0,0 +1,132 @@
+package stashkins
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/xoom/jenkins"
+)
+
+func TestJobIndex(t *testing.T) {
+	index := NewJobIndex([]jenkins.JobSummary{
+		jenkins.JobSummary{JobDescriptor: jenkins.JobDescriptor{Name: "proj-somelib-continuous-feature-1"}},
+		jenkins.JobSummary{JobDescriptor: jenkins.JobDescriptor{Name: "proj-somelib-continuous-develop"}},
+		jenkins.JobSummary{JobDescriptor: jenkins.JobDescriptor{Name: "proj-some-continuous-lib-continuous-develop"}},
+		jenkins.JobSummary{JobDescriptor: jenkins.JobDescriptor{Name: "retired-20160801000000-proj-somelib-continuous-feature-2"}},
+		jenkins.JobSummary{JobDescriptor: jenkins.JobDescriptor{Name: "proj-somelib-release"}},
+	})
+
+	if index.Len() != 5 {
+		t.Fatalf("Want 5 but got %d\n", index.Len())
+	}
+	if !index.Exists("proj-somelib-release") || index.Exists("nope") {
+		t.Fatal("Unexpected job existence")
+	}
+
+	if jobs := index.inNameSpace("proj-somelib-continuous-"); len(jobs) != 2 || jobs[0] != "proj-somelib-continuous-feature-1" || jobs[1] != "proj-somelib-continuous-develop" {
+		t.Fatalf("Unexpected namespace jobs %+v\n", jobs)
+	}
+
+	// a slug containing the namespace delimiter
+	if jobs := index.inNameSpace("proj-some-continuous-lib-continuous-"); len(jobs) != 1 {
+		t.Fatalf("Want 1 but got %+v\n", jobs)
+	}
+	if jobs := index.inNameSpace("proj-some-continuous-"); len(jobs) != 1 {
+		t.Fatalf("Want 1 but got %+v\n", jobs)
+	}
+
+	if jobs := index.archivedFromNameSpace("proj-somelib-continuous-"); len(jobs) != 1 || jobs[0] != "retired-20160801000000-proj-somelib-continuous-feature-2" {
+		t.Fatalf("Unexpected archived jobs %+v\n", jobs)
+	}
+}
+
+// benchmarkJobs returns job summaries for the given number of repositories with 10 branch jobs each, and the spec jobs of the
+// first repository.
+func benchmarkJobs(repositories int) ([]jenkins.JobSummary, []JobDescriptorNG) {
+	jobSummaries := make([]jenkins.JobSummary, 0, repositories*10)
+	for r := 0; r < repositories; r++ {
+		for b := 0; b < 10; b++ {
+			jobSummaries = append(jobSummaries, jenkins.JobSummary{JobDescriptor: jenkins.JobDescriptor{Name: fmt.Sprintf("proj-slug%d-continuous-feature-%d", r, b)}})
+		}
+	}
+	specCIJobs := make([]JobDescriptorNG, 0)
+	for b := 5; b < 15; b++ {
+		specCIJobs = append(specCIJobs, JobDescriptorNG{JobName: fmt.Sprintf("proj-slug0-continuous-feature-%d", b)})
+	}
+	return jobSummaries, specCIJobs
+}
+
+// quadraticMissingAndObsolete is the nested loop set computation the job index replaced, kept as a benchmark baseline.
+func quadraticMissingAndObsolete(specCIJobs []JobDescriptorNG, jobSummaries []jenkins.JobSummary) (int, int) {
+	skins := DefaultStashkins{}
+	missing, obsolete := 0, 0
+	for _, specJob := range specCIJobs {
+		foundIt := false
+		for _, existingJob := range jobSummaries {
+			if existingJob.JobDescriptor.Name == specJob.JobName {
+				foundIt = true
+				break
+			}
+		}
+		if !foundIt {
+			missing++
+		}
+	}
+	for _, existingJob := range jobSummaries {
+		jobNotInSpec := true
+		for _, specJob := range specCIJobs {
+			if existingJob.JobDescriptor.Name == specJob.JobName {
+				jobNotInSpec = false
+				break
+			}
+		}
+		if jobNotInSpec && skins.jobInCINameSpace(existingJob.JobDescriptor.Name, "proj", "slug0") {
+			obsolete++
+		}
+	}
+	return missing, obsolete
+}
+
+// Each benchmark iteration reconciles every repository once against the same Jenkins master, as a run does.
+func benchmarkQuadratic(b *testing.B, repositories int) {
+	jobSummaries, specCIJobs := benchmarkJobs(repositories)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for r := 0; r < repositories; r++ {
+			quadraticMissingAndObsolete(specCIJobs, jobSummaries)
+		}
+	}
+}
+
+func benchmarkIndexed(b *testing.B, repositories int) {
+	jobSummaries, specCIJobs := benchmarkJobs(repositories)
+	skins := DefaultStashkins{}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		index := NewJobIndex(jobSummaries)
+		for r := 0; r < repositories; r++ {
+			skins.calculateMissingCIJobs(specCIJobs, index)
+			skins.calculateObsoleteCIJobs(specCIJobs, "proj", "slug0", index)
+		}
+	}
+}
+
+func BenchmarkQuadraticSetComputation1000Jobs(b *testing.B)  { benchmarkQuadratic(b, 100) }
+func BenchmarkQuadraticSetComputation10000Jobs(b *testing.B) { benchmarkQuadratic(b, 1000) }
+func BenchmarkIndexedSetComputation1000Jobs(b *testing.B)    { benchmarkIndexed(b, 100) }
+func BenchmarkIndexedSetComputation10000Jobs(b *testing.B)   { benchmarkIndexed(b, 1000) }
+func BenchmarkIndexedSetComputation100000Jobs(b *testing.B)  { benchmarkIndexed(b, 10000) }
+
+func TestIndexedMatchesQuadratic(t *testing.T) {
+	jobSummaries, specCIJobs := benchmarkJobs(50)
+	skins := DefaultStashkins{}
+	index := NewJobIndex(jobSummaries)
+
+	missing, obsolete := quadraticMissingAndObsolete(specCIJobs, jobSummaries)
+	if n := len(skins.calculateMissingCIJobs(specCIJobs, index)); n != missing {
+		t.Fatalf("Want %d but got %d\n", missing, n)
+	}
+	if n := len(skins.calculateObsoleteCIJobs(specCIJobs, "proj", "slug0", index)); n != obsolete {
+		t.Fatalf("Want %d but got %d\n", obsolete, n)
+	}
+}
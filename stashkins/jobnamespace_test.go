@@ -18,3 +18,16 @@ func TestJobIsInNameSpace(t *testing.T) {
 		t.Fatalf("Not expecting job to be in namespace\n")
 	}
 }
+
+func TestJobNamePrefix(t *testing.T) {
+	skins := DefaultStashkins{Options: ReconcileOptions{JobNamePrefix: "payments"}}
+	if nameSpace := skins.cIJobNameSpace("proj", "somelib"); nameSpace != "payments-continuous-" {
+		t.Fatalf("Want payments-continuous- but got %s\n", nameSpace)
+	}
+	if jobName := skins.canonicalReleaseJobName("proj", "somelib"); jobName != "payments-release" {
+		t.Fatalf("Want payments-release but got %s\n", jobName)
+	}
+	if nameSpace := skins.tagJobNameSpace("proj", "somelib"); nameSpace != "payments-tagrelease-" {
+		t.Fatalf("Want payments-tagrelease- but got %s\n", nameSpace)
+	}
+}
@@ -11,9 +11,25 @@ import (
 
 	"fmt"
 
+	"github.com/sirupsen/logrus"
 	"github.com/xoom/jenkins"
+	"gopkg.in/yaml.v2"
 )
 
+// settingsFileName names the optional per-repository settings file kept alongside a repository's templates.
+const settingsFileName = "stashkins.yaml"
+
+// Template file names, one for each kind of job.
+const (
+	continuousTemplateFileName  = "continuous-template.xml"
+	releaseTemplateFileName     = "release-template.xml"
+	pullRequestTemplateFileName = "pullrequest-template.xml"
+	tagTemplateFileName         = "tag-template.xml"
+)
+
+// templateFileNames are the template file names in the order a repository's templates are considered.
+var templateFileNames = []string{continuousTemplateFileName, releaseTemplateFileName, pullRequestTemplateFileName, tagTemplateFileName}
+
 func jobType(xmlDocument []byte) (jenkins.JobType, error) {
 	decoder := xml.NewDecoder(bytes.NewBuffer(xmlDocument))
 
@@ -29,13 +45,18 @@ func jobType(xmlDocument []byte) (jenkins.JobType, error) {
 		}
 	}
 
-	switch t {
+	return rootJobType(t), nil
+}
+
+// rootJobType is the job type of a config.xml whose root element has the given name.
+func rootJobType(name string) jenkins.JobType {
+	switch name {
 	case "maven2-moduleset":
-		return jenkins.Maven, nil
+		return jenkins.Maven
 	case "project":
-		return jenkins.Freestyle, nil
+		return jenkins.Freestyle
 	}
-	return jenkins.Unknown, nil
+	return jenkins.Unknown
 }
 
 // templateWalker returns a filepath.Walker that finds files named fileName.  Found files are returned in the input string array.
@@ -57,7 +78,8 @@ func templateKey(project, slug string, projectType jenkins.JobType) string {
 }
 
 // projectCoordinates returns ("proj", "slug", nil) For input "/prefix/proj/slug/c.xml".  If the input is too short
-// to encompass proj/slug/c.xml an error is returned.  IF the input does not end in .xml an error is returned.
+// to encompass proj/slug/c.xml an error is returned.  IF the input does not end in .xml, and is not a settings file, an
+// error is returned.
 func projectCoordinates(fullPath string) (string, string, error) {
 	s := strings.ToLower(fullPath)
 	if strings.HasPrefix(s, "/") {
@@ -68,69 +90,146 @@ func projectCoordinates(fullPath string) (string, string, error) {
 	if len(parts) < 3 {
 		return "", "", fmt.Errorf("stashkins.GetTemplates Skipping invalid template repository record (Unexpected filesystem layout): %s\n", fullPath)
 	}
-	if !strings.HasSuffix(parts[len(parts)-1], ".xml") {
+	if !strings.HasSuffix(parts[len(parts)-1], ".xml") && parts[len(parts)-1] != settingsFileName {
 		return "", "", fmt.Errorf("stashkins.GetTemplates Skipping invalid template file not ending in .xml: %s\n", fullPath)
 	}
 
 	return parts[len(parts)-3], parts[len(parts)-2], nil
 }
 
-// buildTemplates iterates over the input file set and builds a map of pointers to JobTemplates.  We need pointer to a JobTemplate so we can mutate it
+// buildTemplates iterates over the input template sources and builds a map of pointers to JobTemplates.  We need pointer to a JobTemplate so we can mutate it
 // when augmenting found continuous templates with associated release template data.
-func buildTemplates(files []string, f func(projectKey, slug string, data []byte, jobType jenkins.JobType) *JobTemplate) map[string]*JobTemplate {
+func buildTemplates(sources []templateSource, f func(projectKey, slug string, data []byte, jobType jenkins.JobType) *JobTemplate) map[string]*JobTemplate {
 	templates := make(map[string]*JobTemplate)
 
+	for _, source := range sources {
+		projectKey, slug, data := source.projectKey, source.slug, source.data
+
+		jobType, err := jobType(data)
+		if err != nil {
+			Log.WithError(err).WithFields(logrus.Fields{"project": projectKey, "slug": slug, "file": source.file}).Warn("Skipping template of undeterminable job type")
+			continue
+		} else {
+			if jobType == jenkins.Unknown {
+				Log.WithFields(logrus.Fields{"project": projectKey, "slug": slug, "file": source.file}).Warn("Skipping template of unknown job type")
+				continue
+			}
+		}
+
+		templates[templateKey(projectKey, slug, jobType)] = f(projectKey, slug, data, jobType)
+	}
+	return templates
+}
+
+// attachTemplates moves each of the extra templates onto the first of the target maps holding a template for the same
+// repository and job type, using set to copy the template data.  Extra templates without a target are left in place.
+func attachTemplates(extras map[string]*JobTemplate, set func(target, extra *JobTemplate), targets ...map[string]*JobTemplate) {
+	for key, extra := range extras {
+		for _, target := range targets {
+			if t, present := target[key]; present {
+				set(t, extra)
+				delete(extras, key)
+				break
+			}
+		}
+	}
+}
+
+// readSettings reads the given settings files, returning their settings keyed by project-key/slug.  Lists are joined with
+// commas.  Why a file could not be read or parsed is returned keyed the same way, so that its repository can be skipped
+// rather than reconciled without its settings.
+func readSettings(files []string) (map[string]map[string]string, map[string]error) {
+	settings := make(map[string]map[string]string)
+	errs := make(map[string]error)
 	for _, file := range files {
 		projectKey, slug, err := projectCoordinates(file)
 		if err != nil {
-			Log.Printf("%v\n", err)
+			Log.WithError(err).WithField("file", file).Warn("Skipping settings")
 			continue
 		}
+		log := Log.WithFields(logrus.Fields{"project": projectKey, "slug": slug, "file": file})
 
 		data, err := ioutil.ReadFile(file)
 		if err != nil {
-			Log.Printf("stashkins.GetTemplates Skipping template repository record (Read template file) %s: %v\n", file, err)
+			log.WithError(err).Warn("Unreadable settings")
+			errs[projectKey+"/"+slug] = err
 			continue
 		}
-
-		jobType, err := jobType(data)
-		if err != nil {
-			Log.Printf("stashkins.GetTemplates Skipping template repository record %s: %v\n", file, err)
+		var values map[string]interface{}
+		if err := yaml.Unmarshal(data, &values); err != nil {
+			log.WithError(err).Warn("Malformed settings")
+			errs[projectKey+"/"+slug] = fmt.Errorf("cannot parse settings file %s: %v", file, err)
 			continue
-		} else {
-			if jobType == jenkins.Unknown {
-				Log.Printf("stashkins.GetTemplates Skipping template repository record (unknown type)  %s: %v\n", file, jobType)
-				continue
-			}
 		}
 
-		templates[templateKey(projectKey, slug, jobType)] = f(projectKey, slug, data, jobType)
+		repositorySettings := make(map[string]string, len(values))
+		for name, value := range values {
+			if list, ok := value.([]interface{}); ok {
+				items := make([]string, 0, len(list))
+				for _, item := range list {
+					items = append(items, fmt.Sprint(item))
+				}
+				repositorySettings[name] = strings.Join(items, ",")
+			} else {
+				repositorySettings[name] = fmt.Sprint(value)
+			}
+		}
+		settings[projectKey+"/"+slug] = repositorySettings
 	}
-	return templates
+	return settings, errs
 }
 
-func Templates(templateRepositoryURL, branch, cloneIntoDir string) ([]JobTemplate, error) {
-	if err := cloneTemplates(templateRepositoryURL, branch, cloneIntoDir); err != nil {
-		return nil, err
+// findTemplates finds every kind of template, and the settings files, at each level of the template repository checked out
+// at dir.  It returns them by file name, with the repositories they describe keyed by project-key/slug.  A repository is
+// known by its own template or settings files, since defaults apply only to known repositories.
+func findTemplates(dir string) (map[string]templateLevels, map[string][2]string, error) {
+	kinds := append(append([]string{}, templateFileNames...), settingsFileName)
+	levels := make(map[string]templateLevels, len(kinds))
+	for _, kind := range kinds {
+		files := make([]string, 0)
+		if err := filepath.Walk(dir, templateWalker(kind, &files)); err != nil {
+			return nil, nil, err
+		}
+		levels[kind] = classifyTemplates(dir, files)
+	}
+
+	repositories := make(map[string][2]string)
+	for _, kind := range kinds {
+		for key := range levels[kind].repositories {
+			parts := strings.SplitN(key, "/", 2)
+			repositories[key] = [2]string{parts[0], parts[1]}
+		}
 	}
+	return levels, repositories, nil
+}
 
-	continuousTemplateFiles := make([]string, 0)
-	if err := filepath.Walk(cloneIntoDir, templateWalker("continuous-template.xml", &continuousTemplateFiles)); err != nil {
+// Templates fetches the template repository from source, using workDir as the source needs, and returns its templates.
+func Templates(source TemplateSource, workDir string) ([]JobTemplate, error) {
+	dir, err := source.Fetch(workDir)
+	if err != nil {
 		return nil, err
 	}
+	return TemplatesFromDirectory(dir)
+}
 
-	releaseTemplateFiles := make([]string, 0)
-	if err := filepath.Walk(cloneIntoDir, templateWalker("release-template.xml", &releaseTemplateFiles)); err != nil {
+// TemplatesFromDirectory returns the templates of the template repository checked out at dir.
+func TemplatesFromDirectory(dir string) ([]JobTemplate, error) {
+	levels, repositories, err := findTemplates(dir)
+	if err != nil {
 		return nil, err
 	}
+	continuousTemplateSources := levels[continuousTemplateFileName].resolve(repositories)
+	releaseTemplateSources := levels[releaseTemplateFileName].resolve(repositories)
+	pullRequestTemplateSources := levels[pullRequestTemplateFileName].resolve(repositories)
+	tagTemplateSources := levels[tagTemplateFileName].resolve(repositories)
 
 	// A temporary auditing map to track continuous templates.
-	continuousTemplates := buildTemplates(continuousTemplateFiles, func(projectKey, slug string, data []byte, jobType jenkins.JobType) *JobTemplate {
+	continuousTemplates := buildTemplates(continuousTemplateSources, func(projectKey, slug string, data []byte, jobType jenkins.JobType) *JobTemplate {
 		return &JobTemplate{ProjectKey: projectKey, Slug: slug, ContinuousJobTemplate: data, JobType: jobType}
 	})
 
 	// A temporary auditing map to track release templates.
-	releaseTemplates := buildTemplates(releaseTemplateFiles, func(projectKey, slug string, data []byte, jobType jenkins.JobType) *JobTemplate {
+	releaseTemplates := buildTemplates(releaseTemplateSources, func(projectKey, slug string, data []byte, jobType jenkins.JobType) *JobTemplate {
 		return &JobTemplate{ProjectKey: projectKey, Slug: slug, ReleaseJobTemplate: data, JobType: jobType}
 	})
 
@@ -142,6 +241,24 @@ func Templates(templateRepositoryURL, branch, cloneIntoDir string) ([]JobTemplat
 		}
 	}
 
+	// A temporary auditing map to track pull request templates.
+	pullRequestTemplates := buildTemplates(pullRequestTemplateSources, func(projectKey, slug string, data []byte, jobType jenkins.JobType) *JobTemplate {
+		return &JobTemplate{ProjectKey: projectKey, Slug: slug, PullRequestJobTemplate: data, JobType: jobType}
+	})
+
+	// A temporary auditing map to track tag templates.
+	tagTemplates := buildTemplates(tagTemplateSources, func(projectKey, slug string, data []byte, jobType jenkins.JobType) *JobTemplate {
+		return &JobTemplate{ProjectKey: projectKey, Slug: slug, TagJobTemplate: data, JobType: jobType}
+	})
+
+	// Augment continuous templates, or failing those unassociated release templates, with pull request and tag templates.
+	attachTemplates(pullRequestTemplates, func(target, pullRequestTemplate *JobTemplate) {
+		target.PullRequestJobTemplate = pullRequestTemplate.PullRequestJobTemplate
+	}, continuousTemplates, releaseTemplates)
+	attachTemplates(tagTemplates, func(target, tagTemplate *JobTemplate) {
+		target.TagJobTemplate = tagTemplate.TagJobTemplate
+	}, continuousTemplates, releaseTemplates, pullRequestTemplates)
+
 	templates := make([]JobTemplate, 0)
 
 	// Add continuous templates to result
@@ -154,5 +271,21 @@ func Templates(templateRepositoryURL, branch, cloneIntoDir string) ([]JobTemplat
 		templates = append(templates, *template)
 	}
 
+	// Add pull request and tag templates associated with no other, for repositories that want only pull request or tag builds.
+	for _, template := range pullRequestTemplates {
+		templates = append(templates, *template)
+	}
+	for _, template := range tagTemplates {
+		templates = append(templates, *template)
+	}
+
+	// Attach each repository's settings, or why they could not be read, to its templates.
+	settings, settingsErrors := readSettings(levels[settingsFileName].files())
+	for i := range templates {
+		key := templates[i].ProjectKey + "/" + templates[i].Slug
+		templates[i].Settings = settings[key]
+		templates[i].SettingsError = settingsErrors[key]
+	}
+
 	return templates, nil
 }
@@ -112,6 +112,43 @@ func TestProjectCoordinates(t *testing.T) {
 	}
 }
 
+func TestReadSettings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "settings-")
+	if err != nil {
+		t.Fatalf("Unexpected error creating temp dir: %v\n", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := []string{filepath.Join(dir, "PROJ", "slug", settingsFileName), filepath.Join(dir, "proj", "broken", settingsFileName)}
+	contents := []string{"enabled: false\nmanaged-branch-prefixes: [feature/, hotfix/]\naspect: freestyle\n", "enabled: [\n"}
+	for i, file := range files {
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatalf("Unexpected error: %v\n", err)
+		}
+		if err := ioutil.WriteFile(file, []byte(contents[i]), 0644); err != nil {
+			t.Fatalf("Unexpected error: %v\n", err)
+		}
+	}
+
+	settings, errs := readSettings(files)
+	if len(settings) != 1 {
+		t.Fatalf("Want 1 but got %d\n", len(settings))
+	}
+	if err := errs["proj/broken"]; err == nil || !strings.Contains(err.Error(), files[1]) {
+		t.Fatalf("Want an error naming %s but got %v\n", files[1], err)
+	}
+	repositorySettings := settings["proj/slug"]
+	if repositorySettings["enabled"] != "false" {
+		t.Fatalf("Want false but got %s\n", repositorySettings["enabled"])
+	}
+	if repositorySettings["managed-branch-prefixes"] != "feature/,hotfix/" {
+		t.Fatalf("Want feature/,hotfix/ but got %s\n", repositorySettings["managed-branch-prefixes"])
+	}
+	if repositorySettings["aspect"] != "freestyle" {
+		t.Fatalf("Want freestyle but got %s\n", repositorySettings["aspect"])
+	}
+}
+
 func TestBuildTemplates(t *testing.T) {
 	sourceRepoDirectory, err := extractTestTemplates()
 	if err != nil {
@@ -123,7 +160,7 @@ func TestBuildTemplates(t *testing.T) {
 		t.Fatalf("Unexpected error creating temp dir: %v\n", err)
 	}
 
-	templates, err := Templates("file://"+sourceRepoDirectory, "master", cloneDirectory)
+	templates, err := Templates(GitTemplateSource{URL: "file://" + sourceRepoDirectory, Branch: "master"}, cloneDirectory)
 	if err != nil {
 		os.RemoveAll(cloneDirectory)
 		os.RemoveAll(sourceRepoDirectory)
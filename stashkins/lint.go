@@ -0,0 +1,213 @@
+package stashkins
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"text/template"
+
+	"github.com/xoom/jenkins"
+)
+
+// A LintProblem is a problem found in a template file as it applies to one repository.  A project or global default applies
+// to many repositories, and so may be reported for each.
+type LintProblem struct {
+	File       string
+	ProjectKey string
+	Slug       string
+	Message    string
+}
+
+func (p LintProblem) String() string {
+	return fmt.Sprintf("%s: %s/%s: %s", p.File, p.ProjectKey, p.Slug, p.Message)
+}
+
+// Sample values from which lint models are made.
+const (
+	lintFeatureBranch = "feature/PROJ-999"
+	lintCommit        = "0123456789abcdef0123456789abcdef01234567"
+)
+
+// LintTemplates checks every template in the template repository checked out at dir, as Templates would find it, for
+// every repository the template repository describes.  Each template must parse, must render against sample models made
+// as reconciliation would make them, and must render to well-formed XML whose root element is of the job type detected in
+// the template.  A repository's settings file must be readable and parse; if not, its templates are checked with no
+// repository settings.  setup supplies the Stashkins and Aspect for each repository.  Problems are returned by repository,
+// and by template kind within each repository.
+func LintTemplates(dir string, setup TemplateSetup) ([]LintProblem, error) {
+	levels, repositories, err := findTemplates(dir)
+	if err != nil {
+		return nil, err
+	}
+	settings, settingsErrors := readSettings(levels[settingsFileName].files())
+
+	keys := make([]string, 0, len(repositories))
+	for key := range repositories {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	problems := make([]LintProblem, 0)
+	for _, key := range keys {
+		if err := settingsErrors[key]; err != nil {
+			coordinates := repositories[key]
+			problems = append(problems, LintProblem{File: levels[settingsFileName].repositories[key], ProjectKey: coordinates[0], Slug: coordinates[1], Message: err.Error()})
+		}
+		for _, kind := range templateFileNames {
+			source, found, err := levels[kind].source(key, repositories[key])
+			if !found {
+				continue
+			}
+			if err != nil {
+				problems = append(problems, LintProblem{File: source.file, ProjectKey: source.projectKey, Slug: source.slug, Message: err.Error()})
+				continue
+			}
+			problems = append(problems, lintTemplate(kind, source, settings[key], setup)...)
+		}
+	}
+	return problems, nil
+}
+
+// lintTemplate checks one kind of template for one repository.
+func lintTemplate(kind string, source templateSource, settings map[string]string, setup TemplateSetup) []LintProblem {
+	problems := make([]LintProblem, 0)
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, LintProblem{File: source.file, ProjectKey: source.projectKey, Slug: source.slug, Message: fmt.Sprintf(format, args...)})
+	}
+
+	want, err := jobType(source.data)
+	if err != nil {
+		problem("cannot determine job type: %v", err)
+		return problems
+	}
+	if want == jenkins.Unknown {
+		problem("unknown job type: the root element must be maven2-moduleset or project")
+		return problems
+	}
+
+	if _, err := template.New("jobconfig").Funcs(templateFuncs).Parse(string(source.data)); err != nil {
+		problem("cannot parse: %v", err)
+		return problems
+	}
+
+	jobTemplate := JobTemplate{ProjectKey: source.projectKey, Slug: source.slug, JobType: want, Settings: settings}
+	switch kind {
+	case continuousTemplateFileName:
+		jobTemplate.ContinuousJobTemplate = source.data
+	case releaseTemplateFileName:
+		jobTemplate.ReleaseJobTemplate = source.data
+	case pullRequestTemplateFileName:
+		jobTemplate.PullRequestJobTemplate = source.data
+	case tagTemplateFileName:
+		jobTemplate.TagJobTemplate = source.data
+	}
+	skins, aspect := setup(jobTemplate)
+	if aspect == nil && (kind == continuousTemplateFileName || kind == releaseTemplateFileName) {
+		problem("no aspect for the template's job type")
+		return problems
+	}
+
+	models := skins.lintModels(kind, jobTemplate, aspect)
+	names := make([]string, 0, len(models))
+	for name := range models {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		rendered, err := skins.renderJob(source.data, name, models[name])
+		if err != nil {
+			problem("cannot render for %s: %v", name, err)
+			continue
+		}
+		root, err := xmlRoot(rendered)
+		if err != nil {
+			problem("rendered for %s is not well-formed XML: %v", name, err)
+			continue
+		}
+		if rootJobType(root) != want {
+			problem("rendered for %s has root element %s, which is not of the template's job type", name, root)
+		}
+	}
+	return problems
+}
+
+// lintModels are sample models for a kind of template, keyed by the names of the jobs they describe.  Continuous templates
+// are rendered for a feature branch and for the release branch.
+func (c DefaultStashkins) lintModels(kind string, jobTemplate JobTemplate, aspect Aspect) map[string]interface{} {
+	projectKey, slug := jobTemplate.ProjectKey, jobTemplate.Slug
+	releaseBranch := c.branchOperations.releaseBranch()
+	state := sampleState(projectKey, slug, lintFeatureBranch, releaseBranch)
+
+	models := make(map[string]interface{})
+	switch kind {
+	case continuousTemplateFileName:
+		for _, name := range []string{lintFeatureBranch, releaseBranch} {
+			branch := branchFromName(name)
+			jobName := c.canonicalCIJobName(projectKey, slug, branch)
+			models[jobName] = c.jobModel(aspect, jobName, c.continuousJobDescription(jobTemplate, branch), name, jobTemplate, state)
+		}
+	case releaseTemplateFileName:
+		jobName := c.canonicalReleaseJobName(projectKey, slug)
+		models[jobName] = c.jobModel(aspect, jobName, c.releaseJobDescription(jobTemplate), releaseBranch, jobTemplate, state)
+	case pullRequestTemplateFileName:
+		pullRequest := PullRequest{
+			ID:           1,
+			Title:        "Sample <pull request> & title",
+			Author:       "jdoe",
+			SourceBranch: lintFeatureBranch,
+			TargetBranch: releaseBranch,
+			MergeRef:     "refs/pull-requests/1/merge",
+		}
+		job := PullRequestJobDescriptor{JobName: c.canonicalPullRequestJobName(projectKey, slug, pullRequest), PullRequest: pullRequest}
+		models[job.JobName] = c.pullRequestModel(job, jobTemplate, state)
+	case tagTemplateFileName:
+		tag := Tag{Name: "v1.0.0", Commit: lintCommit}
+		job := TagJobDescriptor{JobName: c.canonicalTagJobName(projectKey, slug, tag), Tag: tag}
+		models[job.JobName] = c.tagModel(job, jobTemplate, state)
+	}
+	return models
+}
+
+// sampleState describes a repository without asking its SCM provider, with sample clone URLs and a sample commit at the
+// head of each of the given branches.
+func sampleState(projectKey, slug string, branches ...string) scmState {
+	state := scmState{
+		repository: SCMRepository{
+			ProjectKey:   projectKey,
+			Slug:         slug,
+			CloneURL:     fmt.Sprintf("ssh://git@scm.example.com/%s/%s.git", projectKey, slug),
+			HTTPCloneURL: fmt.Sprintf("https://scm.example.com/scm/%s/%s.git", projectKey, slug),
+		},
+		heads: make(map[string]BranchHead, len(branches)),
+	}
+	for _, branch := range branches {
+		state.heads[branch] = BranchHead{Commit: lintCommit}
+	}
+	return state
+}
+
+// xmlRoot returns the name of the root element of a well-formed XML document, or an error if the document is not
+// well-formed.
+func xmlRoot(document []byte) (string, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(document))
+	var root string
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if element, ok := token.(xml.StartElement); ok && root == "" {
+			root = element.Name.Local
+		}
+	}
+	if root == "" {
+		return "", fmt.Errorf("no root element")
+	}
+	return root, nil
+}
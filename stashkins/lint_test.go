@@ -0,0 +1,82 @@
+package stashkins
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xoom/jenkins"
+)
+
+func TestLintTemplates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "templates-")
+	if err != nil {
+		t.Fatalf("Unexpected error creating temp dir: %v\n", err)
+	}
+	defer os.RemoveAll(dir)
+
+	contents := map[string]string{
+		"proj/good/continuous-template.xml":       `<maven2-moduleset><description>{{xml .Description}}</description><url>{{.MavenSnapshotRepositoryURL}}</url></maven2-moduleset>`,
+		"proj/good/pullrequest-template.xml":      `<project><description>{{.Title}}</description></project>`,
+		"proj/good/tag-template.xml":              `<project><description>{{.TagName}} {{.LatestCommit}}</description></project>`,
+		"proj/unparsed/continuous-template.xml":   `<project>{{.BranchName</project>`,
+		"proj/unrendered/release-template.xml":    `<project>{{.MavenRepositoryID}}</project>`,
+		"proj/malformed/continuous-template.xml":  `<project><description>{{.BranchName}}</project>`,
+		"proj/mismatched/continuous-template.xml": `{{if .BranchSuffix}}<project/>{{else}}<matrix-project/>{{end}}`,
+		"proj/unknown/continuous-template.xml":    `<matrix-project/>`,
+		"proj/garbled/continuous-template.xml":    `<project><description>{{.BranchName}}</description></project>`,
+		"proj/garbled/stashkins.yaml":             "enabled: [\n",
+	}
+	for name, content := range contents {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatalf("Unexpected error: %v\n", err)
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatalf("Unexpected error: %v\n", err)
+		}
+	}
+
+	setup := func(jobTemplate JobTemplate) (DefaultStashkins, Aspect) {
+		switch jobTemplate.JobType {
+		case jenkins.Maven:
+			return DefaultStashkins{}, NewMavenAspect(MavenRepositoryParams{WebClientParams: WebClientParams{URL: "http://nexus"}}, nil, BranchOperations{})
+		case jenkins.Freestyle:
+			return DefaultStashkins{}, NewFreestyleAspect()
+		}
+		return DefaultStashkins{}, nil
+	}
+	problems, err := LintTemplates(dir, setup)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+
+	want := map[string]string{
+		"unparsed":   "cannot parse",
+		"unrendered": "MavenRepositoryID",
+		"malformed":  "not well-formed XML",
+		"mismatched": "root element matrix-project",
+		"unknown":    "unknown job type",
+		"garbled":    "cannot parse settings file",
+	}
+	found := make(map[string]bool)
+	for _, problem := range problems {
+		found[problem.Slug] = true
+		if !strings.Contains(problem.Message, want[problem.Slug]) {
+			t.Fatalf("Want a problem mentioning %s for %s but got %s\n", want[problem.Slug], problem.Slug, problem)
+		}
+		if !strings.HasSuffix(problem.File, filepath.Join("proj", problem.Slug, filepath.Base(problem.File))) {
+			t.Fatalf("Want the problem reported against %s's file but got %s\n", problem.Slug, problem.File)
+		}
+	}
+	for _, problem := range problems {
+		if problem.Slug == "garbled" && filepath.Base(problem.File) != settingsFileName {
+			t.Fatalf("Want the settings file named but got %s\n", problem.File)
+		}
+	}
+	if len(found) != len(want) {
+		t.Fatalf("Want problems for %d repositories but got %v\n", len(want), problems)
+	}
+}
@@ -0,0 +1,57 @@
+package stashkins
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Log is the leveled, structured logger used throughout Stashkins.  Entries about a repository carry project and slug
+// fields, and where they apply branch, job and aspect fields, so that one repository's entries can be selected from a
+// run across hundreds.
+var Log = newLogger()
+
+func newLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.Out = os.Stdout
+	logger.Formatter = logfmtFormatter()
+	return logger
+}
+
+func logfmtFormatter() logrus.Formatter {
+	return &logrus.TextFormatter{DisableColors: true, FullTimestamp: true}
+}
+
+// ConfigureLogging sets the minimum level logged, one of debug, info, warning or error, and the format, logfmt or json.
+func ConfigureLogging(level, format string) error {
+	logLevel, err := logrus.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case "logfmt":
+		Log.Formatter = logfmtFormatter()
+	case "json":
+		Log.Formatter = &logrus.JSONFormatter{}
+	default:
+		return fmt.Errorf("stashkins.ConfigureLogging unsupported log format %s", format)
+	}
+	Log.Level = logLevel
+	return nil
+}
+
+// repositoryLog returns a log entry for the template's repository.
+func repositoryLog(jobTemplate JobTemplate) *logrus.Entry {
+	return Log.WithFields(logrus.Fields{"project": jobTemplate.ProjectKey, "slug": jobTemplate.Slug})
+}
+
+// jobLog returns a log entry for a job of the template's repository.  An empty branch is left out.
+func jobLog(jobTemplate JobTemplate, jobName, branch string) *logrus.Entry {
+	entry := repositoryLog(jobTemplate).WithField("job", jobName)
+	if branch != "" {
+		entry = entry.WithField("branch", branch)
+	}
+	return entry
+}
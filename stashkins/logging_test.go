@@ -0,0 +1,57 @@
+package stashkins
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestConfigureLogging(t *testing.T) {
+	defer func() {
+		Log.Out = os.Stdout
+		ConfigureLogging("info", "logfmt")
+	}()
+
+	var buf bytes.Buffer
+	Log.Out = &buf
+
+	if err := ConfigureLogging("warning", "json"); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	jobTemplate := JobTemplate{ProjectKey: "proj", Slug: "slug"}
+	jobLog(jobTemplate, "proj-slug-continuous-feature-1", "feature/1").Info("not logged")
+	jobLog(jobTemplate, "proj-slug-continuous-feature-1", "feature/1").Warn("logged")
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("Want one JSON entry but got %s: %v\n", buf.String(), err)
+	}
+	for k, want := range map[string]string{"level": "warning", "msg": "logged", "project": "proj", "slug": "slug", "job": "proj-slug-continuous-feature-1", "branch": "feature/1"} {
+		if entry[k] != want {
+			t.Fatalf("Want %s=%s but got %v\n", k, want, entry[k])
+		}
+	}
+
+	buf.Reset()
+	if err := ConfigureLogging("debug", "logfmt"); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	repositoryLog(jobTemplate).Debug("logged")
+	if got := buf.String(); !strings.Contains(got, "level=debug") || !strings.Contains(got, "project=proj") || !strings.Contains(got, "slug=slug") {
+		t.Fatalf("Want a logfmt debug entry with project and slug but got %s\n", got)
+	}
+
+	if err := ConfigureLogging("loud", "json"); err == nil {
+		t.Fatalf("Want an error for an unknown level\n")
+	}
+	if err := ConfigureLogging("info", "xml"); err == nil {
+		t.Fatalf("Want an error for an unknown format\n")
+	}
+	if Log.Level != logrus.DebugLevel {
+		t.Fatalf("Want level unchanged by failed configuration but got %v\n", Log.Level)
+	}
+}
@@ -8,20 +8,23 @@ import (
 	"unicode"
 
 	"github.com/ae6rt/retry"
+	"github.com/sirupsen/logrus"
 	"github.com/xoom/maventools"
 )
 
 const postCreatorAgent = "Maven postCreator"
+const mavenRepositoryKind = "maven-repository"
 const postDeleterAgent = "Maven postDeleter"
+const mavenAspectName = "maven"
 
 type MavenAspect struct {
 	mavenRepositoryParams MavenRepositoryParams
-	client                maventools.NexusClient
+	client                MavenRepositoryClient
 	branchOperations      BranchOperations
 	Aspect
 }
 
-func NewMavenAspect(params MavenRepositoryParams, client maventools.NexusClient, branchOperations BranchOperations) Aspect {
+func NewMavenAspect(params MavenRepositoryParams, client MavenRepositoryClient, branchOperations BranchOperations) Aspect {
 	return MavenAspect{mavenRepositoryParams: params, client: client, branchOperations: branchOperations}
 }
 
@@ -37,69 +40,109 @@ func (maven MavenAspect) MakeModel(newJobName, newJobDescription, gitRepositoryU
 }
 
 func (maven MavenAspect) PostJobDeleteTasks(jobName, gitRepositoryURL, branch string, templateRecord JobTemplate) error {
+	log := maven.log(postDeleterAgent, jobName, branch, templateRecord)
 	if !maven.branchOperations.isFeatureBranch(branch) {
-		Log.Printf("%s:  skipping tasks for non-feature branch %s:\n", postDeleterAgent, branch)
+		log.Debug("Skipping tasks for non-feature branch")
 		return nil
 	}
 
 	repositoryID := maventools.RepositoryID(maven.repositoryID(templateRecord.ProjectKey, templateRecord.Slug, branch))
+	log = log.WithField("maven_repository", repositoryID)
 	if _, err := maven.client.DeleteRepository(repositoryID); err != nil {
-		Log.Printf("%s: failed to delete Maven repository %v: %+v\n", postDeleterAgent, repositoryID, err)
+		log.WithError(err).Error("Cannot delete Maven repository")
 		return err
 	} else {
-		Log.Printf("%s: deleted Maven repository %v\n", postDeleterAgent, repositoryID)
+		log.Info("Deleted Maven repository")
 	}
 	return nil
 }
 
 func (maven MavenAspect) PostJobCreateTasks(newJobName, newJobDescription, gitRepositoryURL, branch string, templateRecord JobTemplate) error {
-
+	log := maven.log(postCreatorAgent, newJobName, branch, templateRecord)
 	if !maven.branchOperations.isFeatureBranch(branch) {
-		Log.Printf("%s: skipping tasks for non-feature branch %s\n", postCreatorAgent, branch)
+		log.Debug("Skipping tasks for non-feature branch")
 		return nil
 	}
 
 	repositoryID := maventools.RepositoryID(maven.repositoryID(templateRecord.ProjectKey, templateRecord.Slug, branch))
+	log = log.WithField("maven_repository", repositoryID)
 	if present, err := maven.client.RepositoryExists(repositoryID); err == nil && !present {
 		if _, err := maven.client.CreateSnapshotRepository(repositoryID); err != nil {
-			Log.Printf("%s: failed to create Maven repository %v: %+v\n", postCreatorAgent, repositoryID, err)
+			log.WithError(err).Error("Cannot create Maven repository")
 			return err
 		} else {
-			Log.Printf("%s: created Maven repositoryID %v\n", postCreatorAgent, repositoryID)
+			log.Info("Created Maven repository")
 			// falls through to add the repository
 		}
 	} else if err != nil {
-		Log.Printf("%s: error checking if Maven repositoryID %v exists: %v\n", postCreatorAgent, repositoryID, err)
+		log.WithError(err).Error("Cannot determine whether Maven repository exists")
 		return err
 	} else {
-		Log.Printf("%s: Maven repositoryID %v exists.  Skipping.\n", postCreatorAgent, repositoryID)
+		log.Info("Maven repository exists.  Skipping.")
 		// we historically allow this to fall through and re-add the repository
 	}
 
 	if err := maven.waitForRepositoryToSettle(repositoryID); err != nil {
-		Log.Printf("%s: per-branch repository %s does not exist or error trying to determine as much.\n", postCreatorAgent, err)
+		log.WithError(err).Error("Per-branch repository does not exist or error trying to determine as much")
 		return err
 	}
 
+	log = log.WithField("maven_group", maven.mavenRepositoryParams.FeatureBranchRepositoryGroupID)
 	repositoryGroupID := maventools.GroupID(maven.mavenRepositoryParams.FeatureBranchRepositoryGroupID)
 	if rc, err := maven.client.AddRepositoryToGroup(repositoryID, repositoryGroupID); err != nil {
-		Log.Printf("%s: failed to add Maven repository %s to repository group %v: %+v\n", postCreatorAgent, repositoryID, maven.mavenRepositoryParams.FeatureBranchRepositoryGroupID, err)
+		log.WithError(err).Error("Cannot add Maven repository to repository group")
 		return err
 	} else {
 		if rc == 200 {
-			Log.Printf("%s: repositoryID %v added to repository groupID %s\n", postCreatorAgent, repositoryID, maven.mavenRepositoryParams.FeatureBranchRepositoryGroupID)
+			log.Info("Added Maven repository to repository group")
 		}
 	}
 	return nil
 }
 
+func (maven MavenAspect) PlanJobDeleteTasks(jobName, gitRepositoryURL, branch string, templateRecord JobTemplate) []string {
+	if !maven.branchOperations.isFeatureBranch(branch) {
+		return nil
+	}
+	return []string{fmt.Sprintf("delete Maven repository %s", maven.repositoryID(templateRecord.ProjectKey, templateRecord.Slug, branch))}
+}
+
+// PlanJobCreateTasks makes only read calls to Nexus to determine whether the per-branch repository must be created.
+func (maven MavenAspect) PlanJobCreateTasks(newJobName, gitRepositoryURL, branch string, templateRecord JobTemplate) []string {
+	if !maven.branchOperations.isFeatureBranch(branch) {
+		return nil
+	}
+
+	repositoryID := maventools.RepositoryID(maven.repositoryID(templateRecord.ProjectKey, templateRecord.Slug, branch))
+	tasks := make([]string, 0)
+	if present, err := maven.client.RepositoryExists(repositoryID); err != nil {
+		tasks = append(tasks, fmt.Sprintf("create Maven repository %v if absent (existence check failed: %v)", repositoryID, err))
+	} else if !present {
+		tasks = append(tasks, fmt.Sprintf("create Maven repository %v", repositoryID))
+	}
+	return append(tasks, fmt.Sprintf("add Maven repository %v to repository group %s", repositoryID, maven.mavenRepositoryParams.FeatureBranchRepositoryGroupID))
+}
+
+// Resources returns the per-branch Maven repository managed alongside a job for a feature branch.
+func (maven MavenAspect) Resources(branch string, templateRecord JobTemplate) []Resource {
+	if !maven.branchOperations.isFeatureBranch(branch) {
+		return nil
+	}
+	return []Resource{Resource{Kind: mavenRepositoryKind, Name: maven.repositoryID(templateRecord.ProjectKey, templateRecord.Slug, branch)}}
+}
+
+// log returns a log entry for the aspect's tasks on a job.
+func (maven MavenAspect) log(agent, jobName, branch string, templateRecord JobTemplate) *logrus.Entry {
+	return jobLog(templateRecord, jobName, branch).WithFields(logrus.Fields{"aspect": mavenAspectName, "agent": agent})
+}
+
 func (maven MavenAspect) waitForRepositoryToSettle(repositoryID maventools.RepositoryID) error {
 	retry := retry.New(4, func(attempts int) {
 		if attempts == 0 {
 			return
 		}
 		if attempts > 2 {
-			Log.Printf("%s: wait for repository-exists with-backoff try %d\n", postCreatorAgent, attempts+1)
+			Log.WithFields(logrus.Fields{"aspect": mavenAspectName, "agent": postCreatorAgent, "maven_repository": repositoryID, "attempt": attempts + 1}).Warn("Waiting for Maven repository to exist")
 		}
 		time.Sleep((1 << uint(attempts)) * time.Second)
 	})
@@ -121,21 +164,14 @@ func (maven MavenAspect) waitForRepositoryToSettle(repositoryID maventools.Repos
 }
 
 func (maven MavenAspect) repositoryURL(gitProjectKey, gitRepositorySlug, gitBranch string) string {
-	var mavenSnapshotRepositoryURL string
-	if gitBranch == "develop" {
-		mavenSnapshotRepositoryURL = fmt.Sprintf("%s/content/repositories/snapshots", maven.mavenRepositoryParams.URL)
-	} else {
-		// For feature/ branches, use per-branch repositories
-		mavenSnapshotRepositoryID := maven.repositoryID(gitProjectKey, gitRepositorySlug, gitBranch)
-		mavenSnapshotRepositoryURL = fmt.Sprintf("%s/content/repositories/%s", maven.mavenRepositoryParams.URL, mavenSnapshotRepositoryID)
-	}
-	return mavenSnapshotRepositoryURL
+	// Mainline branches share their snapshot repository.  Feature branches use per-branch repositories.
+	return fmt.Sprintf("%s/content/repositories/%s", maven.mavenRepositoryParams.URL, maven.repositoryID(gitProjectKey, gitRepositorySlug, gitBranch))
 }
 
 func (maven MavenAspect) repositoryID(gitRepoProjectKey, gitRepoSlug, gitBranch string) string {
 	branch := maven.branchOperations.stripLeadingOrigin(gitBranch)
-	if branch == "develop" {
-		return "snapshots"
+	if mainline, ok := maven.branchOperations.mainline(branch); ok {
+		return mainline.SnapshotRepository
 	}
 	return maven.scrubRepositoryID(fmt.Sprintf("%s.%s.%s", gitRepoProjectKey, gitRepoSlug, branch))
 }
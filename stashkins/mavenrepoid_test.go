@@ -36,3 +36,22 @@ func TestMavenRepoID(t *testing.T) {
 		t.Fatalf("Want snapshots but got %s\n", s)
 	}
 }
+
+func TestMavenRepoIDMainlines(t *testing.T) {
+	o := MavenAspect{
+		branchOperations: BranchOperations{
+			ManagedPrefixes: []string{"feature/", "release/"},
+			Mainlines:       []MainlineBranch{{Pattern: "main", SnapshotRepository: "snapshots"}, {Pattern: "release/*", SnapshotRepository: "release-snapshots"}},
+		},
+	}
+
+	if s := o.repositoryID("INF", "test-me", "origin/main"); s != "snapshots" {
+		t.Fatalf("Want snapshots but got %s\n", s)
+	}
+	if s := o.repositoryID("INF", "test-me", "release/1.2"); s != "release-snapshots" {
+		t.Fatalf("Want release-snapshots but got %s\n", s)
+	}
+	if s := o.repositoryID("INF", "test-me", "develop"); s != "INF.test-me.develop" {
+		t.Fatalf("Want INF.test-me.develop but got %s\n", s)
+	}
+}
@@ -0,0 +1,276 @@
+package stashkins
+
+import (
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/expfmt"
+	"github.com/xoom/jenkins"
+	"github.com/xoom/maventools"
+	"github.com/xoom/stash"
+)
+
+const (
+	backendStash   = "stash"
+	backendJenkins = "jenkins"
+	backendNexus   = "nexus"
+
+	lastSuccessMetric = "stashkins_last_success_timestamp_seconds"
+)
+
+// Metrics are the Prometheus metrics of reconciliation runs.  A nil *Metrics records nothing.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	operations       *prometheus.CounterVec
+	repositoryErrors prometheus.Counter
+	requests         *prometheus.HistogramVec
+	requestErrors    *prometheus.CounterVec
+	runDuration      prometheus.Histogram
+	lastRun          prometheus.Gauge
+	lastSuccess      prometheus.Gauge
+}
+
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		operations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "stashkins_operations_total",
+			Help: "Jobs and Maven repositories created, updated, archived or deleted, by kind, action and status.",
+		}, []string{"kind", "action", "status"}),
+		repositoryErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "stashkins_repository_errors_total",
+			Help: "Repositories whose reconciliation could not be completed.",
+		}),
+		requests: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "stashkins_backend_request_duration_seconds",
+			Help: "Latency of requests to Stash, Jenkins and Nexus.",
+		}, []string{"backend", "operation"}),
+		requestErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "stashkins_backend_request_errors_total",
+			Help: "Failed requests to Stash, Jenkins and Nexus.",
+		}, []string{"backend", "operation"}),
+		runDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "stashkins_run_duration_seconds",
+			Help:    "Duration of reconciliation runs of every template.",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+		}),
+		lastRun: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "stashkins_last_run_timestamp_seconds",
+			Help: "Time the last reconciliation run finished.",
+		}),
+		lastSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: lastSuccessMetric,
+			Help: "Time the last reconciliation run finished with every repository reconciled and no failed operations.",
+		}),
+	}
+	m.registry.MustRegister(m.operations, m.repositoryErrors, m.requests, m.requestErrors, m.runDuration, m.lastRun, m.lastSuccess)
+	return m
+}
+
+// ObserveReport counts the operations in a repository's report.
+func (m *Metrics) ObserveReport(report RepositoryReport) {
+	if m == nil {
+		return
+	}
+	if report.Error != "" {
+		m.repositoryErrors.Inc()
+	}
+	for _, entry := range report.Entries {
+		m.operations.WithLabelValues(entry.Kind, entry.Action, entry.Status).Inc()
+	}
+}
+
+// ObserveRun records a run of every template.  A run succeeds if it reconciled every repository without failures.
+func (m *Metrics) ObserveRun(started, finished time.Time, succeeded bool) {
+	if m == nil {
+		return
+	}
+	m.runDuration.Observe(finished.Sub(started).Seconds())
+	m.lastRun.Set(float64(finished.Unix()))
+	if succeeded {
+		m.lastSuccess.Set(float64(finished.Unix()))
+	}
+}
+
+// observeRequest records the latency and outcome of a backend request begun at start.
+func (m *Metrics) observeRequest(backend, operation string, start time.Time, err error) {
+	if m == nil {
+		return
+	}
+	m.requests.WithLabelValues(backend, operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		m.requestErrors.WithLabelValues(backend, operation).Inc()
+	}
+}
+
+// Handler serves the metrics for scraping.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// WriteTextfile atomically writes the metrics to fileName for the node exporter textfile collector.
+func (m *Metrics) WriteTextfile(fileName string) error {
+	return prometheus.WriteToTextfile(fileName, m.registry)
+}
+
+// LoadLastSuccess carries the last success time forward from a textfile written by an earlier run, so that a failed
+// one-shot run does not reset it.  A missing file is not an error.
+func (m *Metrics) LoadLastSuccess(fileName string) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(f)
+	if err != nil {
+		return err
+	}
+	if family, present := families[lastSuccessMetric]; present && len(family.Metric) > 0 && family.Metric[0].Gauge != nil {
+		m.lastSuccess.Set(family.Metric[0].Gauge.GetValue())
+	}
+	return nil
+}
+
+// RunSucceeded reports whether every repository was reconciled without failed operations.
+func RunSucceeded(reports []RepositoryReport) bool {
+	for _, report := range reports {
+		if report.Error != "" || report.Count("", "", StatusFailed) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+type instrumentedSCM struct {
+	SCMProvider
+	backend string
+	metrics *Metrics
+}
+
+func (i instrumentedSCM) Repository(projectKey, slug string) (SCMRepository, error) {
+	start := time.Now()
+	repository, err := i.SCMProvider.Repository(projectKey, slug)
+	i.metrics.observeRequest(i.backend, "get_repository", start, err)
+	return repository, err
+}
+
+func (i instrumentedSCM) Branches(projectKey, slug string) (map[string]stash.Branch, error) {
+	start := time.Now()
+	branches, err := i.SCMProvider.Branches(projectKey, slug)
+	i.metrics.observeRequest(i.backend, "get_branches", start, err)
+	return branches, err
+}
+
+func (i instrumentedSCM) BranchHeads(projectKey, slug string) (map[string]BranchHead, error) {
+	start := time.Now()
+	heads, err := i.SCMProvider.BranchHeads(projectKey, slug)
+	i.metrics.observeRequest(i.backend, "get_branch_heads", start, err)
+	return heads, err
+}
+
+func (i instrumentedSCM) PullRequests(projectKey, slug string) ([]PullRequest, error) {
+	start := time.Now()
+	pullRequests, err := i.SCMProvider.PullRequests(projectKey, slug)
+	i.metrics.observeRequest(i.backend, "get_pull_requests", start, err)
+	return pullRequests, err
+}
+
+func (i instrumentedSCM) Tags(projectKey, slug string) ([]Tag, error) {
+	start := time.Now()
+	tags, err := i.SCMProvider.Tags(projectKey, slug)
+	i.metrics.observeRequest(i.backend, "get_tags", start, err)
+	return tags, err
+}
+
+// scmBackend names the backend label of requests made by p, which is the provider kind.
+func scmBackend(p SCMProvider) string {
+	switch p.(type) {
+	case bitbucketServerProvider:
+		return SCMBitbucketServer
+	case gitHubProvider:
+		return SCMGitHub
+	case gitLabProvider:
+		return SCMGitLab
+	case giteaProvider:
+		return SCMGitea
+	}
+	return backendStash
+}
+
+type instrumentedJenkins struct {
+	jenkins.Jenkins
+	metrics *Metrics
+}
+
+func (i instrumentedJenkins) GetJobSummaries() ([]jenkins.JobSummary, error) {
+	start := time.Now()
+	jobSummaries, err := i.Jenkins.GetJobSummaries()
+	i.metrics.observeRequest(backendJenkins, "get_job_summaries", start, err)
+	return jobSummaries, err
+}
+
+func (i instrumentedJenkins) CreateJob(jobName, jobConfigXML string) error {
+	start := time.Now()
+	err := i.Jenkins.CreateJob(jobName, jobConfigXML)
+	i.metrics.observeRequest(backendJenkins, "create_job", start, err)
+	return err
+}
+
+func (i instrumentedJenkins) DeleteJob(jobName string) error {
+	start := time.Now()
+	err := i.Jenkins.DeleteJob(jobName)
+	i.metrics.observeRequest(backendJenkins, "delete_job", start, err)
+	return err
+}
+
+type instrumentedNexus struct {
+	MavenRepositoryClient
+	metrics *Metrics
+}
+
+func (i instrumentedNexus) RepositoryExists(repositoryID maventools.RepositoryID) (bool, error) {
+	start := time.Now()
+	exists, err := i.MavenRepositoryClient.RepositoryExists(repositoryID)
+	i.metrics.observeRequest(backendNexus, "repository_exists", start, err)
+	return exists, err
+}
+
+func (i instrumentedNexus) CreateSnapshotRepository(repositoryID maventools.RepositoryID) (int, error) {
+	start := time.Now()
+	rc, err := i.MavenRepositoryClient.CreateSnapshotRepository(repositoryID)
+	i.metrics.observeRequest(backendNexus, "create_repository", start, err)
+	return rc, err
+}
+
+func (i instrumentedNexus) DeleteRepository(repositoryID maventools.RepositoryID) (int, error) {
+	start := time.Now()
+	rc, err := i.MavenRepositoryClient.DeleteRepository(repositoryID)
+	i.metrics.observeRequest(backendNexus, "delete_repository", start, err)
+	return rc, err
+}
+
+func (i instrumentedNexus) AddRepositoryToGroup(repositoryID maventools.RepositoryID, groupID maventools.GroupID) (int, error) {
+	start := time.Now()
+	rc, err := i.MavenRepositoryClient.AddRepositoryToGroup(repositoryID, groupID)
+	i.metrics.observeRequest(backendNexus, "add_repository_to_group", start, err)
+	return rc, err
+}
+
+// WithMetrics returns a copy of c that records its backend requests and repository reports in m.  Apply it before
+// WithConcurrencyLimits so that request latency does not include time spent waiting for a concurrency slot.
+func (c DefaultStashkins) WithMetrics(m *Metrics) DefaultStashkins {
+	c.metrics = m
+	c.scm = instrumentedSCM{SCMProvider: c.scm, backend: scmBackend(c.scm), metrics: m}
+	c.jenkinsClient = instrumentedJenkins{Jenkins: c.jenkinsClient, metrics: m}
+	c.NexusClient = instrumentedNexus{MavenRepositoryClient: c.NexusClient, metrics: m}
+	return c
+}
@@ -0,0 +1,105 @@
+package stashkins
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xoom/stash"
+)
+
+type failingStash struct {
+	stash.Stash
+}
+
+func (f failingStash) GetBranches(projectKey, repositorySlug string) (map[string]stash.Branch, error) {
+	return nil, errors.New("unavailable")
+}
+
+func readMetrics(t *testing.T, m *Metrics, fileName string) string {
+	if err := m.WriteTextfile(fileName); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	return string(data)
+}
+
+func TestMetrics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stashkins-metrics-")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "stashkins.prom")
+
+	m := NewMetrics()
+	report := NewRepositoryReport(JobTemplate{ProjectKey: "proj", Slug: "slug"})
+	report.record(KindJob, "proj-slug-continuous-feature-1", "feature/1", ActionCreate, nil)
+	report.record(KindJob, "proj-slug-continuous-feature-2", "feature/2", ActionCreate, nil)
+	report.record(mavenRepositoryKind, "proj.slug.feature_3", "feature/3", ActionDelete, errors.New("failed"))
+	m.ObserveReport(report)
+
+	skins := DefaultStashkins{scm: NewStashProvider(failingStash{})}.WithMetrics(m)
+	skins.scm.Branches("proj", "slug")
+
+	finished := time.Unix(1500000000, 0)
+	m.ObserveRun(finished.Add(-time.Minute), finished, true)
+	m.ObserveRun(finished.Add(time.Minute), finished.Add(2*time.Minute), false)
+
+	text := readMetrics(t, m, fileName)
+	for _, want := range []string{
+		`stashkins_operations_total{action="create",kind="job",status="done"} 2`,
+		`stashkins_operations_total{action="delete",kind="maven-repository",status="failed"} 1`,
+		`stashkins_backend_request_errors_total{backend="stash",operation="get_branches"} 1`,
+		`stashkins_backend_request_duration_seconds_count{backend="stash",operation="get_branches"} 1`,
+		`stashkins_run_duration_seconds_count 2`,
+		`stashkins_last_run_timestamp_seconds 1.50000012e+09`,
+		`stashkins_last_success_timestamp_seconds 1.5e+09`,
+	} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("Want metrics to contain %q but got %s\n", want, text)
+		}
+	}
+
+	next := NewMetrics()
+	if err := next.LoadLastSuccess(fileName); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if text := readMetrics(t, next, filepath.Join(dir, "next.prom")); !strings.Contains(text, `stashkins_last_success_timestamp_seconds 1.5e+09`) {
+		t.Fatalf("Want last success carried forward but got %s\n", text)
+	}
+	if err := next.LoadLastSuccess(filepath.Join(dir, "missing.prom")); err != nil {
+		t.Fatalf("Want no error for a missing file but got %v\n", err)
+	}
+}
+
+func TestNilMetrics(t *testing.T) {
+	var m *Metrics
+	m.ObserveReport(RepositoryReport{Error: "failed"})
+	m.ObserveRun(time.Now(), time.Now(), true)
+	m.observeRequest(backendJenkins, "create_job", time.Now(), nil)
+}
+
+func TestRunSucceeded(t *testing.T) {
+	done := NewRepositoryReport(JobTemplate{ProjectKey: "proj", Slug: "a"})
+	done.record(KindJob, "job", "feature/1", ActionCreate, nil)
+	failed := NewRepositoryReport(JobTemplate{ProjectKey: "proj", Slug: "b"})
+	failed.record(KindJob, "job", "feature/1", ActionCreate, errors.New("failed"))
+
+	if !RunSucceeded([]RepositoryReport{done}) {
+		t.Fatalf("Want success\n")
+	}
+	if RunSucceeded([]RepositoryReport{done, failed}) {
+		t.Fatalf("Want failure for a failed operation\n")
+	}
+	if RunSucceeded([]RepositoryReport{RepositoryReport{Error: "stopped"}}) {
+		t.Fatalf("Want failure for a repository error\n")
+	}
+}
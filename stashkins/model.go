@@ -0,0 +1,70 @@
+package stashkins
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// jobModel makes the aspect's model for a branch job and fills in the fields common to every job model.  Models of aspects
+// other than those in this package are returned as the aspect made them.
+func (c DefaultStashkins) jobModel(jobAspect Aspect, jobName, description, branch string, jobTemplate JobTemplate, state scmState) interface{} {
+	model := jobAspect.MakeModel(jobName, description, state.repository.CloneURL, branch, jobTemplate)
+	fields := c.jobFields(jobTemplate, state, branch)
+	switch m := model.(type) {
+	case MavenJob:
+		m.JobFields = fields
+		return m
+	case FreestyleJob:
+		m.JobFields = fields
+		return m
+	}
+	return model
+}
+
+// jobFields are the fields common to every job model for the given branch, which is empty for tag jobs.
+func (c DefaultStashkins) jobFields(jobTemplate JobTemplate, state scmState, branch string) JobFields {
+	fields := JobFields{
+		ProjectKey:        jobTemplate.ProjectKey,
+		Slug:              jobTemplate.Slug,
+		HTTPRepositoryURL: state.repository.HTTPCloneURL,
+		LatestCommit:      state.heads[branch].Commit,
+		Parameters:        c.Options.Parameters,
+	}
+	if branch != "" {
+		fields.BranchBaseName, fields.BranchSuffix = c.branchOperations.suffixer(branch)
+	}
+	if fields.Parameters == nil {
+		fields.Parameters = make(map[string]string)
+	}
+	return fields
+}
+
+// usesLatestCommit reports whether any of the template's branch or pull request job templates refers to LatestCommit.
+func usesLatestCommit(jobTemplate JobTemplate) bool {
+	for _, data := range [][]byte{jobTemplate.ContinuousJobTemplate, jobTemplate.ReleaseJobTemplate, jobTemplate.PullRequestJobTemplate} {
+		if bytes.Contains(data, []byte("LatestCommit")) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseParameters parses a comma separated list of name=value custom parameters, as in
+// team=platform,notify=builds@example.com.
+func ParseParameters(spec string) (map[string]string, error) {
+	parameters := make(map[string]string)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		name := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || name == "" {
+			return nil, fmt.Errorf("stashkins.ParseParameters want name=value but got %s", entry)
+		}
+		parameters[name] = strings.TrimSpace(parts[1])
+	}
+	return parameters, nil
+}
@@ -0,0 +1,150 @@
+package stashkins
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/xoom/stash"
+)
+
+// A Plan describes what ReconcileJobs would do for one JobTemplate.  Computing a Plan makes no write calls to Jenkins or Nexus.
+type Plan struct {
+	ProjectKey       string
+	Slug             string
+	BranchCount      int
+	ExistingJobs     int // jobs that exist in the repository's CI, pull request and tag job namespaces
+	SpecJobs         []JobDescriptorNG
+	MissingJobs      []JobDescriptorNG
+	ObsoleteJobs     []JobDescriptorNG
+	PurgeJobs        []JobDescriptorNG // archived jobs whose retention has expired
+	Archive          bool              // whether obsolete jobs are archived rather than deleted
+	ReleaseJob       string            // name of the release job that would be created, or empty if none
+	DeletionsRefused string            // why the deletion guard would refuse to delete the obsolete jobs, or empty if it would not
+	DriftedJobs      []JobDrift
+	AspectTasks      []string // human readable descriptions of the aspect work, e.g. Maven repositories to be created or deleted, only from PlanJobs
+
+	PullRequestJobs         []PullRequestJobDescriptor // a job for every open pull request
+	MissingPullRequestJobs  []PullRequestJobDescriptor
+	ObsoletePullRequestJobs []PullRequestJobDescriptor // jobs of merged or declined pull requests, always deleted
+
+	TagJobs         []TagJobDescriptor // a job for every retained tag matching the tag pattern
+	MissingTagJobs  []TagJobDescriptor
+	ObsoleteTagJobs []TagJobDescriptor // jobs of removed tags or tags beyond retention, always deleted
+}
+
+// PlanJobs computes the reconciliation plan for the given template without changing Jenkins or Nexus.
+func (c DefaultStashkins) PlanJobs(jobIndex JobIndex, jobTemplate JobTemplate, jobAspect Aspect) (Plan, error) {
+	state, err := c.repositoryState(jobTemplate)
+	if err != nil {
+		return Plan{}, err
+	}
+	gitRepository := state.repository
+	plan := c.plan(jobIndex, jobTemplate, state.branches, state.stale)
+	c.planPullRequestJobs(&plan, jobIndex, jobTemplate, state.pullRequests)
+	c.planTagJobs(&plan, jobIndex, jobTemplate, state.tags)
+	c.planAspectTasks(&plan, jobTemplate, jobAspect, gitRepository.CloneURL)
+	if err := c.Options.DeletionGuard.reserve(plan); err != nil {
+		plan.DeletionsRefused = err.Error()
+	}
+	if c.Options.RepairDrift {
+		plan.DriftedJobs = c.detectDrift(plan, jobIndex, jobTemplate, jobAspect, state)
+	}
+	return plan, nil
+}
+
+// plan computes the continuous and release jobs for the given branches.  Stale branches get no new jobs, but keep the jobs
+// they have, and with them their aspect resources, until the branches are deleted.  A repository without a continuous
+// template, such as one wanting only pull request or tag builds, gets no continuous jobs and keeps any it has.
+func (c DefaultStashkins) plan(jobIndex JobIndex, jobTemplate JobTemplate, branches, stale map[string]stash.Branch) Plan {
+	plan := Plan{
+		ProjectKey:   jobTemplate.ProjectKey,
+		Slug:         jobTemplate.Slug,
+		BranchCount:  len(branches),
+		ExistingJobs: c.existingJobCount(jobIndex, jobTemplate.ProjectKey, jobTemplate.Slug),
+		SpecJobs:     make([]JobDescriptorNG, 0),
+		MissingJobs:  make([]JobDescriptorNG, 0),
+		ObsoleteJobs: make([]JobDescriptorNG, 0),
+		PurgeJobs:    c.calculatePurgeableJobs(jobTemplate.ProjectKey, jobTemplate.Slug, jobIndex),
+		Archive:      c.Options.Retirement.Archive,
+		AspectTasks:  make([]string, 0),
+	}
+
+	if len(jobTemplate.ContinuousJobTemplate) > 0 {
+		// Calculate the specification CI job names which must by design exist for this project.
+		specCIJobs := c.calculateSpecCIJobs(jobTemplate.ProjectKey, jobTemplate.Slug, branches)
+		keptCIJobs := append(c.calculateSpecCIJobs(jobTemplate.ProjectKey, jobTemplate.Slug, stale), specCIJobs...)
+		plan.SpecJobs = specCIJobs
+		plan.MissingJobs = c.calculateMissingCIJobs(specCIJobs, jobIndex)
+		plan.ObsoleteJobs = c.calculateObsoleteCIJobs(keptCIJobs, jobTemplate.ProjectKey, jobTemplate.Slug, jobIndex)
+	}
+
+	if c.shouldCreateReleaseJob(jobTemplate.ProjectKey, jobTemplate.Slug, jobIndex) && len(jobTemplate.ReleaseJobTemplate) > 0 {
+		plan.ReleaseJob = c.canonicalReleaseJobName(jobTemplate.ProjectKey, jobTemplate.Slug)
+	}
+
+	return plan
+}
+
+// planAspectTasks adds to the plan the aspect work its job deletions and creations entail.  Planning that work may ask the
+// aspect's backend what already exists, so it is done only for plans that are shown rather than carried out.
+func (c DefaultStashkins) planAspectTasks(plan *Plan, jobTemplate JobTemplate, jobAspect Aspect, gitRepositoryURL string) {
+	// Aspect resources are released when a job is deleted or archived, so purging an archived job releases none.
+	for _, obsoleteJob := range plan.ObsoleteJobs {
+		recoveredBranchName, err := c.branchOperations.recoverBranchFromCIJobName(obsoleteJob.JobName)
+		if err != nil {
+			continue
+		}
+		plan.AspectTasks = append(plan.AspectTasks, jobAspect.PlanJobDeleteTasks(obsoleteJob.JobName, gitRepositoryURL, recoveredBranchName, jobTemplate)...)
+	}
+
+	for _, missingJob := range plan.MissingJobs {
+		plan.AspectTasks = append(plan.AspectTasks, jobAspect.PlanJobCreateTasks(missingJob.JobName, gitRepositoryURL, missingJob.Branch.DisplayID, jobTemplate)...)
+	}
+}
+
+// String renders the plan in a form suitable for review by a human.
+func (p Plan) String() string {
+	var b bytes.Buffer
+	retire := "delete"
+	if p.Archive {
+		retire = "archive"
+	}
+	fmt.Fprintf(&b, "Plan for %s/%s: %d branches, %d spec jobs, %d to create, %d to %s\n", p.ProjectKey, p.Slug, p.BranchCount, len(p.SpecJobs), len(p.MissingJobs), len(p.ObsoleteJobs), retire)
+	if p.DeletionsRefused != "" {
+		fmt.Fprintf(&b, "  ! %s\n", p.DeletionsRefused)
+	}
+	for _, job := range p.ObsoleteJobs {
+		fmt.Fprintf(&b, "  - %s job %s\n", retire, job.JobName)
+	}
+	for _, job := range p.PurgeJobs {
+		fmt.Fprintf(&b, "  - delete expired archived job %s\n", job.JobName)
+	}
+	for _, job := range p.MissingJobs {
+		fmt.Fprintf(&b, "  + create job %s (branch %s)\n", job.JobName, job.Branch.DisplayID)
+	}
+	for _, drift := range p.DriftedJobs {
+		fmt.Fprintf(&b, "  ~ update drifted job %s\n", drift.JobName)
+		for _, change := range drift.Changes {
+			fmt.Fprintf(&b, "      %s\n", change)
+		}
+	}
+	if p.ReleaseJob != "" {
+		fmt.Fprintf(&b, "  + create release job %s\n", p.ReleaseJob)
+	}
+	for _, job := range p.ObsoletePullRequestJobs {
+		fmt.Fprintf(&b, "  - delete pull request job %s\n", job.JobName)
+	}
+	for _, job := range p.ObsoleteTagJobs {
+		fmt.Fprintf(&b, "  - delete tag release job %s\n", job.JobName)
+	}
+	for _, job := range p.MissingTagJobs {
+		fmt.Fprintf(&b, "  + create tag release job %s (tag %s)\n", job.JobName, job.Tag.Name)
+	}
+	for _, job := range p.MissingPullRequestJobs {
+		fmt.Fprintf(&b, "  + create pull request job %s (pull request %d, %s into %s)\n", job.JobName, job.PullRequest.ID, job.PullRequest.SourceBranch, job.PullRequest.TargetBranch)
+	}
+	for _, task := range p.AspectTasks {
+		fmt.Fprintf(&b, "  * %s\n", task)
+	}
+	return b.String()
+}
@@ -0,0 +1,93 @@
+package stashkins
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/xoom/jenkins"
+	"github.com/xoom/stash"
+)
+
+func TestPlan(t *testing.T) {
+	skins := DefaultStashkins{branchOperations: NewBranchOperations("feature/")}
+
+	branches := map[string]stash.Branch{
+		"develop":   stash.Branch{DisplayID: "develop"},
+		"feature/1": stash.Branch{DisplayID: "feature/1"},
+		"feature/2": stash.Branch{DisplayID: "feature/2"},
+	}
+	jobSummaries := []jenkins.JobSummary{
+		jenkins.JobSummary{JobDescriptor: jenkins.JobDescriptor{Name: "proj-slug-continuous-develop"}},
+		jenkins.JobSummary{JobDescriptor: jenkins.JobDescriptor{Name: "proj-slug-continuous-feature-1"}},
+		jenkins.JobSummary{JobDescriptor: jenkins.JobDescriptor{Name: "proj-slug-continuous-feature-3"}},
+		jenkins.JobSummary{JobDescriptor: jenkins.JobDescriptor{Name: "proj-slug-continuous-feature-4"}},
+	}
+	jobTemplate := JobTemplate{ProjectKey: "proj", Slug: "slug", ContinuousJobTemplate: []byte("<project/>"), ReleaseJobTemplate: []byte("<project/>")}
+
+	// feature/4 and feature/5 are stale:  feature/4 keeps its job and feature/5 gets none.
+	stale := map[string]stash.Branch{
+		"feature/4": stash.Branch{DisplayID: "feature/4"},
+		"feature/5": stash.Branch{DisplayID: "feature/5"},
+	}
+
+	plan := skins.plan(NewJobIndex(jobSummaries), jobTemplate, branches, stale)
+
+	if plan.BranchCount != 3 {
+		t.Fatalf("Want 3 but got %d\n", plan.BranchCount)
+	}
+	if len(plan.SpecJobs) != 3 {
+		t.Fatalf("Want 3 but got %d\n", len(plan.SpecJobs))
+	}
+	if len(plan.MissingJobs) != 1 || plan.MissingJobs[0].JobName != "proj-slug-continuous-feature-2" {
+		t.Fatalf("Want proj-slug-continuous-feature-2 missing but got %+v\n", plan.MissingJobs)
+	}
+	if len(plan.ObsoleteJobs) != 1 || plan.ObsoleteJobs[0].JobName != "proj-slug-continuous-feature-3" {
+		t.Fatalf("Want proj-slug-continuous-feature-3 obsolete but got %+v\n", plan.ObsoleteJobs)
+	}
+	if plan.ReleaseJob != "proj-slug-release" {
+		t.Fatalf("Want proj-slug-release but got %s\n", plan.ReleaseJob)
+	}
+
+	s := plan.String()
+	for _, want := range []string{"- delete job proj-slug-continuous-feature-3", "+ create job proj-slug-continuous-feature-2", "+ create release job proj-slug-release"} {
+		if !strings.Contains(s, want) {
+			t.Fatalf("Want plan to contain %q but got %s\n", want, s)
+		}
+	}
+}
+
+func TestPlanMavenTasks(t *testing.T) {
+	maven := MavenAspect{
+		mavenRepositoryParams: MavenRepositoryParams{FeatureBranchRepositoryGroupID: "group"},
+		branchOperations:      NewBranchOperations("feature/"),
+	}
+
+	tasks := maven.PlanJobDeleteTasks("proj-slug-continuous-feature-3", "", "feature/3", JobTemplate{ProjectKey: "proj", Slug: "slug"})
+	if len(tasks) != 1 || tasks[0] != "delete Maven repository proj.slug.feature_3" {
+		t.Fatalf("Want delete of proj.slug.feature_3 but got %+v\n", tasks)
+	}
+
+	if tasks := maven.PlanJobDeleteTasks("proj-slug-continuous-develop", "", "develop", JobTemplate{ProjectKey: "proj", Slug: "slug"}); len(tasks) != 0 {
+		t.Fatalf("Want no tasks for develop but got %+v\n", tasks)
+	}
+}
+
+func TestPlanAspectTasksArchive(t *testing.T) {
+	maven := MavenAspect{
+		mavenRepositoryParams: MavenRepositoryParams{FeatureBranchRepositoryGroupID: "group"},
+		branchOperations:      NewBranchOperations("feature/"),
+	}
+	skins := DefaultStashkins{branchOperations: NewBranchOperations("feature/")}
+	plan := Plan{
+		Archive:      true,
+		ObsoleteJobs: []JobDescriptorNG{JobDescriptorNG{JobName: "proj-slug-continuous-feature-3"}},
+		PurgeJobs:    []JobDescriptorNG{JobDescriptorNG{JobName: "retired-20160809154719-proj-slug-continuous-feature-1"}},
+	}
+
+	skins.planAspectTasks(&plan, JobTemplate{ProjectKey: "proj", Slug: "slug"}, maven, "")
+
+	// The archived job's repository goes now, and the purged job's went when it was archived.
+	if len(plan.AspectTasks) != 1 || plan.AspectTasks[0] != "delete Maven repository proj.slug.feature_3" {
+		t.Fatalf("Want only the delete of proj.slug.feature_3 but got %+v\n", plan.AspectTasks)
+	}
+}
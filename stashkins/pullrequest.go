@@ -0,0 +1,112 @@
+package stashkins
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// planPullRequestJobs adds to the plan a job for every open pull request, and the retirement of every pull request job whose
+// pull request has been merged or declined.  Without a pull request template, whether absent or unresolvable, existing pull
+// request jobs are left alone, since there are no open pull requests against which to judge them.
+func (c DefaultStashkins) planPullRequestJobs(plan *Plan, jobIndex JobIndex, jobTemplate JobTemplate, pullRequests []PullRequest) {
+	specJobs := make([]PullRequestJobDescriptor, 0)
+	plan.PullRequestJobs = specJobs
+	plan.MissingPullRequestJobs = make([]PullRequestJobDescriptor, 0)
+	plan.ObsoletePullRequestJobs = make([]PullRequestJobDescriptor, 0)
+	if len(jobTemplate.PullRequestJobTemplate) == 0 {
+		return
+	}
+
+	for _, pullRequest := range pullRequests {
+		specJobs = append(specJobs, PullRequestJobDescriptor{
+			JobName:     c.canonicalPullRequestJobName(jobTemplate.ProjectKey, jobTemplate.Slug, pullRequest),
+			PullRequest: pullRequest,
+		})
+	}
+
+	specJobNames := make(map[string]bool, len(specJobs))
+	for _, specJob := range specJobs {
+		specJobNames[specJob.JobName] = true
+		if !jobIndex.Exists(specJob.JobName) {
+			plan.MissingPullRequestJobs = append(plan.MissingPullRequestJobs, specJob)
+		}
+	}
+
+	for _, existingJobName := range jobIndex.inNameSpace(c.pullRequestJobNameSpace(jobTemplate.ProjectKey, jobTemplate.Slug)) {
+		if !specJobNames[existingJobName] {
+			plan.ObsoletePullRequestJobs = append(plan.ObsoletePullRequestJobs, PullRequestJobDescriptor{JobName: existingJobName})
+		}
+	}
+	plan.PullRequestJobs = specJobs
+}
+
+// reconcilePullRequestJobs deletes the plan's obsolete pull request jobs and creates its missing ones.  Pull request jobs
+// have no aspect resources, and are always deleted rather than archived.
+func (c DefaultStashkins) reconcilePullRequestJobs(plan Plan, jobTemplate JobTemplate, state scmState, report *RepositoryReport) {
+	for _, obsoleteJob := range plan.ObsoletePullRequestJobs {
+		log := jobLog(jobTemplate, obsoleteJob.JobName, "")
+		err := c.jenkinsClient.DeleteJob(obsoleteJob.JobName)
+		if err != nil {
+			log.WithError(err).Error("Cannot delete pull request job, continuing")
+		} else {
+			log.Info("Deleted pull request job")
+		}
+		report.record(KindPullRequestJob, obsoleteJob.JobName, "", ActionDelete, err)
+	}
+
+	for _, missingJob := range plan.MissingPullRequestJobs {
+		branchName := missingJob.PullRequest.SourceBranch
+		log := jobLog(jobTemplate, missingJob.JobName, branchName).WithField("pull_request", missingJob.PullRequest.ID)
+		model := c.pullRequestModel(missingJob, jobTemplate, state)
+		err := c.createJob(jobTemplate.PullRequestJobTemplate, missingJob.JobName, model)
+		if err != nil {
+			log.WithError(err).Error("Cannot create pull request job")
+		} else {
+			log.Info("Created pull request job")
+		}
+		report.record(KindPullRequestJob, missingJob.JobName, branchName, ActionCreate, err)
+	}
+}
+
+func (c DefaultStashkins) pullRequestModel(job PullRequestJobDescriptor, jobTemplate JobTemplate, state scmState) PullRequestJob {
+	pullRequest := job.PullRequest
+	return PullRequestJob{
+		JobFields:     c.jobFields(jobTemplate, state, pullRequest.SourceBranch),
+		JobName:       job.JobName,
+		Description:   c.pullRequestJobDescription(jobTemplate, pullRequest),
+		BranchName:    pullRequest.SourceBranch,
+		RepositoryURL: state.repository.CloneURL,
+		PullRequestID: pullRequest.ID,
+		SourceBranch:  pullRequest.SourceBranch,
+		TargetBranch:  pullRequest.TargetBranch,
+		Author:        xmlEscape(pullRequest.Author),
+		Title:         xmlEscape(pullRequest.Title),
+		MergeRef:      pullRequest.MergeRef,
+	}
+}
+
+func (c DefaultStashkins) pullRequestJobDescription(jobTemplate JobTemplate, pullRequest PullRequest) string {
+	return fmt.Sprintf("This is a pull request build for %s-%s, pull request %d from %s into %s", jobTemplate.ProjectKey, jobTemplate.Slug, pullRequest.ID, pullRequest.SourceBranch, pullRequest.TargetBranch)
+}
+
+func (c DefaultStashkins) canonicalPullRequestJobName(projectKey, slug string, pullRequest PullRequest) string {
+	return c.pullRequestJobNameSpace(projectKey, slug) + strconv.Itoa(pullRequest.ID)
+}
+
+func (c DefaultStashkins) pullRequestJobNameSpace(projectKey, slug string) string {
+	return c.jobNamePrefix(projectKey, slug) + pullRequestNameSpaceDelimiter
+}
+
+// xmlEscape escapes free text, such as a pull request title, for inclusion in a job's config.xml.
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func (c DefaultStashkins) jobInPullRequestNameSpace(jobName, projectKey, slug string) bool {
+	return strings.HasPrefix(jobName, c.pullRequestJobNameSpace(projectKey, slug))
+}
@@ -0,0 +1,131 @@
+package stashkins
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/xoom/jenkins"
+	"github.com/xoom/stash"
+)
+
+// recordingJenkins records the jobs created and deleted.
+type recordingJenkins struct {
+	jenkins.Jenkins
+	created []string
+	deleted []string
+}
+
+func (r *recordingJenkins) CreateJob(jobName, jobConfigXML string) error {
+	r.created = append(r.created, jobName)
+	return nil
+}
+
+func (r *recordingJenkins) DeleteJob(jobName string) error {
+	r.deleted = append(r.deleted, jobName)
+	return nil
+}
+
+func TestPlanPullRequestJobs(t *testing.T) {
+	skins := DefaultStashkins{}
+
+	jobSummaries := []jenkins.JobSummary{
+		jenkins.JobSummary{JobDescriptor: jenkins.JobDescriptor{Name: "proj-slug-pullrequest-1"}},
+		jenkins.JobSummary{JobDescriptor: jenkins.JobDescriptor{Name: "proj-slug-pullrequest-2"}},
+		jenkins.JobSummary{JobDescriptor: jenkins.JobDescriptor{Name: "proj-slug-continuous-develop"}},
+	}
+	pullRequests := []PullRequest{
+		PullRequest{ID: 1, SourceBranch: "feature/1", TargetBranch: "develop"},
+		PullRequest{ID: 3, SourceBranch: "feature/3", TargetBranch: "develop"},
+	}
+	jobTemplate := JobTemplate{ProjectKey: "proj", Slug: "slug", PullRequestJobTemplate: []byte("<project/>")}
+
+	var plan Plan
+	skins.planPullRequestJobs(&plan, NewJobIndex(jobSummaries), jobTemplate, pullRequests)
+
+	if len(plan.PullRequestJobs) != 2 {
+		t.Fatalf("Want 2 but got %d\n", len(plan.PullRequestJobs))
+	}
+	if len(plan.MissingPullRequestJobs) != 1 || plan.MissingPullRequestJobs[0].JobName != "proj-slug-pullrequest-3" {
+		t.Fatalf("Want proj-slug-pullrequest-3 missing but got %+v\n", plan.MissingPullRequestJobs)
+	}
+	if len(plan.ObsoletePullRequestJobs) != 1 || plan.ObsoletePullRequestJobs[0].JobName != "proj-slug-pullrequest-2" {
+		t.Fatalf("Want proj-slug-pullrequest-2 obsolete but got %+v\n", plan.ObsoletePullRequestJobs)
+	}
+
+	s := plan.String()
+	for _, want := range []string{"- delete pull request job proj-slug-pullrequest-2", "+ create pull request job proj-slug-pullrequest-3 (pull request 3, feature/3 into develop)"} {
+		if !strings.Contains(s, want) {
+			t.Fatalf("Want plan to contain %q but got %s\n", want, s)
+		}
+	}
+
+	// Without a pull request template existing pull request jobs are left alone.
+	jobTemplate.PullRequestJobTemplate = nil
+	plan = Plan{}
+	skins.planPullRequestJobs(&plan, NewJobIndex(jobSummaries), jobTemplate, pullRequests)
+	if len(plan.MissingPullRequestJobs) != 0 || len(plan.ObsoletePullRequestJobs) != 0 {
+		t.Fatalf("Want 0 missing and 0 obsolete but got %+v and %+v\n", plan.MissingPullRequestJobs, plan.ObsoletePullRequestJobs)
+	}
+}
+
+func TestPullRequestModel(t *testing.T) {
+	skins := DefaultStashkins{}
+	job := PullRequestJobDescriptor{
+		JobName:     "proj-slug-pullrequest-42",
+		PullRequest: PullRequest{ID: 42, Title: "Fix <b> & </b>", Author: "jdoe", SourceBranch: "feature/42", TargetBranch: "develop", MergeRef: "refs/pull-requests/42/merge"},
+	}
+	state := scmState{
+		repository: SCMRepository{CloneURL: "ssh://git@example.com/proj/slug.git", HTTPCloneURL: "https://example.com/scm/proj/slug.git"},
+		heads:      map[string]BranchHead{"feature/42": {Commit: "abc123"}},
+	}
+	model := skins.pullRequestModel(job, JobTemplate{ProjectKey: "proj", Slug: "slug"}, state)
+
+	data, err := skins.renderJob([]byte("<project><description>{{.Title}}</description><ref>{{.MergeRef}}</ref><id>{{.PullRequestID}}</id></project>"), job.JobName, model)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	want := "<project><description>Fix &lt;b&gt; &amp; &lt;/b&gt;</description><ref>refs/pull-requests/42/merge</ref><id>42</id></project>"
+	if !bytes.Equal(data, []byte(want)) {
+		t.Fatalf("Want %s but got %s\n", want, string(data))
+	}
+	if model.BranchName != "feature/42" || model.TargetBranch != "develop" {
+		t.Fatalf("Want feature/42 into develop but got %s into %s\n", model.BranchName, model.TargetBranch)
+	}
+	if model.ProjectKey != "proj" || model.BranchSuffix != "-42" || model.LatestCommit != "abc123" || model.HTTPRepositoryURL != "https://example.com/scm/proj/slug.git" {
+		t.Fatalf("Want the common fields for proj feature/42 at abc123 but got %+v\n", model.JobFields)
+	}
+}
+
+func TestReconcilePullRequestOnlyTemplate(t *testing.T) {
+	jenkinsClient := &recordingJenkins{}
+	skins := DefaultStashkins{
+		scm: fixedSCM{
+			repository:   SCMRepository{ProjectKey: "proj", Slug: "slug"},
+			branches:     map[string]stash.Branch{"develop": {DisplayID: "develop"}, "feature/1": {DisplayID: "feature/1"}},
+			pullRequests: []PullRequest{PullRequest{ID: 1, SourceBranch: "feature/1", TargetBranch: "develop"}},
+		},
+		jenkinsClient:    jenkinsClient,
+		branchOperations: NewBranchOperations("feature/"),
+	}
+	jobSummaries := []jenkins.JobSummary{
+		jenkins.JobSummary{JobDescriptor: jenkins.JobDescriptor{Name: "proj-slug-continuous-feature-2"}},
+	}
+	jobTemplate := JobTemplate{ProjectKey: "proj", Slug: "slug", PullRequestJobTemplate: []byte("<project/>")}
+
+	report, err := skins.ReconcileJobs(NewJobIndex(jobSummaries), jobTemplate, FreestyleAspect{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if !RunSucceeded([]RepositoryReport{report}) {
+		t.Fatalf("Want a successful reconciliation but got %+v\n", report)
+	}
+
+	// Without a continuous template no continuous jobs are created, and those that exist are left alone.
+	if len(jenkinsClient.created) != 1 || jenkinsClient.created[0] != "proj-slug-pullrequest-1" {
+		t.Fatalf("Want only proj-slug-pullrequest-1 created but got %+v\n", jenkinsClient.created)
+	}
+	if len(jenkinsClient.deleted) != 0 {
+		t.Fatalf("Want no jobs deleted but got %+v\n", jenkinsClient.deleted)
+	}
+}
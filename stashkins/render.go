@@ -0,0 +1,44 @@
+package stashkins
+
+import "fmt"
+
+// RenderJob renders the continuous job for the given branch of the template's repository, or the release job if release is
+// set, as reconciliation would create it, and returns the job's name and configuration.  A branch that would get no job is
+// rendered all the same.  It makes only read calls to the SCM provider.  Without an SCM provider the repository's clone URLs
+// and head commits are sample values, as lint uses.
+func (c DefaultStashkins) RenderJob(jobTemplate JobTemplate, jobAspect Aspect, branch string, release bool) (string, []byte, error) {
+	projectKey, slug := jobTemplate.ProjectKey, jobTemplate.Slug
+	if jobAspect == nil {
+		return "", nil, fmt.Errorf("stashkins.RenderJob no aspect for the job type of %s/%s", projectKey, slug)
+	}
+
+	state := sampleState(projectKey, slug, branch, c.branchOperations.releaseBranch())
+	if c.scm != nil {
+		var err error
+		if state.repository, err = c.scm.Repository(projectKey, slug); err != nil {
+			return "", nil, err
+		}
+		state.heads = nil
+		if usesLatestCommit(jobTemplate) {
+			if state.heads, err = c.scm.BranchHeads(projectKey, slug); err != nil {
+				return "", nil, err
+			}
+		}
+	}
+
+	if release {
+		jobName := c.canonicalReleaseJobName(projectKey, slug)
+		model := c.jobModel(jobAspect, jobName, c.releaseJobDescription(jobTemplate), c.branchOperations.releaseBranch(), jobTemplate, state)
+		config, err := c.renderJob(jobTemplate.ReleaseJobTemplate, jobName, model)
+		return jobName, config, err
+	}
+
+	if !c.branchOperations.isBranchManaged(branch) {
+		jobLog(jobTemplate, "", branch).Warn("Rendering a job for a branch that would get none")
+	}
+	stashBranch := branchFromName(branch)
+	jobName := c.canonicalCIJobName(projectKey, slug, stashBranch)
+	model := c.jobModel(jobAspect, jobName, c.continuousJobDescription(jobTemplate, stashBranch), branch, jobTemplate, state)
+	config, err := c.renderJob(jobTemplate.ContinuousJobTemplate, jobName, model)
+	return jobName, config, err
+}
@@ -0,0 +1,88 @@
+package stashkins
+
+import (
+	"testing"
+
+	"github.com/xoom/stash"
+)
+
+type fixedSCM struct {
+	SCMProvider
+	repository   SCMRepository
+	branches     map[string]stash.Branch
+	heads        map[string]BranchHead
+	pullRequests []PullRequest
+	tags         []Tag
+}
+
+func (f fixedSCM) Repository(projectKey, slug string) (SCMRepository, error) {
+	return f.repository, nil
+}
+
+func (f fixedSCM) Branches(projectKey, slug string) (map[string]stash.Branch, error) {
+	return f.branches, nil
+}
+
+func (f fixedSCM) BranchHeads(projectKey, slug string) (map[string]BranchHead, error) {
+	return f.heads, nil
+}
+
+func (f fixedSCM) PullRequests(projectKey, slug string) ([]PullRequest, error) {
+	return f.pullRequests, nil
+}
+
+func (f fixedSCM) Tags(projectKey, slug string) ([]Tag, error) {
+	return f.tags, nil
+}
+
+func TestRenderJob(t *testing.T) {
+	skins := DefaultStashkins{
+		scm: fixedSCM{
+			repository: SCMRepository{ProjectKey: "proj", Slug: "slug", CloneURL: "ssh://git@example.com/proj/slug.git"},
+			heads:      map[string]BranchHead{"feature/PROJ-999": {Commit: "abc123"}, "develop": {Commit: "def456"}},
+		},
+		branchOperations: NewBranchOperations("feature/"),
+	}
+	jobTemplate := JobTemplate{
+		ProjectKey:            "proj",
+		Slug:                  "slug",
+		ContinuousJobTemplate: []byte("<project>{{.JobName}} {{.RepositoryURL}} {{.LatestCommit}}</project>"),
+		ReleaseJobTemplate:    []byte("<project>{{.JobName}} {{.BranchName}} {{.LatestCommit}}</project>"),
+	}
+
+	var tests = []struct {
+		branch   string
+		release  bool
+		wantName string
+		want     string
+	}{
+		{"feature/PROJ-999", false, "proj-slug-continuous-feature-PROJ-999", "<project>proj-slug-continuous-feature-PROJ-999 ssh://git@example.com/proj/slug.git abc123</project>"},
+		{"", true, "proj-slug-release", "<project>proj-slug-release develop def456</project>"},
+	}
+	for _, test := range tests {
+		jobName, config, err := skins.RenderJob(jobTemplate, FreestyleAspect{}, test.branch, test.release)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v\n", err)
+		}
+		if jobName != test.wantName {
+			t.Fatalf("Want %s but got %s\n", test.wantName, jobName)
+		}
+		if string(config) != test.want {
+			t.Fatalf("Want %s but got %s\n", test.want, string(config))
+		}
+	}
+
+	if _, _, err := skins.RenderJob(jobTemplate, nil, "feature/PROJ-999", false); err == nil {
+		t.Fatalf("Want an error without an aspect\n")
+	}
+
+	// Without an SCM provider the repository is described by sample values.
+	skins.scm = nil
+	_, config, err := skins.RenderJob(jobTemplate, FreestyleAspect{}, "feature/PROJ-999", false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if want := "<project>proj-slug-continuous-feature-PROJ-999 ssh://git@scm.example.com/proj/slug.git " + lintCommit + "</project>"; string(config) != want {
+		t.Fatalf("Want %s but got %s\n", want, string(config))
+	}
+}
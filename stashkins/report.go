@@ -0,0 +1,132 @@
+package stashkins
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Report entry statuses
+const (
+	StatusDone    = "done"
+	StatusSkipped = "skipped"
+	StatusFailed  = "failed"
+)
+
+// Report entry actions
+const (
+	ActionCreate  = "create"
+	ActionDelete  = "delete"
+	ActionUpdate  = "update"
+	ActionArchive = "archive"
+)
+
+// Report entry kinds for the jobs ReconcileJobs manages directly.  Aspects contribute their own kinds through Resource.
+const (
+	KindJob            = "job"
+	KindReleaseJob     = "release-job"
+	KindArchivedJob    = "archived-job"
+	KindPullRequestJob = "pullrequest-job"
+	KindTagJob         = "tag-job"
+)
+
+type (
+	// A Resource is something other than a Jenkins job that an Aspect creates or deletes alongside a job, such as a Maven repository.
+	Resource struct {
+		Kind string
+		Name string
+	}
+
+	// A ReportEntry records the outcome of one action against Jenkins or an aspect backend.
+	ReportEntry struct {
+		Kind    string   `json:"kind" yaml:"kind"`
+		Name    string   `json:"name" yaml:"name"`
+		Branch  string   `json:"branch,omitempty" yaml:"branch,omitempty"`
+		Action  string   `json:"action" yaml:"action"`
+		Status  string   `json:"status" yaml:"status"`
+		Error   string   `json:"error,omitempty" yaml:"error,omitempty"`
+		Changes []string `json:"changes,omitempty" yaml:"changes,omitempty"`
+	}
+
+	// A RepositoryReport records the outcome of reconciling one JobTemplate.
+	RepositoryReport struct {
+		ProjectKey string        `json:"projectKey" yaml:"projectKey"`
+		Slug       string        `json:"slug" yaml:"slug"`
+		Error      string        `json:"error,omitempty" yaml:"error,omitempty"`
+		Entries    []ReportEntry `json:"entries" yaml:"entries"`
+	}
+
+	// A Report records the outcome of one stashkins run.
+	Report struct {
+		Started      time.Time          `json:"started" yaml:"started"`
+		Finished     time.Time          `json:"finished" yaml:"finished"`
+		Repositories []RepositoryReport `json:"repositories" yaml:"repositories"`
+	}
+)
+
+func NewRepositoryReport(jobTemplate JobTemplate) RepositoryReport {
+	return RepositoryReport{ProjectKey: jobTemplate.ProjectKey, Slug: jobTemplate.Slug, Entries: make([]ReportEntry, 0)}
+}
+
+// record appends an entry whose status is derived from err.
+func (r *RepositoryReport) record(kind, name, branch, action string, err error) {
+	entry := ReportEntry{Kind: kind, Name: name, Branch: branch, Action: action, Status: StatusDone}
+	if err != nil {
+		entry.Status = StatusFailed
+		entry.Error = err.Error()
+	}
+	r.Entries = append(r.Entries, entry)
+}
+
+// recordChanges appends an update entry that lists what changed.
+func (r *RepositoryReport) recordChanges(kind, name, branch string, changes []string, err error) {
+	r.record(kind, name, branch, ActionUpdate, err)
+	r.Entries[len(r.Entries)-1].Changes = changes
+}
+
+// skip appends an entry for an action that was not attempted.
+func (r *RepositoryReport) skip(kind, name, branch, action, reason string) {
+	r.Entries = append(r.Entries, ReportEntry{Kind: kind, Name: name, Branch: branch, Action: action, Status: StatusSkipped, Error: reason})
+}
+
+// recordResources appends one entry per aspect resource.
+func (r *RepositoryReport) recordResources(resources []Resource, branch, action string, err error) {
+	for _, resource := range resources {
+		r.record(resource.Kind, resource.Name, branch, action, err)
+	}
+}
+
+// Count returns the number of entries matching kind, action and status.  An empty argument matches any value.
+func (r RepositoryReport) Count(kind, action, status string) int {
+	n := 0
+	for _, e := range r.Entries {
+		if (kind == "" || e.Kind == kind) && (action == "" || e.Action == action) && (status == "" || e.Status == status) {
+			n++
+		}
+	}
+	return n
+}
+
+// Write serializes the report to w in the given format, which is one of json or yaml.
+func (r Report) Write(w io.Writer, format string) error {
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", data)
+		return err
+	case "yaml":
+		data, err := yaml.Marshal(r)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}
+	return fmt.Errorf("stashkins.Report unsupported report format %s", format)
+}
@@ -0,0 +1,61 @@
+package stashkins
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRepositoryReportRecord(t *testing.T) {
+	report := NewRepositoryReport(JobTemplate{ProjectKey: "proj", Slug: "slug"})
+
+	report.record(KindJob, "proj-slug-continuous-feature-1", "feature/1", ActionCreate, nil)
+	report.record(KindJob, "proj-slug-continuous-feature-2", "feature/2", ActionCreate, errors.New("boom"))
+	report.skip(mavenRepositoryKind, "proj.slug.feature_2", "feature/2", ActionCreate, "job creation failed")
+	report.recordResources([]Resource{Resource{Kind: mavenRepositoryKind, Name: "proj.slug.feature_1"}}, "feature/1", ActionCreate, nil)
+
+	if n := report.Count(KindJob, ActionCreate, StatusDone); n != 1 {
+		t.Fatalf("Want 1 but got %d\n", n)
+	}
+	if n := report.Count(KindJob, "", StatusFailed); n != 1 {
+		t.Fatalf("Want 1 but got %d\n", n)
+	}
+	if n := report.Count(mavenRepositoryKind, "", ""); n != 2 {
+		t.Fatalf("Want 2 but got %d\n", n)
+	}
+	if report.Entries[1].Error != "boom" {
+		t.Fatalf("Want boom but got %s\n", report.Entries[1].Error)
+	}
+}
+
+func TestReportWrite(t *testing.T) {
+	repositoryReport := NewRepositoryReport(JobTemplate{ProjectKey: "proj", Slug: "slug"})
+	repositoryReport.record(KindJob, "proj-slug-continuous-feature-1", "feature/1", ActionDelete, nil)
+	report := Report{Repositories: []RepositoryReport{repositoryReport}}
+
+	var b bytes.Buffer
+	if err := report.Write(&b, "json"); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	var decoded Report
+	if err := json.Unmarshal(b.Bytes(), &decoded); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if len(decoded.Repositories) != 1 || decoded.Repositories[0].Entries[0].Status != StatusDone {
+		t.Fatalf("Unexpected decoded report: %+v\n", decoded)
+	}
+
+	b.Reset()
+	if err := report.Write(&b, "yaml"); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if !strings.Contains(b.String(), "name: proj-slug-continuous-feature-1") {
+		t.Fatalf("Want job name in yaml report but got %s\n", b.String())
+	}
+
+	if err := report.Write(&b, "xml"); err == nil {
+		t.Fatal("Expecting an error for unsupported format")
+	}
+}
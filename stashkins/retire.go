@@ -0,0 +1,74 @@
+package stashkins
+
+import (
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	retiredJobPrefix     = "retired-"
+	retirementTimeLayout = "20060102150405"
+)
+
+// RetirementPolicy determines what becomes of obsolete jobs.
+type RetirementPolicy struct {
+	// Archive causes obsolete jobs to be disabled and renamed out of the CI job namespace instead of deleted, so their
+	// build history survives.  An archived job is named retired-<UTC timestamp>-<original job name>.
+	Archive bool
+
+	// RetentionDays is how long archived jobs are kept before they are deleted.  Zero keeps them forever.
+	RetentionDays int
+}
+
+// now is replaced in tests.
+var now = time.Now
+
+func archivedJobName(jobName string, retired time.Time) string {
+	return retiredJobPrefix + retired.UTC().Format(retirementTimeLayout) + "-" + jobName
+}
+
+// parseArchivedJobName returns the original job name and retirement time encoded in an archived job name.  ok is false
+// if the name is not that of an archived job.
+func parseArchivedJobName(name string) (jobName string, retired time.Time, ok bool) {
+	if !strings.HasPrefix(name, retiredJobPrefix) {
+		return "", time.Time{}, false
+	}
+	rest := name[len(retiredJobPrefix):]
+	if len(rest) < len(retirementTimeLayout)+2 || rest[len(retirementTimeLayout)] != '-' {
+		return "", time.Time{}, false
+	}
+	retired, err := time.Parse(retirementTimeLayout, rest[:len(retirementTimeLayout)])
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return rest[len(retirementTimeLayout)+1:], retired, true
+}
+
+// archiveJob disables the job and renames it out of the CI job namespace.  The archived name is returned.
+func (c DefaultStashkins) archiveJob(jobName string) (string, error) {
+	if _, err := c.jenkinsRequest("POST", jobPath(jobName)+"/disable", nil); err != nil {
+		return "", err
+	}
+	archivedName := archivedJobName(jobName, now())
+	if _, err := c.jenkinsRequest("POST", jobPath(jobName)+"/doRename?newName="+url.QueryEscape(archivedName), nil); err != nil {
+		return "", err
+	}
+	return archivedName, nil
+}
+
+// calculatePurgeableJobs returns the archived jobs from this repository's CI job namespace whose retention has expired.
+func (c DefaultStashkins) calculatePurgeableJobs(projectKey, slug string, jobIndex JobIndex) []JobDescriptorNG {
+	purgeable := make([]JobDescriptorNG, 0)
+	if c.Options.Retirement.RetentionDays <= 0 {
+		return purgeable
+	}
+
+	cutoff := now().Add(-time.Duration(c.Options.Retirement.RetentionDays) * 24 * time.Hour)
+	for _, archivedJobName := range jobIndex.archivedFromNameSpace(c.cIJobNameSpace(projectKey, slug)) {
+		if _, retired, _ := parseArchivedJobName(archivedJobName); retired.Before(cutoff) {
+			purgeable = append(purgeable, JobDescriptorNG{JobName: archivedJobName})
+		}
+	}
+	return purgeable
+}
@@ -0,0 +1,103 @@
+package stashkins
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/xoom/jenkins"
+)
+
+func TestArchivedJobName(t *testing.T) {
+	retired := time.Date(2016, 8, 9, 15, 47, 19, 0, time.UTC)
+
+	name := archivedJobName("proj-slug-continuous-feature-1", retired)
+	if name != "retired-20160809154719-proj-slug-continuous-feature-1" {
+		t.Fatalf("Want retired-20160809154719-proj-slug-continuous-feature-1 but got %s\n", name)
+	}
+
+	jobName, when, ok := parseArchivedJobName(name)
+	if !ok {
+		t.Fatalf("Want %s to parse as an archived job name\n", name)
+	}
+	if jobName != "proj-slug-continuous-feature-1" {
+		t.Fatalf("Want proj-slug-continuous-feature-1 but got %s\n", jobName)
+	}
+	if !when.Equal(retired) {
+		t.Fatalf("Want %v but got %v\n", retired, when)
+	}
+
+	for _, v := range []string{"proj-slug-continuous-feature-1", "retired-2016-proj", "retired-2016080915471x-proj", "retired-20160809154719"} {
+		if _, _, ok := parseArchivedJobName(v); ok {
+			t.Fatalf("Want %s not to parse as an archived job name\n", v)
+		}
+	}
+}
+
+func TestCalculatePurgeableJobs(t *testing.T) {
+	defer func() { now = time.Now }()
+	now = func() time.Time { return time.Date(2016, 8, 20, 0, 0, 0, 0, time.UTC) }
+
+	jobSummaries := []jenkins.JobSummary{
+		jenkins.JobSummary{JobDescriptor: jenkins.JobDescriptor{Name: "retired-20160801000000-proj-slug-continuous-feature-1"}}, // <<< purge this
+		jenkins.JobSummary{JobDescriptor: jenkins.JobDescriptor{Name: "retired-20160815000000-proj-slug-continuous-feature-2"}},
+		jenkins.JobSummary{JobDescriptor: jenkins.JobDescriptor{Name: "retired-20160801000000-proj-other-continuous-feature-1"}},
+		jenkins.JobSummary{JobDescriptor: jenkins.JobDescriptor{Name: "proj-slug-continuous-feature-3"}},
+	}
+
+	skins := DefaultStashkins{Options: ReconcileOptions{Retirement: RetirementPolicy{Archive: true, RetentionDays: 10}}}
+	purgeable := skins.calculatePurgeableJobs("proj", "slug", NewJobIndex(jobSummaries))
+	if len(purgeable) != 1 {
+		t.Fatalf("Want 1 but got %d\n", len(purgeable))
+	}
+	if purgeable[0].JobName != "retired-20160801000000-proj-slug-continuous-feature-1" {
+		t.Fatalf("Unexpected purgeable job %s\n", purgeable[0].JobName)
+	}
+
+	skins.Options.Retirement.RetentionDays = 0
+	if purgeable := skins.calculatePurgeableJobs("proj", "slug", NewJobIndex(jobSummaries)); len(purgeable) != 0 {
+		t.Fatalf("Want 0 but got %d\n", len(purgeable))
+	}
+}
+
+func TestArchiveJob(t *testing.T) {
+	defer func() { now = time.Now }()
+	now = func() time.Time { return time.Date(2016, 8, 9, 15, 47, 19, 0, time.UTC) }
+
+	var disabled, renamed bool
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Without CSRF protection Jenkins has no crumb issuer.
+		if r.URL.Path == "/crumbIssuer/api/json" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != "POST" {
+			t.Fatalf("Want POST but got %s\n", r.Method)
+		}
+		switch r.URL.Path {
+		case "/job/proj-slug-continuous-feature-1/disable":
+			disabled = true
+		case "/job/proj-slug-continuous-feature-1/doRename":
+			if r.URL.Query().Get("newName") != "retired-20160809154719-proj-slug-continuous-feature-1" {
+				t.Fatalf("Unexpected new name %s\n", r.URL.Query().Get("newName"))
+			}
+			renamed = true
+		default:
+			t.Fatalf("Unexpected URL path %s\n", r.URL.Path)
+		}
+	}))
+	defer testServer.Close()
+
+	skins := DefaultStashkins{jenkinsParams: WebClientParams{URL: testServer.URL}}
+	archivedName, err := skins.archiveJob("proj-slug-continuous-feature-1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if archivedName != "retired-20160809154719-proj-slug-continuous-feature-1" {
+		t.Fatalf("Unexpected archived name %s\n", archivedName)
+	}
+	if !disabled || !renamed {
+		t.Fatalf("Want job disabled and renamed but got disabled=%v renamed=%v\n", disabled, renamed)
+	}
+}
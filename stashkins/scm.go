@@ -0,0 +1,284 @@
+package stashkins
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/xoom/stash"
+)
+
+// SCM provider kinds.
+const (
+	SCMStash           = "stash"
+	SCMBitbucketServer = "bitbucket-server"
+	SCMGitHub          = "github"
+	SCMGitLab          = "gitlab"
+	SCMGitea           = "gitea"
+)
+
+// Clone protocols.
+const (
+	CloneSSH   = "ssh"
+	CloneHTTPS = "https"
+)
+
+type (
+	// An SCMProvider looks up a repository and its branches in the source code management system hosting it.  Templates
+	// name repositories by project key and slug, which for GitHub and Gitea are the owner and repository name, and for
+	// GitLab the group path and project path.
+	SCMProvider interface {
+		Repository(projectKey, slug string) (SCMRepository, error)
+
+		// Branches returns the repository's branches keyed by display ID, as in feature/PROJ-999.  Every provider describes
+		// branches as Stash does, so job reconciliation is the same whatever hosts the repository.
+		Branches(projectKey, slug string) (map[string]stash.Branch, error)
+
+		// BranchHeads returns each branch's latest commit, keyed by display ID.
+		BranchHeads(projectKey, slug string) (map[string]BranchHead, error)
+
+		// PullRequests returns the repository's open pull requests.
+		PullRequests(projectKey, slug string) ([]PullRequest, error)
+
+		// Tags returns the repository's tags.
+		Tags(projectKey, slug string) ([]Tag, error)
+	}
+
+	// An SCMRepository is a repository as jobs see it.
+	SCMRepository struct {
+		ProjectKey string
+		Slug       string
+		CloneURL   string // over the configured clone protocol
+
+		// HTTPCloneURL is the repository's HTTPS clone URL whatever the clone protocol, or empty if the provider cannot
+		// say.
+		HTTPCloneURL string
+	}
+
+	// A BranchHead is the latest commit on a branch.  Time is zero if the provider does not report it.
+	BranchHead struct {
+		Commit string
+		Time   time.Time
+	}
+
+	// A PullRequest is an open pull request, or for GitLab, merge request.
+	PullRequest struct {
+		ID           int
+		Title        string
+		Author       string
+		SourceBranch string // feature/PROJ-999
+		TargetBranch string // develop
+
+		// MergeRef is the ref holding the result of merging the source branch into the target branch, as in
+		// refs/pull-requests/1/merge.  Gitea keeps no such ref, so for Gitea it is the head of the source branch.
+		MergeRef string
+	}
+
+	// A Tag is a tag and the commit it points to.
+	Tag struct {
+		Name   string // v1.2.3
+		Commit string
+	}
+)
+
+// NewSCMProvider returns a provider of the given kind whose API is at params.URL.  Jobs clone repositories over
+// cloneProtocol, ssh or https.  For GitHub, GitLab and Gitea, params.Password is an access token.
+func NewSCMProvider(kind string, params WebClientParams, cloneProtocol string) (SCMProvider, error) {
+	if cloneProtocol != CloneSSH && cloneProtocol != CloneHTTPS {
+		return nil, fmt.Errorf("stashkins.NewSCMProvider unsupported clone protocol %s", cloneProtocol)
+	}
+
+	baseURL, err := url.Parse(params.URL)
+	if err != nil {
+		return nil, fmt.Errorf("stashkins.NewSCMProvider cannot parse URL %s: %v", params.URL, err)
+	}
+
+	switch kind {
+	case SCMStash:
+		if cloneProtocol != CloneSSH {
+			return nil, fmt.Errorf("stashkins.NewSCMProvider %s supports only the ssh clone protocol", kind)
+		}
+		return stashProvider{
+			client: stash.NewClient(params.UserName, params.Password, baseURL),
+			rest:   &bitbucketServerProvider{api: newRESTClient(params, basicAuth(params)), cloneProtocol: cloneProtocol},
+		}, nil
+	case SCMBitbucketServer:
+		return bitbucketServerProvider{api: newRESTClient(params, basicAuth(params)), cloneProtocol: cloneProtocol}, nil
+	case SCMGitHub:
+		return gitHubProvider{api: newRESTClient(params, headerAuth("Authorization", "token "+params.Password)), cloneProtocol: cloneProtocol}, nil
+	case SCMGitLab:
+		return gitLabProvider{api: newRESTClient(params, headerAuth("PRIVATE-TOKEN", params.Password)), cloneProtocol: cloneProtocol}, nil
+	case SCMGitea:
+		return giteaProvider{api: newRESTClient(params, headerAuth("Authorization", "token "+params.Password)), cloneProtocol: cloneProtocol}, nil
+	}
+	return nil, fmt.Errorf("stashkins.NewSCMProvider unsupported SCM provider %s", kind)
+}
+
+type stashProvider struct {
+	client stash.Stash
+	rest   *bitbucketServerProvider // for what the Stash client does not do, or nil
+}
+
+// NewStashProvider returns a provider backed by a Stash client.  It cannot list pull requests; NewSCMProvider returns a Stash
+// provider that can.
+func NewStashProvider(client stash.Stash) SCMProvider {
+	return stashProvider{client: client}
+}
+
+// Repository reads the HTTPS clone URL over the REST API Stash shares with Bitbucket Server, if it can.
+func (s stashProvider) Repository(projectKey, slug string) (SCMRepository, error) {
+	repository, err := s.client.GetRepository(projectKey, slug)
+	if err != nil {
+		return SCMRepository{}, err
+	}
+	scmRepository := SCMRepository{ProjectKey: projectKey, Slug: slug, CloneURL: repository.SshUrl()}
+	if s.rest != nil {
+		if rest, err := s.rest.Repository(projectKey, slug); err == nil {
+			scmRepository.HTTPCloneURL = rest.HTTPCloneURL
+		}
+	}
+	return scmRepository, nil
+}
+
+func (s stashProvider) Branches(projectKey, slug string) (map[string]stash.Branch, error) {
+	return s.client.GetBranches(projectKey, slug)
+}
+
+// BranchHeads lists branch details over the REST API Stash shares with Bitbucket Server.
+func (s stashProvider) BranchHeads(projectKey, slug string) (map[string]BranchHead, error) {
+	if s.rest == nil {
+		return nil, fmt.Errorf("stashkins.stashProvider cannot list branch heads without a Stash URL")
+	}
+	return s.rest.BranchHeads(projectKey, slug)
+}
+
+// PullRequests lists pull requests over the REST API Stash shares with Bitbucket Server.
+func (s stashProvider) PullRequests(projectKey, slug string) ([]PullRequest, error) {
+	if s.rest == nil {
+		return nil, fmt.Errorf("stashkins.stashProvider cannot list pull requests without a Stash URL")
+	}
+	return s.rest.PullRequests(projectKey, slug)
+}
+
+// Tags lists tags over the REST API Stash shares with Bitbucket Server.
+func (s stashProvider) Tags(projectKey, slug string) ([]Tag, error) {
+	if s.rest == nil {
+		return nil, fmt.Errorf("stashkins.stashProvider cannot list tags without a Stash URL")
+	}
+	return s.rest.Tags(projectKey, slug)
+}
+
+// branchRefPrefix prefixes branch names to make ref IDs.
+const branchRefPrefix = "refs/heads/"
+
+func branchFromName(name string) stash.Branch {
+	return stash.Branch{ID: branchRefPrefix + name, DisplayID: name}
+}
+
+// restClient makes authenticated JSON requests against an SCM REST API.
+type restClient struct {
+	baseURL string
+	auth    func(*http.Request)
+	client  *http.Client
+}
+
+func newRESTClient(params WebClientParams, auth func(*http.Request)) restClient {
+	return restClient{baseURL: strings.TrimSuffix(params.URL, "/"), auth: auth, client: params.httpClient()}
+}
+
+func basicAuth(params WebClientParams) func(*http.Request) {
+	return func(req *http.Request) {
+		if params.UserName != "" || params.Password != "" {
+			req.SetBasicAuth(params.UserName, params.Password)
+		}
+	}
+}
+
+func headerAuth(name, value string) func(*http.Request) {
+	return func(req *http.Request) {
+		req.Header.Set(name, value)
+	}
+}
+
+// get fetches path, relative to the API base URL unless it is absolute, and decodes the JSON response into v.  The response
+// headers are returned for paging.  Any non-2xx response is an error.
+func (r restClient) get(path string, v interface{}) (http.Header, error) {
+	return r.do("GET", path, nil, v)
+}
+
+// post sends body as JSON to path, as get resolves it, and decodes the JSON response into v.
+func (r restClient) post(path string, body, v interface{}) (http.Header, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	return r.do("POST", path, bytes.NewReader(data), v)
+}
+
+// do makes a request of path, as get resolves it.  An absolute URL, such as a paging header names, must be on the API's own
+// scheme and host, since the request carries the API's credentials.
+func (r restClient) do(method, path string, body io.Reader, v interface{}) (http.Header, error) {
+	absolute := strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+	if !absolute {
+		path = r.baseURL + path
+	}
+	req, err := http.NewRequest(method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	if absolute {
+		base, err := url.Parse(r.baseURL)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.EqualFold(req.URL.Scheme, base.Scheme) || !strings.EqualFold(req.URL.Host, base.Host) {
+			return nil, fmt.Errorf("stashkins.restClient refusing to send credentials for %s to %s://%s", base.Host, req.URL.Scheme, req.URL.Host)
+		}
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	r.auth(req)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("stashkins.restClient %s %s returned HTTP status %d", method, req.URL.Path, resp.StatusCode)
+	}
+	return resp.Header, json.Unmarshal(data, v)
+}
+
+// nextLink returns the URL of the next page named by an RFC 5988 Link header, as GitHub and Gitea send, or "" on the last page.
+func nextLink(header http.Header) string {
+	for _, link := range strings.Split(header.Get("Link"), ",") {
+		parts := strings.Split(link, ";")
+		if len(parts) < 2 {
+			continue
+		}
+		for _, param := range parts[1:] {
+			if strings.TrimSpace(param) == `rel="next"` {
+				return strings.Trim(strings.TrimSpace(parts[0]), "<>")
+			}
+		}
+	}
+	return ""
+}
+
+func pathEscape(projectKey, slug string) string {
+	return url.PathEscape(projectKey) + "/" + url.PathEscape(slug)
+}
@@ -0,0 +1,370 @@
+package stashkins
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSCMProviders(t *testing.T) {
+	var tests = []struct {
+		kind    string
+		handler func(server *httptest.Server) http.HandlerFunc
+		auth    func(r *http.Request) bool
+	}{
+		{
+			kind: SCMBitbucketServer,
+			handler: func(server *httptest.Server) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					switch r.URL.Path {
+					case "/rest/api/1.0/projects/PROJ/repos/slug":
+						fmt.Fprint(w, `{"links": {"clone": [{"name": "http", "href": "https://bitbucket/scm/proj/slug.git"}, {"name": "ssh", "href": "ssh://git@bitbucket:7999/proj/slug.git"}]}}`)
+					case "/rest/api/1.0/projects/PROJ/repos/slug/pull-requests":
+						fmt.Fprint(w, `{"values": [{"id": 7, "title": "Seven", "author": {"user": {"name": "jdoe"}}, "fromRef": {"id": "refs/heads/feature/1", "displayId": "feature/1"}, "toRef": {"id": "refs/heads/develop", "displayId": "develop"}}], "isLastPage": true}`)
+					case "/rest/api/1.0/projects/PROJ/repos/slug/tags":
+						fmt.Fprint(w, `{"values": [{"id": "refs/tags/v1.0.0", "displayId": "v1.0.0", "latestCommit": "abc123"}], "isLastPage": true}`)
+					case "/rest/api/1.0/projects/PROJ/repos/slug/branches":
+						if r.URL.Query().Get("start") == "0" {
+							fmt.Fprint(w, `{"values": [{"id": "refs/heads/develop", "displayId": "develop", "latestCommit": "abc123", "metadata": {"com.atlassian.bitbucket.server.bitbucket-branch:latest-commit-metadata": {"authorTimestamp": 1, "committerTimestamp": 1577836800000}}}], "isLastPage": false, "nextPageStart": 1}`)
+						} else {
+							fmt.Fprint(w, `{"values": [{"id": "refs/heads/feature/1", "displayId": "feature/1", "latestChangeset": "abc123", "metadata": {"com.atlassian.stash.stash-branch-utils:latest-changeset-metadata": {"authorTimestamp": 1577836800000}}}], "isLastPage": true}`)
+						}
+					default:
+						http.NotFound(w, r)
+					}
+				}
+			},
+			auth: func(r *http.Request) bool {
+				user, password, ok := r.BasicAuth()
+				return ok && user == "user" && password == "token"
+			},
+		},
+		{
+			kind: SCMGitHub,
+			handler: func(server *httptest.Server) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					switch r.URL.Path {
+					case "/repos/PROJ/slug":
+						fmt.Fprint(w, `{"ssh_url": "git@github.com:PROJ/slug.git", "clone_url": "https://github.com/PROJ/slug.git"}`)
+					case "/repos/PROJ/slug/pulls":
+						fmt.Fprint(w, `[{"number": 7, "title": "Seven", "user": {"login": "jdoe"}, "head": {"ref": "feature/1"}, "base": {"ref": "develop"}}]`)
+					case "/repos/PROJ/slug/tags":
+						fmt.Fprint(w, `[{"name": "v1.0.0", "commit": {"sha": "abc123"}}]`)
+					case "/graphql":
+						var query struct {
+							Query     string            `json:"query"`
+							Variables map[string]string `json:"variables"`
+						}
+						if r.Method != "POST" || json.NewDecoder(r.Body).Decode(&query) != nil || query.Variables["owner"] != "PROJ" || query.Variables["name"] != "slug" {
+							http.Error(w, "bad query", http.StatusBadRequest)
+							return
+						}
+						if query.Variables["after"] == "" {
+							fmt.Fprint(w, `{"data": {"repository": {"refs": {"nodes": [{"name": "develop", "target": {"oid": "abc123", "committedDate": "2020-01-01T00:00:00Z"}}], "pageInfo": {"hasNextPage": true, "endCursor": "MQ"}}}}}`)
+						} else {
+							fmt.Fprint(w, `{"data": {"repository": {"refs": {"nodes": [{"name": "feature/1", "target": {"oid": "abc123", "committedDate": "2020-01-01T00:00:00Z"}}], "pageInfo": {"hasNextPage": false, "endCursor": "Mg"}}}}}`)
+						}
+					case "/repos/PROJ/slug/branches":
+						if r.URL.Query().Get("page") == "" {
+							w.Header().Set("Link", fmt.Sprintf(`<%s/repos/PROJ/slug/branches?per_page=100&page=2>; rel="next", <%s/repos/PROJ/slug/branches?per_page=100&page=2>; rel="last"`, server.URL, server.URL))
+							fmt.Fprint(w, `[{"name": "develop", "commit": {"sha": "abc123"}}]`)
+						} else {
+							fmt.Fprint(w, `[{"name": "feature/1", "commit": {"sha": "abc123"}}]`)
+						}
+					default:
+						http.NotFound(w, r)
+					}
+				}
+			},
+			auth: func(r *http.Request) bool {
+				return r.Header.Get("Authorization") == "token token"
+			},
+		},
+		{
+			kind: SCMGitLab,
+			handler: func(server *httptest.Server) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					switch r.URL.EscapedPath() {
+					case "/api/v4/projects/PROJ%2Fslug":
+						fmt.Fprint(w, `{"ssh_url_to_repo": "git@gitlab:PROJ/slug.git", "http_url_to_repo": "https://gitlab/PROJ/slug.git"}`)
+					case "/api/v4/projects/PROJ%2Fslug/merge_requests":
+						fmt.Fprint(w, `[{"iid": 7, "title": "Seven", "author": {"username": "jdoe"}, "source_branch": "feature/1", "target_branch": "develop"}]`)
+					case "/api/v4/projects/PROJ%2Fslug/repository/tags":
+						fmt.Fprint(w, `[{"name": "v1.0.0", "commit": {"id": "abc123"}}]`)
+					case "/api/v4/projects/PROJ%2Fslug/repository/branches":
+						if r.URL.Query().Get("page") == "1" {
+							w.Header().Set("X-Next-Page", "2")
+							fmt.Fprint(w, `[{"name": "develop", "commit": {"id": "abc123", "committed_date": "2020-01-01T00:00:00.000+00:00"}}]`)
+						} else {
+							fmt.Fprint(w, `[{"name": "feature/1", "commit": {"id": "abc123", "committed_date": "2020-01-01T00:00:00.000+00:00"}}]`)
+						}
+					default:
+						http.NotFound(w, r)
+					}
+				}
+			},
+			auth: func(r *http.Request) bool {
+				return r.Header.Get("PRIVATE-TOKEN") == "token"
+			},
+		},
+		{
+			kind: SCMGitea,
+			handler: func(server *httptest.Server) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					switch r.URL.Path {
+					case "/api/v1/repos/PROJ/slug":
+						fmt.Fprint(w, `{"ssh_url": "git@gitea:PROJ/slug.git", "clone_url": "https://gitea/PROJ/slug.git"}`)
+					case "/api/v1/repos/PROJ/slug/pulls":
+						if r.URL.Query().Get("page") == "1" {
+							fmt.Fprint(w, `[{"number": 7, "title": "Seven", "user": {"login": "jdoe"}, "head": {"ref": "feature/1"}, "base": {"ref": "develop"}}]`)
+						} else {
+							fmt.Fprint(w, `[]`)
+						}
+					case "/api/v1/repos/PROJ/slug/tags":
+						if r.URL.Query().Get("page") == "1" {
+							fmt.Fprint(w, `[{"name": "v1.0.0", "commit": {"sha": "abc123"}}]`)
+						} else {
+							fmt.Fprint(w, `[]`)
+						}
+					case "/api/v1/repos/PROJ/slug/branches":
+						switch r.URL.Query().Get("page") {
+						case "1":
+							branches := make([]string, 0, giteaPageLimit)
+							branches = append(branches, `{"name": "develop", "commit": {"id": "abc123", "timestamp": "2020-01-01T00:00:00Z"}}`)
+							for i := 1; i < giteaPageLimit; i++ {
+								branches = append(branches, fmt.Sprintf(`{"name": "feature/%d"}`, i+1))
+							}
+							fmt.Fprintf(w, "[%s]", strings.Join(branches, ","))
+						case "2":
+							fmt.Fprint(w, `[{"name": "feature/1", "commit": {"id": "abc123", "timestamp": "2020-01-01T00:00:00Z"}}]`)
+						default:
+							fmt.Fprint(w, `[]`)
+						}
+					default:
+						http.NotFound(w, r)
+					}
+				}
+			},
+			auth: func(r *http.Request) bool {
+				return r.Header.Get("Authorization") == "token token"
+			},
+		},
+	}
+
+	for _, test := range tests {
+		var server *httptest.Server
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !test.auth(r) {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			test.handler(server)(w, r)
+		}))
+
+		for _, protocol := range []string{CloneSSH, CloneHTTPS} {
+			provider, err := NewSCMProvider(test.kind, WebClientParams{URL: server.URL + "/", UserName: "user", Password: "token"}, protocol)
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %v\n", test.kind, err)
+			}
+
+			repository, err := provider.Repository("PROJ", "slug")
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %v\n", test.kind, err)
+			}
+			if protocol == CloneSSH && !strings.Contains(repository.CloneURL, "git@") {
+				t.Fatalf("%s: want an ssh clone URL but got %s\n", test.kind, repository.CloneURL)
+			}
+			if protocol == CloneHTTPS && !strings.HasPrefix(repository.CloneURL, "https://") {
+				t.Fatalf("%s: want an https clone URL but got %s\n", test.kind, repository.CloneURL)
+			}
+			if !strings.HasPrefix(repository.HTTPCloneURL, "https://") {
+				t.Fatalf("%s: want an https clone URL whatever the protocol but got %s\n", test.kind, repository.HTTPCloneURL)
+			}
+			if repository.ProjectKey != "PROJ" || repository.Slug != "slug" {
+				t.Fatalf("%s: want PROJ/slug but got %s/%s\n", test.kind, repository.ProjectKey, repository.Slug)
+			}
+		}
+
+		provider, _ := NewSCMProvider(test.kind, WebClientParams{URL: server.URL, UserName: "user", Password: "token"}, CloneSSH)
+		branches, err := provider.Branches("PROJ", "slug")
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v\n", test.kind, err)
+		}
+		if _, present := branches["develop"]; !present {
+			t.Fatalf("%s: want develop from the first page but got %v\n", test.kind, branches)
+		}
+		if branch, present := branches["feature/1"]; !present || branch.ID != "refs/heads/feature/1" {
+			t.Fatalf("%s: want refs/heads/feature/1 from the last page but got %v\n", test.kind, branches)
+		}
+
+		heads, err := provider.BranchHeads("PROJ", "slug")
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v\n", test.kind, err)
+		}
+		newYear := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		for _, name := range []string{"develop", "feature/1"} {
+			if !heads[name].Time.Equal(newYear) {
+				t.Fatalf("%s: want %s last committed at %v but got %v\n", test.kind, name, newYear, heads[name].Time)
+			}
+			if heads[name].Commit != "abc123" {
+				t.Fatalf("%s: want %s at abc123 but got %s\n", test.kind, name, heads[name].Commit)
+			}
+		}
+
+		pullRequests, err := provider.PullRequests("PROJ", "slug")
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v\n", test.kind, err)
+		}
+		if len(pullRequests) != 1 {
+			t.Fatalf("%s: want 1 pull request but got %d\n", test.kind, len(pullRequests))
+		}
+		pr := pullRequests[0]
+		if pr.ID != 7 || pr.Title != "Seven" || pr.Author != "jdoe" || pr.SourceBranch != "feature/1" || pr.TargetBranch != "develop" || !strings.Contains(pr.MergeRef, "/7/") {
+			t.Fatalf("%s: want pull request 7 from feature/1 into develop but got %+v\n", test.kind, pr)
+		}
+
+		tags, err := provider.Tags("PROJ", "slug")
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v\n", test.kind, err)
+		}
+		if len(tags) != 1 || tags[0].Name != "v1.0.0" || tags[0].Commit != "abc123" {
+			t.Fatalf("%s: want v1.0.0 at abc123 but got %+v\n", test.kind, tags)
+		}
+
+		server.Close()
+	}
+}
+
+func TestSCMProviderErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	provider, err := NewSCMProvider(SCMGitHub, WebClientParams{URL: server.URL, Password: "wrong"}, CloneHTTPS)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if _, err := provider.Branches("PROJ", "slug"); err == nil {
+		t.Fatalf("Want an error for HTTP status 401\n")
+	}
+
+	for _, test := range []struct{ kind, protocol string }{
+		{"svn", CloneSSH},
+		{SCMGitHub, "ftp"},
+		{SCMStash, CloneHTTPS},
+	} {
+		if _, err := NewSCMProvider(test.kind, WebClientParams{URL: server.URL}, test.protocol); err == nil {
+			t.Fatalf("Want an error for provider %s over %s\n", test.kind, test.protocol)
+		}
+	}
+}
+
+func TestGitHubGraphQLPath(t *testing.T) {
+	for url, want := range map[string]string{
+		"https://api.github.com":             "https://api.github.com/graphql",
+		"https://github.example.com/api/v3/": "https://github.example.com/api/graphql",
+		"https://github.example.com/api/v3":  "https://github.example.com/api/graphql",
+	} {
+		provider, _ := NewSCMProvider(SCMGitHub, WebClientParams{URL: url}, CloneSSH)
+		if got := provider.(gitHubProvider).graphQLPath(); got != want {
+			t.Fatalf("%s: want %s but got %s\n", url, want, got)
+		}
+	}
+}
+
+func TestNextLink(t *testing.T) {
+	var tests = []struct {
+		link string
+		want string
+	}{
+		{"", ""},
+		{`<https://api.github.com/repositories/1/branches?page=2>; rel="next", <https://api.github.com/repositories/1/branches?page=5>; rel="last"`, "https://api.github.com/repositories/1/branches?page=2"},
+		{`<https://api.github.com/repositories/1/branches?page=1>; rel="first", <https://api.github.com/repositories/1/branches?page=4>; rel="prev"`, ""},
+	}
+	for _, test := range tests {
+		header := http.Header{}
+		header.Set("Link", test.link)
+		if got := nextLink(header); got != test.want {
+			t.Fatalf("Want %s but got %s\n", test.want, got)
+		}
+	}
+}
+
+func TestGiteaPagesCappedBelowLimit(t *testing.T) {
+	// A Gitea server whose MAX_RESPONSE_ITEMS is 2 answers every request for 50 branches with at most 2.
+	names := []string{"develop", "feature/1", "feature/2", "feature/3", "feature/4"}
+	for _, headers := range []string{"none", "total", "link"} {
+		var server *httptest.Server
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var page int
+			fmt.Sscan(r.URL.Query().Get("page"), &page)
+			last := (len(names) + 1) / 2
+			switch headers {
+			case "total":
+				w.Header().Set("X-Total-Count", fmt.Sprint(len(names)))
+			case "link":
+				if page < last {
+					w.Header().Set("Link", fmt.Sprintf(`<%s%s?page=%d&limit=2>; rel="next"`, server.URL, r.URL.Path, page+1))
+				} else {
+					w.Header().Set("Link", fmt.Sprintf(`<%s%s?page=1&limit=2>; rel="first"`, server.URL, r.URL.Path))
+				}
+			}
+			values := make([]string, 0, 2)
+			for i := (page - 1) * 2; i >= 0 && i < page*2 && i < len(names); i++ {
+				values = append(values, fmt.Sprintf(`{"name": "%s"}`, names[i]))
+			}
+			fmt.Fprintf(w, "[%s]", strings.Join(values, ","))
+		}))
+
+		provider, _ := NewSCMProvider(SCMGitea, WebClientParams{URL: server.URL, Password: "token"}, CloneSSH)
+		branches, err := provider.Branches("PROJ", "slug")
+		server.Close()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v\n", headers, err)
+		}
+		if len(branches) != len(names) {
+			t.Fatalf("%s: want %d branches but got %v\n", headers, len(names), branches)
+		}
+	}
+}
+
+func TestRESTClientKeepsCredentialsOnHost(t *testing.T) {
+	var leaked bool
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		leaked = leaked || r.Header.Get("Authorization") != ""
+		fmt.Fprint(w, `[]`)
+	}))
+	defer other.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", fmt.Sprintf(`<%s/repos/PROJ/slug/branches?page=2>; rel="next"`, other.URL))
+		fmt.Fprint(w, `[{"name": "develop"}]`)
+	}))
+	defer server.Close()
+
+	provider, _ := NewSCMProvider(SCMGitHub, WebClientParams{URL: server.URL, Password: "token"}, CloneSSH)
+	if _, err := provider.Branches("PROJ", "slug"); err == nil {
+		t.Fatalf("Want an error for a next page on another host\n")
+	}
+	if leaked {
+		t.Fatalf("Want no credentials sent to another host\n")
+	}
+}
+
+func TestRESTClientTimesOut(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	provider, _ := NewSCMProvider(SCMGitHub, WebClientParams{URL: server.URL, Password: "token", Timeout: 50 * time.Millisecond}, CloneSSH)
+	if _, err := provider.Branches("PROJ", "slug"); err == nil {
+		t.Fatalf("Want an error from an SCM provider that does not respond\n")
+	}
+}
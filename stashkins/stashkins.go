@@ -3,13 +3,13 @@ package stashkins
 import (
 	"bytes"
 	"fmt"
-	"log"
 	"net/url"
-	"os"
 	"text/template"
+	"time"
 
 	"strings"
 
+	"github.com/sirupsen/logrus"
 	"github.com/xoom/jenkins"
 	"github.com/xoom/maventools"
 	"github.com/xoom/stash"
@@ -17,9 +17,22 @@ import (
 
 type (
 
+	// Fields common to every job model.  Like the fields of the models embedding them, their names cannot be changed without
+	// changing the same names in the text templates in the template repository.
+	JobFields struct {
+		ProjectKey        string            // PROJ
+		Slug              string            // code
+		BranchBaseName    string            // feature, as in feature/PROJ-999, or empty for tag jobs
+		BranchSuffix      string            // -PROJ-999, as in feature/PROJ-999, or empty for mainline branches
+		HTTPRepositoryURL string            // https://example.com/scm/proj/code.git, whatever the clone protocol
+		LatestCommit      string            // the commit at the head of the branch, or the tag's commit for tag jobs
+		Parameters        map[string]string // custom parameters from configuration
+	}
+
 	// Maven job model.  The name of these fields cannot be changed without
 	// changing the same names in the text templates in the template repository.
 	MavenJob struct {
+		JobFields
 		JobName                    string // code in ssh://git@example.com:9999/teamp/code.git
 		Description                string // mashup of repository URL and branch name
 		BranchName                 string // feature/PROJ-999, as in feature/PROJ-999
@@ -30,17 +43,45 @@ type (
 
 	// Freestyle job model
 	FreestyleJob struct {
+		JobFields
 		JobName       string // code in ssh://git@example.com:9999/teamp/code.git
 		Description   string // mashup of repository URL and branch name
 		BranchName    string // feature/PROJ-999, as in feature/PROJ-999
 		RepositoryURL string // ssh://git@example.com:9999/teamp/code.git
 	}
 
+	// Pull request job model
+	PullRequestJob struct {
+		JobFields
+		JobName       string // PROJ-code-pullrequest-42
+		Description   string // mashup of repository and pull request
+		BranchName    string // the source branch, feature/PROJ-999
+		RepositoryURL string // ssh://git@example.com:9999/teamp/code.git
+		PullRequestID int    // 42
+		SourceBranch  string // feature/PROJ-999
+		TargetBranch  string // develop
+		Author        string // the author's login, XML escaped
+		Title         string // the pull request title, XML escaped
+		MergeRef      string // the ref to build, refs/pull-requests/42/merge
+	}
+
+	// Tag release job model
+	TagJob struct {
+		JobFields
+		JobName       string // PROJ-code-tagrelease-v1.2.3
+		Description   string // mashup of repository and tag
+		RepositoryURL string // ssh://git@example.com:9999/teamp/code.git
+		TagName       string // v1.2.3
+		TagRef        string // refs/tags/v1.2.3
+		Commit        string // the commit the tag points to
+	}
+
 	// Generic struct to hold a network URL and login
 	WebClientParams struct {
 		URL      string
 		UserName string
 		Password string
+		Timeout  time.Duration // bounds each request, or DefaultRequestTimeout if 0
 	}
 
 	// A Nexus / Maven client needs more than a URL and login, namely, a feature branch repository ID.
@@ -49,26 +90,66 @@ type (
 		WebClientParams
 	}
 
+	// ReconcileOptions tune how ReconcileJobs treats jobs that already exist.
+	ReconcileOptions struct {
+		// RepairDrift causes existing jobs whose configuration differs from their rendered template to be updated.
+		RepairDrift bool
+
+		// JenkinsJobsDirectory, if set, is where existing job configuration is read from instead of over HTTP.
+		JenkinsJobsDirectory string
+
+		// DeletionGuard, if set, refuses deletion of obsolete jobs beyond its limits.
+		DeletionGuard *DeletionGuard
+
+		// Retirement determines whether obsolete jobs are deleted or archived, and how long archived jobs are kept.
+		Retirement RetirementPolicy
+
+		// Tags determines which tags get release jobs and how many of those jobs are kept.
+		Tags TagPolicy
+
+		// JobNamePrefix, if set, begins the repository's job names in place of project-key-slug.
+		JobNamePrefix string
+
+		// Parameters are custom parameters given to every job model.
+		Parameters map[string]string
+	}
+
 	// The core Stashkins functionality is articulated here.
 	DefaultStashkins struct {
 		stashParams   WebClientParams
 		jenkinsParams WebClientParams
 		nexusParams   MavenRepositoryParams
 
-		stashClient   stash.Stash
+		scm           SCMProvider
 		jenkinsClient jenkins.Jenkins
-		NexusClient   maventools.NexusClient
+		NexusClient   MavenRepositoryClient
 
 		branchOperations BranchOperations
+
+		Options ReconcileOptions
+
+		workers      int
+		scmLimit     semaphore
+		jenkinsLimit semaphore
+		stop         <-chan struct{}
+		metrics      *Metrics
 	}
 
 	// A record in the template repository
 	JobTemplate struct {
-		ProjectKey            string
-		Slug                  string
-		ContinuousJobTemplate []byte
-		ReleaseJobTemplate    []byte
-		JobType               jenkins.JobType
+		ProjectKey             string
+		Slug                   string
+		ContinuousJobTemplate  []byte
+		ReleaseJobTemplate     []byte
+		PullRequestJobTemplate []byte
+		TagJobTemplate         []byte
+		JobType                jenkins.JobType
+
+		// Settings are those from the repository's stashkins.yaml, keyed by setting name.  Lists are joined with commas.
+		Settings map[string]string
+
+		// SettingsError is why the repository's stashkins.yaml could not be read or parsed, or nil.
+		SettingsError error
 	}
 
 	JobDescriptorNG struct {
@@ -76,28 +157,34 @@ type (
 		Branch  stash.Branch
 	}
 
+	PullRequestJobDescriptor struct {
+		JobName     string
+		PullRequest PullRequest
+	}
+
+	TagJobDescriptor struct {
+		JobName string
+		Tag     Tag
+	}
+
 	// Jobs have aspects.  Maven jobs create and delete per-branch repositories.
 	Aspect interface {
 		MakeModel(newJobName, newJobDescription, gitRepositoryURL, branch string, templateRecord JobTemplate) interface{}
 		PostJobDeleteTasks(jobName, gitRepositoryURL, branchName string, templateRecord JobTemplate) error
 		PostJobCreateTasks(newJobName, newJobDescription, gitRepositoryURL, branch string, templateRecord JobTemplate) error
+		PlanJobDeleteTasks(jobName, gitRepositoryURL, branchName string, templateRecord JobTemplate) []string
+		PlanJobCreateTasks(newJobName, gitRepositoryURL, branch string, templateRecord JobTemplate) []string
+		Resources(branch string, templateRecord JobTemplate) []Resource
 	}
 )
 
-var (
-	Log *log.Logger = log.New(os.Stdout, "", log.Ldate|log.Ltime|log.Lshortfile)
-)
-
 func NewStashkins(stashParams, jenkinsParams WebClientParams, nexusParams MavenRepositoryParams, branchOperations BranchOperations) DefaultStashkins {
-	var err error
-	var stashURL *url.URL
 	var jenkinsURL *url.URL
 
-	stashURL, err = url.Parse(stashParams.URL)
+	scm, err := NewSCMProvider(SCMStash, stashParams, CloneSSH)
 	if err != nil {
 		panic(fmt.Sprintf("Error parsing Stash URL %s: %v\n", stashParams.URL, err))
 	}
-	stashClient := stash.NewClient(stashParams.UserName, stashParams.Password, stashURL)
 
 	jenkinsURL, err = url.Parse(jenkinsParams.URL)
 	if err != nil {
@@ -111,7 +198,7 @@ func NewStashkins(stashParams, jenkinsParams WebClientParams, nexusParams MavenR
 		stashParams:      stashParams,
 		jenkinsParams:    jenkinsParams,
 		nexusParams:      nexusParams,
-		stashClient:      stashClient,
+		scm:              scm,
 		jenkinsClient:    jenkinsClient,
 		branchOperations: branchOperations,
 		NexusClient:      nexusClient,
@@ -121,7 +208,7 @@ func NewStashkins(stashParams, jenkinsParams WebClientParams, nexusParams MavenR
 func (c DefaultStashkins) JobSummariesOverHTTP() ([]jenkins.JobSummary, error) {
 	jobSummaries, err := c.jenkinsClient.GetJobSummaries()
 	if err != nil {
-		Log.Printf("stashkins.getJobSummaries get jobs error: %v\n", err)
+		Log.WithError(err).Error("Cannot get job summaries over HTTP")
 		return nil, err
 	}
 	return jobSummaries, nil
@@ -130,90 +217,269 @@ func (c DefaultStashkins) JobSummariesOverHTTP() ([]jenkins.JobSummary, error) {
 func (c DefaultStashkins) JobSummariesFromFilesystem(root string) ([]jenkins.JobSummary, error) {
 	jobSummaries, err := c.jenkinsClient.GetJobSummariesFromFilesystem(root)
 	if err != nil {
-		Log.Printf("stashkins.getJobSummariesFromFilesystem get jobs error: %v\n", err)
+		Log.WithError(err).WithField("directory", root).Error("Cannot get job summaries from filesystem")
 		return nil, err
 	}
 	return jobSummaries, nil
 }
 
-func (c DefaultStashkins) ReconcileJobs(jobSummaries []jenkins.JobSummary, jobTemplate JobTemplate, jobAspect Aspect) error {
+// scmState is what the SCM provider says of a repository.
+type scmState struct {
+	repository   SCMRepository
+	branches     map[string]stash.Branch
+	stale        map[string]stash.Branch // branches dropped from branches for their age
+	heads        map[string]BranchHead   // nil unless needed
+	pullRequests []PullRequest
+	tags         []Tag
+}
+
+// repositoryState fetches the repository metadata and branches for the given template, setting aside any stale branches,
+// its open pull requests if the template has a pull request template, and its tags if tag jobs are enabled.  Branch heads
+// are fetched only to find stale branches or for templates that use LatestCommit, since some providers take extra requests
+// to list them.  It makes only read calls to the SCM provider.
+func (c DefaultStashkins) repositoryState(jobTemplate JobTemplate) (scmState, error) {
+	var state scmState
+	var err error
 
 	// Fetch the repository metadata
-	gitRepository, err := c.stashClient.GetRepository(jobTemplate.ProjectKey, jobTemplate.Slug)
+	state.repository, err = c.scm.Repository(jobTemplate.ProjectKey, jobTemplate.Slug)
 	if err != nil {
-		Log.Printf("stashkins.ReconcileJobs get project repository error: %v\n", err)
-		return err
+		repositoryLog(jobTemplate).WithError(err).Error("Cannot get repository from SCM provider")
+		return scmState{}, err
 	}
 
 	// Fetch all branches for this repository
-	stashBranches, err := c.stashClient.GetBranches(jobTemplate.ProjectKey, jobTemplate.Slug)
+	state.branches, err = c.scm.Branches(jobTemplate.ProjectKey, jobTemplate.Slug)
 	if err != nil {
-		Log.Printf("stashkins.ReconcileJobs error getting branches from Stash for repository %s/%s: %v\n", jobTemplate.ProjectKey, jobTemplate.Slug, err)
-		return err
+		repositoryLog(jobTemplate).WithError(err).Error("Cannot get branches from SCM provider")
+		return scmState{}, err
+	}
+
+	// Fetch branch heads, only if there is a stale branch filter or a template needs them
+	if c.branchOperations.StaleDays > 0 || usesLatestCommit(jobTemplate) {
+		state.heads, err = c.scm.BranchHeads(jobTemplate.ProjectKey, jobTemplate.Slug)
+		if err != nil {
+			repositoryLog(jobTemplate).WithError(err).Error("Cannot get branch heads from SCM provider")
+			return scmState{}, err
+		}
 	}
 
-	// Calculate the specification CI job names which must by design exist for this project.
-	specCIJobs := c.calculateSpecCIJobs(jobTemplate.ProjectKey, jobTemplate.Slug, stashBranches)
+	// Set aside stale branches, only if there is a stale branch filter
+	state.stale = make(map[string]stash.Branch)
+	if c.branchOperations.StaleDays > 0 {
+		now := time.Now()
+		for name, branch := range state.branches {
+			if c.branchOperations.isStale(name, state.heads[name].Time, now) {
+				repositoryLog(jobTemplate).WithFields(logrus.Fields{"branch": name, "last_commit": state.heads[name].Time}).Debug("Skipping stale branch")
+				state.stale[name] = branch
+				delete(state.branches, name)
+			}
+		}
+	}
+
+	// Fetch open pull requests, only if there is a template with which to build them
+	if len(jobTemplate.PullRequestJobTemplate) > 0 {
+		state.pullRequests, err = c.scm.PullRequests(jobTemplate.ProjectKey, jobTemplate.Slug)
+		if err != nil {
+			repositoryLog(jobTemplate).WithError(err).Error("Cannot get pull requests from SCM provider")
+			return scmState{}, err
+		}
+	}
 
-	// Calculate missing jobs
-	missingCIJobs := c.calculateMissingCIJobs(specCIJobs, jobSummaries)
+	// Fetch tags, only if tag jobs are enabled
+	if c.tagJobsEnabled(jobTemplate) {
+		state.tags, err = c.scm.Tags(jobTemplate.ProjectKey, jobTemplate.Slug)
+		if err != nil {
+			repositoryLog(jobTemplate).WithError(err).Error("Cannot get tags from SCM provider")
+			return scmState{}, err
+		}
+	}
+	return state, nil
+}
 
-	// Calculate obsolete jobs
-	obsoleteCIJobs := c.calculateObsoleteCIJobs(specCIJobs, jobTemplate.ProjectKey, jobTemplate.Slug, jobSummaries)
+// ReconcileJobs creates missing and deletes obsolete jobs for the given template.  The returned report records the outcome
+// of every action attempted, and is populated even when an error is returned.
+func (c DefaultStashkins) ReconcileJobs(jobIndex JobIndex, jobTemplate JobTemplate, jobAspect Aspect) (RepositoryReport, error) {
+	report := NewRepositoryReport(jobTemplate)
 
-	Log.Printf("Number of Git branches for %s/%s: %d\n", jobTemplate.ProjectKey, jobTemplate.Slug, len(stashBranches))
-	Log.Printf("Number of CI specification jobs required for %s/%s: %d\n", jobTemplate.ProjectKey, jobTemplate.Slug, len(specCIJobs))
-	Log.Printf("Number of outstanding CI jobs to be created for %s/%s: %d\n", jobTemplate.ProjectKey, jobTemplate.Slug, len(missingCIJobs))
-	Log.Printf("Number of CI jobs outliving their backing git branch %s/%s: %d\n", jobTemplate.ProjectKey, jobTemplate.Slug, len(obsoleteCIJobs))
+	state, err := c.repositoryState(jobTemplate)
+	if err != nil {
+		report.Error = err.Error()
+		return report, err
+	}
+	gitRepository := state.repository
+
+	plan := c.plan(jobIndex, jobTemplate, state.branches, state.stale)
+	c.planPullRequestJobs(&plan, jobIndex, jobTemplate, state.pullRequests)
+	c.planTagJobs(&plan, jobIndex, jobTemplate, state.tags)
+
+	repositoryLog(jobTemplate).WithFields(logrus.Fields{
+		"branches":      plan.BranchCount,
+		"spec_jobs":     len(plan.SpecJobs),
+		"missing_jobs":  len(plan.MissingJobs),
+		"obsolete_jobs": len(plan.ObsoleteJobs),
+		"pull_requests": len(plan.PullRequestJobs),
+		"tag_jobs":      len(plan.TagJobs),
+	}).Info("Planned reconciliation")
+
+	// Retire old jobs, unless doing so would exceed the deletion limits
+	obsoleteCIJobs := plan.ObsoleteJobs
+	retireAction := ActionDelete
+	if c.Options.Retirement.Archive {
+		retireAction = ActionArchive
+	}
+	if err := c.Options.DeletionGuard.reserve(plan); err != nil {
+		repositoryLog(jobTemplate).WithError(err).Warn("Refusing to retire obsolete jobs")
+		for _, obsoleteJob := range obsoleteCIJobs {
+			report.skip(KindJob, obsoleteJob.JobName, "", retireAction, err.Error())
+		}
+		for _, obsoleteJob := range plan.ObsoletePullRequestJobs {
+			report.skip(KindPullRequestJob, obsoleteJob.JobName, "", ActionDelete, err.Error())
+		}
+		for _, obsoleteJob := range plan.ObsoleteTagJobs {
+			report.skip(KindTagJob, obsoleteJob.JobName, "", ActionDelete, err.Error())
+		}
+		obsoleteCIJobs = nil
+		plan.ObsoletePullRequestJobs = nil
+		plan.ObsoleteTagJobs = nil
+	}
 
-	// Delete old jobs
 	for _, obsoleteJob := range obsoleteCIJobs {
 		jobName := obsoleteJob.JobName
-		if err := c.jenkinsClient.DeleteJob(jobName); err != nil {
-			Log.Printf("stashkins.ReconcileJobs error deleting obsolete job %s, continuing:  %+v\n", jobName, err)
+		recoveredBranchName, _ := c.branchOperations.recoverBranchFromCIJobName(jobName)
+		if !c.Options.Retirement.Archive {
+			if err := c.deleteJob(jobName, KindJob, recoveredBranchName, jobTemplate, &report); err == nil {
+				c.releaseResources(jobName, gitRepository.CloneURL, jobTemplate, jobAspect, &report)
+			}
 			continue
-		} else {
-			Log.Printf("Deleted obsolete job %+v\n", jobName)
 		}
 
-		recoveredBranchName, err := c.branchOperations.recoverBranchFromCIJobName(jobName)
+		archivedName, err := c.archiveJob(jobName)
 		if err != nil {
-			Log.Printf("Warning: %v.  Skipping post-delete-task.\n", err)
-			continue
+			jobLog(jobTemplate, jobName, recoveredBranchName).WithError(err).Error("Cannot archive obsolete job, continuing")
+		} else {
+			jobLog(jobTemplate, jobName, recoveredBranchName).WithField("archived_job", archivedName).Info("Archived obsolete job")
 		}
-
-		if err := jobAspect.PostJobDeleteTasks(jobName, gitRepository.SshUrl(), recoveredBranchName, jobTemplate); err != nil {
-			Log.Printf("Error in post-job-delete-task, but willing to continue: %v\n", err)
+		report.record(KindJob, jobName, recoveredBranchName, ActionArchive, err)
+		if err == nil {
+			c.releaseResources(jobName, gitRepository.CloneURL, jobTemplate, jobAspect, &report)
 		}
 	}
 
+	// Purge archived jobs whose retention has expired.  Their aspect resources went when they were archived, and may since
+	// belong to a live job for a branch of the same name.
+	for _, archivedJob := range plan.PurgeJobs {
+		ciJobName, _, _ := parseArchivedJobName(archivedJob.JobName)
+		recoveredBranchName, _ := c.branchOperations.recoverBranchFromCIJobName(ciJobName)
+		c.deleteJob(archivedJob.JobName, KindArchivedJob, recoveredBranchName, jobTemplate, &report)
+	}
+
 	// Create missing jobs
-	for _, missingJob := range missingCIJobs {
-		newJobName := c.canonicalCIJobName(jobTemplate.ProjectKey, jobTemplate.Slug, missingJob.Branch)
-		newJobDescription := "This is a continuous build for " + jobTemplate.ProjectKey + "-" + jobTemplate.Slug + ", branch " + missingJob.Branch.DisplayID
+	for _, missingJob := range plan.MissingJobs {
+		newJobName := missingJob.JobName
+		branchName := missingJob.Branch.DisplayID
+		newJobDescription := c.continuousJobDescription(jobTemplate, missingJob.Branch)
 
-		model := jobAspect.MakeModel(newJobName, newJobDescription, gitRepository.SshUrl(), missingJob.Branch.DisplayID, jobTemplate)
+		model := c.jobModel(jobAspect, newJobName, newJobDescription, branchName, jobTemplate, state)
 
 		if err := c.createJob(jobTemplate.ContinuousJobTemplate, newJobName, model); err != nil {
-			Log.Printf("Warning: while creating continuous job %s: %v\n", newJobName, err)
+			jobLog(jobTemplate, newJobName, branchName).WithError(err).Error("Cannot create continuous job")
+			report.record(KindJob, newJobName, branchName, ActionCreate, err)
+			for _, resource := range jobAspect.Resources(branchName, jobTemplate) {
+				report.skip(resource.Kind, resource.Name, branchName, ActionCreate, "job creation failed")
+			}
 			continue
 		}
+		report.record(KindJob, newJobName, branchName, ActionCreate, nil)
+		jobLog(jobTemplate, newJobName, branchName).Info("Created job")
+
+		err := jobAspect.PostJobCreateTasks(newJobName, newJobDescription, gitRepository.CloneURL, branchName, jobTemplate)
+		if err != nil {
+			jobLog(jobTemplate, newJobName, branchName).WithError(err).Error("Post-job-create tasks failed")
+		}
+		report.recordResources(jobAspect.Resources(branchName, jobTemplate), branchName, ActionCreate, err)
+	}
+
+	// Create jobs for new pull requests and delete those of merged or declined pull requests
+	c.reconcilePullRequestJobs(plan, jobTemplate, state, &report)
+
+	// Create jobs for new release tags and delete those of removed tags or beyond retention
+	c.reconcileTagJobs(plan, jobTemplate, state, &report)
 
-		if err := jobAspect.PostJobCreateTasks(newJobName, newJobDescription, gitRepository.SshUrl(), missingJob.Branch.DisplayID, jobTemplate); err != nil {
-			Log.Printf("Error in post-job-create-task: %v\n", err)
+	// Repair jobs whose configuration has drifted from the template
+	if c.Options.RepairDrift {
+		for _, drift := range c.detectDrift(plan, jobIndex, jobTemplate, jobAspect, state) {
+			err := c.updateJobConfig(drift.JobName, drift.config)
+			if err != nil {
+				jobLog(jobTemplate, drift.JobName, drift.Branch).WithError(err).Error("Cannot repair drifted job")
+			} else {
+				jobLog(jobTemplate, drift.JobName, drift.Branch).WithField("changes", strings.Join(drift.Changes, ", ")).Info("Repaired drifted job")
+			}
+			kind := KindJob
+			if drift.JobName == c.canonicalReleaseJobName(jobTemplate.ProjectKey, jobTemplate.Slug) {
+				kind = KindReleaseJob
+			} else if c.jobInPullRequestNameSpace(drift.JobName, jobTemplate.ProjectKey, jobTemplate.Slug) {
+				kind = KindPullRequestJob
+			} else if c.jobInTagNameSpace(drift.JobName, jobTemplate.ProjectKey, jobTemplate.Slug) {
+				kind = KindTagJob
+			}
+			report.recordChanges(kind, drift.JobName, drift.Branch, drift.Changes, err)
 		}
 	}
 
-	if c.shouldCreateReleaseJob(jobTemplate.ProjectKey, jobTemplate.Slug, jobSummaries) && len(jobTemplate.ReleaseJobTemplate) > 0 {
-		newJobName := c.canonicalReleaseJobName(jobTemplate.ProjectKey, jobTemplate.Slug)
-		newJobDescription := "This is a release job for " + jobTemplate.ProjectKey + "-" + jobTemplate.Slug
-		model := jobAspect.MakeModel(newJobName, newJobDescription, gitRepository.SshUrl(), "develop", jobTemplate)
-		if err := c.createJob(jobTemplate.ReleaseJobTemplate, newJobName, model); err != nil {
-			return err
+	if plan.ReleaseJob != "" {
+		newJobName := plan.ReleaseJob
+		newJobDescription := c.releaseJobDescription(jobTemplate)
+		releaseBranch := c.branchOperations.releaseBranch()
+		model := c.jobModel(jobAspect, newJobName, newJobDescription, releaseBranch, jobTemplate, state)
+		err := c.createJob(jobTemplate.ReleaseJobTemplate, newJobName, model)
+		report.record(KindReleaseJob, newJobName, releaseBranch, ActionCreate, err)
+		if err != nil {
+			jobLog(jobTemplate, newJobName, releaseBranch).WithError(err).Error("Cannot create release job")
+			report.Error = err.Error()
+			return report, err
 		}
+		jobLog(jobTemplate, newJobName, releaseBranch).Info("Created release job")
 	}
 
-	return nil
+	return report, nil
+}
+
+// deleteJob deletes a job, recording the outcome against the given branch.
+func (c DefaultStashkins) deleteJob(jobName, kind, branchName string, jobTemplate JobTemplate, report *RepositoryReport) error {
+	log := jobLog(jobTemplate, jobName, branchName)
+	err := c.jenkinsClient.DeleteJob(jobName)
+	if err != nil {
+		log.WithError(err).Error("Cannot delete job, continuing")
+	} else {
+		log.Info("Deleted job")
+	}
+	report.record(kind, jobName, branchName, ActionDelete, err)
+	return err
+}
+
+// releaseResources runs the aspect's post-delete tasks for the branch of a CI job that has been deleted or archived.
+func (c DefaultStashkins) releaseResources(ciJobName, gitRepositoryURL string, jobTemplate JobTemplate, jobAspect Aspect, report *RepositoryReport) {
+	recoveredBranchName, err := c.branchOperations.recoverBranchFromCIJobName(ciJobName)
+	log := jobLog(jobTemplate, ciJobName, recoveredBranchName)
+	if err != nil {
+		log.WithError(err).Warn("Skipping post-job-delete tasks")
+		return
+	}
+
+	err = jobAspect.PostJobDeleteTasks(ciJobName, gitRepositoryURL, recoveredBranchName, jobTemplate)
+	if err != nil {
+		log.WithError(err).Error("Post-job-delete tasks failed, continuing")
+	}
+	report.recordResources(jobAspect.Resources(recoveredBranchName, jobTemplate), recoveredBranchName, ActionDelete, err)
+}
+
+func (c DefaultStashkins) releaseJobDescription(jobTemplate JobTemplate) string {
+	return "This is a release job for " + jobTemplate.ProjectKey + "-" + jobTemplate.Slug
+}
+
+func (c DefaultStashkins) continuousJobDescription(jobTemplate JobTemplate, branch stash.Branch) string {
+	return "This is a continuous build for " + jobTemplate.ProjectKey + "-" + jobTemplate.Slug + ", branch " + branch.DisplayID
 }
 
 func (c DefaultStashkins) calculateSpecCIJobs(projectKey, slug string, branches map[string]stash.Branch) []JobDescriptorNG {
@@ -228,92 +494,84 @@ func (c DefaultStashkins) calculateSpecCIJobs(projectKey, slug string, branches
 	return specCIJobNames
 }
 
-func (c DefaultStashkins) calculateMissingCIJobs(specCIJobs []JobDescriptorNG, jobSummaries []jenkins.JobSummary) []JobDescriptorNG {
+func (c DefaultStashkins) calculateMissingCIJobs(specCIJobs []JobDescriptorNG, jobIndex JobIndex) []JobDescriptorNG {
 	missingJobs := make([]JobDescriptorNG, 0)
 	for _, specJob := range specCIJobs {
-		var foundIt bool = false
-		for _, existingJob := range jobSummaries {
-			if existingJob.JobDescriptor.Name == specJob.JobName {
-				foundIt = true
-				break
-			}
-		}
-		if !foundIt {
+		if !jobIndex.Exists(specJob.JobName) {
 			missingJobs = append(missingJobs, specJob)
 		}
 	}
 	return missingJobs
 }
 
-func (c DefaultStashkins) calculateObsoleteCIJobs(specCIJobs []JobDescriptorNG, projectKey, slug string, jobSummaries []jenkins.JobSummary) []JobDescriptorNG {
+func (c DefaultStashkins) calculateObsoleteCIJobs(specCIJobs []JobDescriptorNG, projectKey, slug string, jobIndex JobIndex) []JobDescriptorNG {
+	specJobNames := make(map[string]bool, len(specCIJobs))
+	for _, specJob := range specCIJobs {
+		specJobNames[specJob.JobName] = true
+	}
+
 	obsoleteJobs := make([]JobDescriptorNG, 0)
-	for _, existingJob := range jobSummaries {
-		var jobNotInSpec bool = true
-		for _, specJob := range specCIJobs {
-			if existingJob.JobDescriptor.Name == specJob.JobName {
-				jobNotInSpec = false
-				break
-			}
-		}
-		if jobNotInSpec && c.jobInCINameSpace(existingJob.JobDescriptor.Name, projectKey, slug) {
-			obsoleteJobs = append(obsoleteJobs, JobDescriptorNG{JobName: existingJob.JobDescriptor.Name})
+	for _, existingJobName := range jobIndex.inNameSpace(c.cIJobNameSpace(projectKey, slug)) {
+		if !specJobNames[existingJobName] {
+			obsoleteJobs = append(obsoleteJobs, JobDescriptorNG{JobName: existingJobName})
 		}
 	}
 	return obsoleteJobs
 }
 
 func (c DefaultStashkins) createJob(data []byte, newJobName string, jobModel interface{}) error {
+	hydratedTemplate, err := c.renderJob(data, newJobName, jobModel)
+	if err != nil {
+		return err
+	}
+
+	// Create the job
+	return c.jenkinsClient.CreateJob(newJobName, string(hydratedTemplate))
+}
+
+// renderJob hydrates the job template with the given model.
+func (c DefaultStashkins) renderJob(data []byte, newJobName string, jobModel interface{}) ([]byte, error) {
 	if len(data) == 0 {
-		return fmt.Errorf("Template []byte length==0 for job %s.  Is template XML file missing or spelled incorrectly?", newJobName)
+		return nil, fmt.Errorf("Template []byte length==0 for job %s.  Is template XML file missing or spelled incorrectly?", newJobName)
 	}
 
-	jobTemplate, err := template.New("jobconfig").Parse(string(data))
+	jobTemplate, err := template.New("jobconfig").Funcs(templateFuncs).Parse(string(data))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	hydratedTemplate := bytes.NewBufferString("")
 	err = jobTemplate.Execute(hydratedTemplate, jobModel)
 	if err != nil {
-		Log.Printf("stashkins.createJob cannot hydrate job template %s: %v\n", string(data), err)
-		return err
-	}
-
-	// Create the job
-	err = c.jenkinsClient.CreateJob(newJobName, string(hydratedTemplate.Bytes()))
-	if err != nil {
-		Log.Printf("stashkins.createJob failed to create job %v, continuing...: error==%v\n", newJobName, err)
-		return err
-	} else {
-		Log.Printf("Created job %s\n", newJobName)
+		Log.WithError(err).WithField("job", newJobName).Debugf("Cannot render job template %s", string(data))
+		return nil, err
 	}
+	return hydratedTemplate.Bytes(), nil
+}
 
-	return nil
+func (c DefaultStashkins) shouldCreateReleaseJob(projectKey, slug string, jobIndex JobIndex) bool {
+	return !jobIndex.Exists(c.canonicalReleaseJobName(projectKey, slug))
 }
 
-func (c DefaultStashkins) shouldCreateReleaseJob(projectKey, slug string, jobSummaries []jenkins.JobSummary) bool {
-	releaseJobName := c.canonicalReleaseJobName(projectKey, slug)
-	var foundIt bool = false
-	for _, v := range jobSummaries {
-		if releaseJobName == v.JobDescriptor.Name {
-			foundIt = true
-			break
-		}
+// jobNamePrefix begins the names of all of a repository's jobs.
+func (c DefaultStashkins) jobNamePrefix(projectKey, slug string) string {
+	if c.Options.JobNamePrefix != "" {
+		return c.Options.JobNamePrefix
 	}
-	return !foundIt
+	return projectKey + "-" + slug
 }
 
 func (c DefaultStashkins) canonicalReleaseJobName(projectKey, slug string) string {
-	return fmt.Sprintf("%s-%s-release", projectKey, slug)
+	return c.jobNamePrefix(projectKey, slug) + "-release"
 }
 
 func (c DefaultStashkins) canonicalCIJobName(projectKey, slug string, branch stash.Branch) string {
 	branchBaseName, branchSuffix := c.branchOperations.suffixer(branch.DisplayID)
-	return projectKey + "-" + slug + "-continuous-" + branchBaseName + branchSuffix
+	return c.cIJobNameSpace(projectKey, slug) + branchBaseName + branchSuffix
 }
 
 func (c DefaultStashkins) cIJobNameSpace(projectKey, slug string) string {
-	return fmt.Sprintf("%s-%s-continuous-", projectKey, slug)
+	return c.jobNamePrefix(projectKey, slug) + ciNameSpaceDelimiter
 }
 
 func (c DefaultStashkins) jobInCINameSpace(jobName, projectKey, slug string) bool {
@@ -0,0 +1,175 @@
+package stashkins
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// TagPolicy determines which tags get release jobs built from a repository's tag template.
+type TagPolicy struct {
+	// Pattern selects tags by name, as path.Match does, for example v*.  An empty pattern disables tag jobs.
+	Pattern string
+
+	// Keep is how many tag jobs to keep, for the matching tags with the highest versions.  Zero keeps a job for every
+	// matching tag.
+	Keep int
+}
+
+// Validate reports a malformed pattern or negative Keep.
+func (p TagPolicy) Validate() error {
+	if _, err := path.Match(p.Pattern, ""); err != nil {
+		return fmt.Errorf("invalid tag pattern %s: %v", p.Pattern, err)
+	}
+	if p.Keep < 0 {
+		return fmt.Errorf("tag jobs to keep must not be negative")
+	}
+	return nil
+}
+
+// tagJobsEnabled reports whether the template's repository gets tag jobs.
+func (c DefaultStashkins) tagJobsEnabled(jobTemplate JobTemplate) bool {
+	return len(jobTemplate.TagJobTemplate) > 0 && c.Options.Tags.Pattern != ""
+}
+
+// planTagJobs adds to the plan a job for every retained tag matching the tag pattern, and the deletion of every tag job
+// whose tag was removed, no longer matches, or has fallen out of retention.  With tag jobs disabled, or without a tag
+// template, whether absent or unresolvable, existing tag jobs are left alone.
+func (c DefaultStashkins) planTagJobs(plan *Plan, jobIndex JobIndex, jobTemplate JobTemplate, tags []Tag) {
+	specJobs := make([]TagJobDescriptor, 0)
+	plan.TagJobs = specJobs
+	plan.MissingTagJobs = make([]TagJobDescriptor, 0)
+	plan.ObsoleteTagJobs = make([]TagJobDescriptor, 0)
+	if !c.tagJobsEnabled(jobTemplate) {
+		return
+	}
+
+	for _, tag := range c.Options.Tags.retained(tags) {
+		specJobs = append(specJobs, TagJobDescriptor{JobName: c.canonicalTagJobName(jobTemplate.ProjectKey, jobTemplate.Slug, tag), Tag: tag})
+	}
+
+	specJobNames := make(map[string]bool, len(specJobs))
+	for _, specJob := range specJobs {
+		specJobNames[specJob.JobName] = true
+		if !jobIndex.Exists(specJob.JobName) {
+			plan.MissingTagJobs = append(plan.MissingTagJobs, specJob)
+		}
+	}
+
+	for _, existingJobName := range jobIndex.inNameSpace(c.tagJobNameSpace(jobTemplate.ProjectKey, jobTemplate.Slug)) {
+		if !specJobNames[existingJobName] {
+			plan.ObsoleteTagJobs = append(plan.ObsoleteTagJobs, TagJobDescriptor{JobName: existingJobName})
+		}
+	}
+	plan.TagJobs = specJobs
+}
+
+// reconcileTagJobs deletes the plan's obsolete tag jobs and creates its missing ones.  Tag jobs have no aspect resources, and
+// are always deleted rather than archived.
+func (c DefaultStashkins) reconcileTagJobs(plan Plan, jobTemplate JobTemplate, state scmState, report *RepositoryReport) {
+	for _, obsoleteJob := range plan.ObsoleteTagJobs {
+		log := jobLog(jobTemplate, obsoleteJob.JobName, "")
+		err := c.jenkinsClient.DeleteJob(obsoleteJob.JobName)
+		if err != nil {
+			log.WithError(err).Error("Cannot delete tag release job, continuing")
+		} else {
+			log.Info("Deleted tag release job")
+		}
+		report.record(KindTagJob, obsoleteJob.JobName, "", ActionDelete, err)
+	}
+
+	for _, missingJob := range plan.MissingTagJobs {
+		log := jobLog(jobTemplate, missingJob.JobName, "").WithField("tag", missingJob.Tag.Name)
+		err := c.createJob(jobTemplate.TagJobTemplate, missingJob.JobName, c.tagModel(missingJob, jobTemplate, state))
+		if err != nil {
+			log.WithError(err).Error("Cannot create tag release job")
+		} else {
+			log.Info("Created tag release job")
+		}
+		report.record(KindTagJob, missingJob.JobName, "", ActionCreate, err)
+	}
+}
+
+func (c DefaultStashkins) tagModel(job TagJobDescriptor, jobTemplate JobTemplate, state scmState) TagJob {
+	fields := c.jobFields(jobTemplate, state, "")
+	fields.LatestCommit = job.Tag.Commit
+	return TagJob{
+		JobFields:     fields,
+		JobName:       job.JobName,
+		Description:   c.tagJobDescription(jobTemplate, job.Tag),
+		RepositoryURL: state.repository.CloneURL,
+		TagName:       job.Tag.Name,
+		TagRef:        "refs/tags/" + job.Tag.Name,
+		Commit:        job.Tag.Commit,
+	}
+}
+
+func (c DefaultStashkins) tagJobDescription(jobTemplate JobTemplate, tag Tag) string {
+	return fmt.Sprintf("This is a release build for %s-%s, tag %s", jobTemplate.ProjectKey, jobTemplate.Slug, tag.Name)
+}
+
+// canonicalTagJobName names a tag's job.  Slashes, which Jenkins does not allow in job names, become dashes.
+func (c DefaultStashkins) canonicalTagJobName(projectKey, slug string, tag Tag) string {
+	return c.tagJobNameSpace(projectKey, slug) + strings.Replace(tag.Name, "/", "-", -1)
+}
+
+func (c DefaultStashkins) tagJobNameSpace(projectKey, slug string) string {
+	return c.jobNamePrefix(projectKey, slug) + tagNameSpaceDelimiter
+}
+
+func (c DefaultStashkins) jobInTagNameSpace(jobName, projectKey, slug string) bool {
+	return strings.HasPrefix(jobName, c.tagJobNameSpace(projectKey, slug))
+}
+
+// retained returns the tags matching the pattern, highest version first, limited to Keep tags.
+func (p TagPolicy) retained(tags []Tag) []Tag {
+	matching := make([]Tag, 0, len(tags))
+	for _, tag := range tags {
+		if matched, _ := path.Match(p.Pattern, tag.Name); matched {
+			matching = append(matching, tag)
+		}
+	}
+	sort.Slice(matching, func(i, j int) bool {
+		return compareVersions(matching[i].Name, matching[j].Name) > 0
+	})
+	if p.Keep > 0 && len(matching) > p.Keep {
+		matching = matching[:p.Keep]
+	}
+	return matching
+}
+
+// compareVersions orders tag names as versions, comparing runs of digits numerically and everything else as text, so that
+// v1.10.0 follows v1.9.2.  It returns a negative number, zero or a positive number as a sorts before, equal to or after b.
+func compareVersions(a, b string) int {
+	for a != "" && b != "" {
+		var x, y string
+		x, a = leadingRun(a)
+		y, b = leadingRun(b)
+		if isDigits(x) && isDigits(y) {
+			x, y = strings.TrimLeft(x, "0"), strings.TrimLeft(y, "0")
+			if len(x) != len(y) {
+				return len(x) - len(y)
+			}
+		}
+		if c := strings.Compare(x, y); c != 0 {
+			return c
+		}
+	}
+	return len(a) - len(b)
+}
+
+// leadingRun splits s after its leading run of digits or of non-digits.
+func leadingRun(s string) (string, string) {
+	digits := unicode.IsDigit(rune(s[0]))
+	i := 1
+	for i < len(s) && unicode.IsDigit(rune(s[i])) == digits {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+func isDigits(s string) bool {
+	return s != "" && unicode.IsDigit(rune(s[0]))
+}
@@ -0,0 +1,135 @@
+package stashkins
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/xoom/jenkins"
+	"github.com/xoom/stash"
+)
+
+func TestCompareVersions(t *testing.T) {
+	var tests = []struct {
+		a, b string
+		want int
+	}{
+		{"v1.10.0", "v1.9.2", 1},
+		{"v1.9.2", "v1.10.0", -1},
+		{"v2.0.0", "v2.0.0", 0},
+		{"v2.0.01", "v2.0.1", 0},
+		{"v2.0.0-rc1", "v2.0.0", 1},
+		{"release/2", "release/10", -1},
+	}
+	for _, test := range tests {
+		got := compareVersions(test.a, test.b)
+		if (got < 0 && test.want >= 0) || (got == 0 && test.want != 0) || (got > 0 && test.want <= 0) {
+			t.Fatalf("Want %d comparing %s to %s but got %d\n", test.want, test.a, test.b, got)
+		}
+	}
+}
+
+func TestTagPolicyRetained(t *testing.T) {
+	tags := []Tag{{Name: "v1.9.0"}, {Name: "v1.10.0"}, {Name: "nightly"}, {Name: "v1.2.0"}, {Name: "v1.11.0"}}
+
+	retained := TagPolicy{Pattern: "v*", Keep: 2}.retained(tags)
+	if len(retained) != 2 || retained[0].Name != "v1.11.0" || retained[1].Name != "v1.10.0" {
+		t.Fatalf("Want v1.11.0 and v1.10.0 but got %+v\n", retained)
+	}
+
+	if retained := (TagPolicy{Pattern: "v*"}).retained(tags); len(retained) != 4 {
+		t.Fatalf("Want 4 but got %d\n", len(retained))
+	}
+
+	if err := (TagPolicy{Pattern: "v[", Keep: 1}).Validate(); err == nil {
+		t.Fatalf("Want an error for a malformed pattern\n")
+	}
+	if err := (TagPolicy{Pattern: "v*", Keep: -1}).Validate(); err == nil {
+		t.Fatalf("Want an error for negative Keep\n")
+	}
+}
+
+func TestPlanTagJobs(t *testing.T) {
+	skins := DefaultStashkins{Options: ReconcileOptions{Tags: TagPolicy{Pattern: "v*", Keep: 2}}}
+
+	jobSummaries := []jenkins.JobSummary{
+		jenkins.JobSummary{JobDescriptor: jenkins.JobDescriptor{Name: "proj-slug-tagrelease-v1.0.0"}},
+		jenkins.JobSummary{JobDescriptor: jenkins.JobDescriptor{Name: "proj-slug-tagrelease-v1.1.0"}},
+		jenkins.JobSummary{JobDescriptor: jenkins.JobDescriptor{Name: "proj-slug-release"}},
+	}
+	tags := []Tag{{Name: "v1.0.0", Commit: "a"}, {Name: "v1.1.0", Commit: "b"}, {Name: "hotfix/v1.1.1", Commit: "c"}, {Name: "v1.2.0", Commit: "d"}}
+	jobTemplate := JobTemplate{ProjectKey: "proj", Slug: "slug", TagJobTemplate: []byte("<project/>")}
+
+	var plan Plan
+	skins.planTagJobs(&plan, NewJobIndex(jobSummaries), jobTemplate, tags)
+
+	if len(plan.TagJobs) != 2 {
+		t.Fatalf("Want 2 but got %d\n", len(plan.TagJobs))
+	}
+	if len(plan.MissingTagJobs) != 1 || plan.MissingTagJobs[0].JobName != "proj-slug-tagrelease-v1.2.0" {
+		t.Fatalf("Want proj-slug-tagrelease-v1.2.0 missing but got %+v\n", plan.MissingTagJobs)
+	}
+	if len(plan.ObsoleteTagJobs) != 1 || plan.ObsoleteTagJobs[0].JobName != "proj-slug-tagrelease-v1.0.0" {
+		t.Fatalf("Want proj-slug-tagrelease-v1.0.0 obsolete but got %+v\n", plan.ObsoleteTagJobs)
+	}
+	if s := plan.String(); !strings.Contains(s, "+ create tag release job proj-slug-tagrelease-v1.2.0 (tag v1.2.0)") {
+		t.Fatalf("Want the tag job in the plan but got %s\n", s)
+	}
+
+	model := skins.tagModel(plan.MissingTagJobs[0], jobTemplate, scmState{repository: SCMRepository{CloneURL: "ssh://git@example.com/proj/slug.git"}})
+	if model.TagName != "v1.2.0" || model.TagRef != "refs/tags/v1.2.0" || model.Commit != "d" || model.LatestCommit != "d" {
+		t.Fatalf("Want tag v1.2.0 at d but got %+v\n", model)
+	}
+
+	if name := skins.canonicalTagJobName("proj", "slug", Tag{Name: "hotfix/v1.1.1"}); name != "proj-slug-tagrelease-hotfix-v1.1.1" {
+		t.Fatalf("Want proj-slug-tagrelease-hotfix-v1.1.1 but got %s\n", name)
+	}
+
+	// Without a tag template existing tag jobs are left alone.
+	plan = Plan{}
+	skins.planTagJobs(&plan, NewJobIndex(jobSummaries), JobTemplate{ProjectKey: "proj", Slug: "slug"}, tags)
+	if len(plan.TagJobs) != 0 || len(plan.ObsoleteTagJobs) != 0 {
+		t.Fatalf("Want no tag jobs and none obsolete but got %+v and %+v\n", plan.TagJobs, plan.ObsoleteTagJobs)
+	}
+
+	// Without a pattern tag jobs are disabled, and existing tag jobs are left alone.
+	skins.Options.Tags.Pattern = ""
+	plan = Plan{}
+	skins.planTagJobs(&plan, NewJobIndex(jobSummaries), jobTemplate, tags)
+	if len(plan.TagJobs) != 0 || len(plan.ObsoleteTagJobs) != 0 {
+		t.Fatalf("Want no tag jobs and none obsolete but got %+v and %+v\n", plan.TagJobs, plan.ObsoleteTagJobs)
+	}
+}
+
+func TestReconcileTagOnlyTemplate(t *testing.T) {
+	jenkinsClient := &recordingJenkins{}
+	skins := DefaultStashkins{
+		scm: fixedSCM{
+			repository: SCMRepository{ProjectKey: "proj", Slug: "slug"},
+			branches:   map[string]stash.Branch{"develop": {DisplayID: "develop"}, "feature/1": {DisplayID: "feature/1"}},
+			tags:       []Tag{{Name: "v1.0.0", Commit: "a"}},
+		},
+		jenkinsClient:    jenkinsClient,
+		branchOperations: NewBranchOperations("feature/"),
+		Options:          ReconcileOptions{Tags: TagPolicy{Pattern: "v*"}},
+	}
+	jobSummaries := []jenkins.JobSummary{
+		jenkins.JobSummary{JobDescriptor: jenkins.JobDescriptor{Name: "proj-slug-continuous-feature-2"}},
+	}
+	jobTemplate := JobTemplate{ProjectKey: "proj", Slug: "slug", TagJobTemplate: []byte("<project/>")}
+
+	report, err := skins.ReconcileJobs(NewJobIndex(jobSummaries), jobTemplate, FreestyleAspect{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if !RunSucceeded([]RepositoryReport{report}) {
+		t.Fatalf("Want a successful reconciliation but got %+v\n", report)
+	}
+
+	// Without a continuous template no continuous jobs are created, and those that exist are left alone.
+	if len(jenkinsClient.created) != 1 || jenkinsClient.created[0] != "proj-slug-tagrelease-v1.0.0" {
+		t.Fatalf("Want only proj-slug-tagrelease-v1.0.0 created but got %+v\n", jenkinsClient.created)
+	}
+	if len(jenkinsClient.deleted) != 0 {
+		t.Fatalf("Want no jobs deleted but got %+v\n", jenkinsClient.deleted)
+	}
+}
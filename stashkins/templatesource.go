@@ -0,0 +1,211 @@
+package stashkins
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Template source kinds.
+const (
+	TemplateSourceGit       = "git"
+	TemplateSourceDirectory = "directory"
+	TemplateSourceArchive   = "archive"
+)
+
+type (
+	// A TemplateSource supplies the files of the template repository.  Every source lays the files out the same way, so
+	// templates are found, composed and attached to repositories the same whatever their source.
+	TemplateSource interface {
+		// Fetch makes the template repository's files available and returns the directory holding them.  workDir is
+		// the source's to use, and is kept between fetches so that a source may update what it fetched before.
+		Fetch(workDir string) (string, error)
+	}
+
+	// A GitTemplateSource clones the template repository with an embedded git client, following a branch or checking out
+	// a pinned tag or commit.
+	GitTemplateSource struct {
+		URL    string
+		Branch string // followed, as in master
+
+		// Ref, if set, is a tag or commit checked out in place of following Branch.
+		Ref string
+
+		// Depth, if greater than zero, makes the clone shallow, holding only that many commits of history.
+		Depth int
+
+		Auth GitAuth
+	}
+
+	// A DirectoryTemplateSource reads a template repository already on the filesystem, such as a local checkout.
+	DirectoryTemplateSource struct {
+		Path string
+	}
+
+	// An ArchiveTemplateSource extracts a template repository from a .tar, .tar.gz, .tgz or .zip archive.  The root of the
+	// archive is the root of the template repository.
+	ArchiveTemplateSource struct {
+		Path string
+	}
+)
+
+// Fetch clones the repository into workDir, or updates the clone there.
+func (s GitTemplateSource) Fetch(workDir string) (string, error) {
+	if err := cloneTemplates(s.URL, s.Branch, s.Ref, s.Depth, s.Auth, workDir); err != nil {
+		return "", err
+	}
+	return workDir, nil
+}
+
+// Fetch returns the directory, which it does not copy.
+func (s DirectoryTemplateSource) Fetch(workDir string) (string, error) {
+	info, err := os.Stat(s.Path)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("stashkins.DirectoryTemplateSource %s is not a directory", s.Path)
+	}
+	return s.Path, nil
+}
+
+// Fetch extracts the archive into workDir, replacing anything extracted before.
+func (s ArchiveTemplateSource) Fetch(workDir string) (string, error) {
+	if err := os.RemoveAll(workDir); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(workDir, 0755); err != nil {
+		return "", err
+	}
+
+	name := strings.ToLower(s.Path)
+	var err error
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		err = extractZip(s.Path, workDir)
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		err = extractTar(s.Path, workDir, true)
+	case strings.HasSuffix(name, ".tar"):
+		err = extractTar(s.Path, workDir, false)
+	default:
+		err = fmt.Errorf("stashkins.ArchiveTemplateSource unsupported archive %s:  want .tar, .tar.gz, .tgz or .zip", s.Path)
+	}
+	if err != nil {
+		return "", err
+	}
+	return workDir, nil
+}
+
+// extractTar extracts the directories and regular files of a tar archive, gzip compressed if compressed is set, into dir.
+func extractTar(archive, dir string, compressed bool) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if compressed {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			path, err := extractPath(dir, header.Name)
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if err := extractFile(dir, header.Name, tr); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// extractZip extracts the directories and regular files of a zip archive into dir.
+func extractZip(archive, dir string) error {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, entry := range zr.File {
+		mode := entry.Mode()
+		if mode.IsDir() {
+			path, err := extractPath(dir, entry.Name)
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if !mode.IsRegular() {
+			continue
+		}
+		r, err := entry.Open()
+		if err != nil {
+			return err
+		}
+		err = extractFile(dir, entry.Name, r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractFile writes an archive entry's contents to its path under dir.
+func extractFile(dir, name string, r io.Reader) error {
+	path, err := extractPath(dir, name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// extractPath is the path under dir of an archive entry.  Entries that would land outside dir are refused.
+func extractPath(dir, name string) (string, error) {
+	path := filepath.Join(dir, filepath.FromSlash(name))
+	if path != filepath.Clean(dir) && !strings.HasPrefix(path, filepath.Clean(dir)+string(filepath.Separator)) {
+		return "", fmt.Errorf("stashkins.ArchiveTemplateSource archive entry %s is outside the archive", name)
+	}
+	return path, nil
+}
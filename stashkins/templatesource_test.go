@@ -0,0 +1,131 @@
+package stashkins
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+var sourceContents = map[string]string{
+	"continuous-template.xml":          `<project>{{block "builders" .}}make{{end}}</project>`,
+	"PROJ/app/continuous-template.xml": `{{define "builders"}}mvn{{end}}`,
+	"PROJ/app/stashkins.yaml":          "job-name-prefix: app\n",
+	"PROJ/lib/release-template.xml":    `<maven2-moduleset/>`,
+}
+
+func writeTar(t *testing.T, file string, compressed bool) {
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+	for name, content := range sourceContents {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatalf("Unexpected error: %v\n", err)
+		}
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+
+	data := b.Bytes()
+	if compressed {
+		var z bytes.Buffer
+		gz := gzip.NewWriter(&z)
+		gz.Write(data)
+		gz.Close()
+		data = z.Bytes()
+	}
+	if err := ioutil.WriteFile(file, data, 0644); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+}
+
+func writeZip(t *testing.T, file string, contents map[string]string) {
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	for name, content := range contents {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v\n", err)
+		}
+		w.Write([]byte(content))
+	}
+	zw.Close()
+	if err := ioutil.WriteFile(file, b.Bytes(), 0644); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+}
+
+// describe summarizes templates for comparison.
+func describe(t *testing.T, jobTemplates []JobTemplate) []string {
+	descriptions := make([]string, 0, len(jobTemplates))
+	for _, jobTemplate := range jobTemplates {
+		description := jobTemplate.ProjectKey + "/" + jobTemplate.Slug + " " + jobTemplate.Settings["job-name-prefix"]
+		if len(jobTemplate.ContinuousJobTemplate) > 0 {
+			description += " " + render(t, jobTemplate.ContinuousJobTemplate)
+		}
+		description += " " + string(jobTemplate.ReleaseJobTemplate)
+		descriptions = append(descriptions, description)
+	}
+	sort.Strings(descriptions)
+	return descriptions
+}
+
+func TestTemplateSources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "template-sources-")
+	if err != nil {
+		t.Fatalf("Unexpected error creating temp dir: %v\n", err)
+	}
+	defer os.RemoveAll(dir)
+
+	checkout := filepath.Join(dir, "checkout")
+	for name, content := range sourceContents {
+		file := filepath.Join(checkout, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatalf("Unexpected error: %v\n", err)
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatalf("Unexpected error: %v\n", err)
+		}
+	}
+	writeTar(t, filepath.Join(dir, "templates.tar"), false)
+	writeTar(t, filepath.Join(dir, "templates.tgz"), true)
+	writeZip(t, filepath.Join(dir, "templates.zip"), sourceContents)
+
+	want := []string{"proj/app app <project>mvn</project> ", "proj/lib  <maven2-moduleset/>", "proj/lib  <project>make</project> "}
+	for _, source := range []TemplateSource{
+		DirectoryTemplateSource{Path: checkout},
+		ArchiveTemplateSource{Path: filepath.Join(dir, "templates.tar")},
+		ArchiveTemplateSource{Path: filepath.Join(dir, "templates.tgz")},
+		ArchiveTemplateSource{Path: filepath.Join(dir, "templates.zip")},
+	} {
+		workDir := filepath.Join(dir, "work")
+		jobTemplates, err := Templates(source, workDir)
+		if err != nil {
+			t.Fatalf("%+v: unexpected error: %v\n", source, err)
+		}
+		got := describe(t, jobTemplates)
+		if len(got) != len(want) {
+			t.Fatalf("%+v: want %v but got %v\n", source, want, got)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("%+v: want %s but got %s\n", source, want[i], got[i])
+			}
+		}
+	}
+
+	writeZip(t, filepath.Join(dir, "escape.zip"), map[string]string{"../escaped.xml": "<project/>"})
+	if _, err := (ArchiveTemplateSource{Path: filepath.Join(dir, "escape.zip")}).Fetch(filepath.Join(dir, "work")); err == nil {
+		t.Fatalf("Want an error for an entry outside the archive\n")
+	}
+	if _, err := (ArchiveTemplateSource{Path: filepath.Join(dir, "templates.rar")}).Fetch(filepath.Join(dir, "work")); err == nil {
+		t.Fatalf("Want an error for an unsupported archive\n")
+	}
+	if _, err := (DirectoryTemplateSource{Path: filepath.Join(dir, "templates.tar")}).Fetch(""); err == nil {
+		t.Fatalf("Want an error for a directory source that is a file\n")
+	}
+}
@@ -0,0 +1,209 @@
+package stashkins
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	signatureHeader = "X-Hub-Signature"
+	signaturePrefix = "sha256="
+
+	// maxWebhookPayload bounds the size of a webhook request body.  Ref change payloads are a few kilobytes.
+	maxWebhookPayload = 1 << 20
+)
+
+type (
+	webhookRepository struct {
+		Slug    string `json:"slug"`
+		Project struct {
+			Key string `json:"key"`
+		} `json:"project"`
+	}
+
+	// refChangePayload holds the parts of a Stash or Bitbucket Server webhook payload that Stashkins needs.  Bitbucket Server
+	// repo:refs_changed events list changes, and the Stash post-receive webhook plugin lists refChanges.  Bitbucket Server
+	// pull request events name the repository in the pull request's target ref.
+	refChangePayload struct {
+		EventKey    string            `json:"eventKey"`
+		Repository  webhookRepository `json:"repository"`
+		PullRequest struct {
+			ToRef struct {
+				Repository webhookRepository `json:"repository"`
+			} `json:"toRef"`
+		} `json:"pullRequest"`
+		Changes []struct {
+			Ref struct {
+				ID string `json:"id"`
+			} `json:"ref"`
+			RefID string `json:"refId"`
+			Type  string `json:"type"`
+		} `json:"changes"`
+		RefChanges []struct {
+			RefID string `json:"refId"`
+			Type  string `json:"type"`
+		} `json:"refChanges"`
+	}
+
+	// A WebhookReceiver is an http.Handler for Stash and Bitbucket Server push, ref change and pull request webhooks.  When a
+	// branch is created or deleted, or a pull request opened or closed, it calls its reconcile function for the affected repository once the repository's events have been quiet for
+	// the debounce period, so a burst of pushes causes one reconciliation.
+	WebhookReceiver struct {
+		secret    string
+		debounce  time.Duration
+		reconcile func(projectKey, slug string)
+
+		mu      sync.Mutex
+		pending map[string]*time.Timer
+		closed  bool
+	}
+)
+
+// NewWebhookReceiver returns a receiver that calls reconcile for the repositories whose branches change.  If secret is
+// set, requests must either carry an X-Hub-Signature header holding sha256=<hex HMAC-SHA256 of the body keyed by
+// secret>, as Bitbucket Server sends, or a secret query parameter equal to secret, for Stash webhooks that cannot sign.
+func NewWebhookReceiver(secret string, debounce time.Duration, reconcile func(projectKey, slug string)) *WebhookReceiver {
+	return &WebhookReceiver{secret: secret, debounce: debounce, reconcile: reconcile, pending: make(map[string]*time.Timer)}
+}
+
+func (w *WebhookReceiver) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(rw, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(rw, r.Body, maxWebhookPayload))
+	if err != nil {
+		http.Error(rw, "cannot read payload", http.StatusBadRequest)
+		return
+	}
+
+	if !w.verified(r, body) {
+		Log.WithField("remote_address", r.RemoteAddr).Warn("Rejecting unverified webhook")
+		http.Error(rw, "signature or secret required", http.StatusUnauthorized)
+		return
+	}
+
+	var payload refChangePayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		http.Error(rw, "cannot parse payload", http.StatusBadRequest)
+		return
+	}
+
+	if payload.changesPullRequests() {
+		payload.Repository = payload.PullRequest.ToRef.Repository
+	}
+	projectKey, slug := payload.Repository.Project.Key, payload.Repository.Slug
+	if projectKey == "" || slug == "" || !(payload.changesBranches() || payload.changesPullRequests()) {
+		rw.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	Log.WithFields(logrus.Fields{"project": projectKey, "slug": slug, "event": payload.EventKey}).Info("Webhook reports branches or pull requests changed")
+	w.schedule(projectKey, slug)
+	rw.WriteHeader(http.StatusAccepted)
+}
+
+// verified reports whether the request carries a valid signature or shared secret.  Without a configured secret every
+// request is accepted.
+func (w *WebhookReceiver) verified(r *http.Request, body []byte) bool {
+	if w.secret == "" {
+		return true
+	}
+
+	if signature := r.Header.Get(signatureHeader); signature != "" {
+		if !strings.HasPrefix(signature, signaturePrefix) {
+			return false
+		}
+		got, err := hex.DecodeString(signature[len(signaturePrefix):])
+		if err != nil {
+			return false
+		}
+		mac := hmac.New(sha256.New, []byte(w.secret))
+		mac.Write(body)
+		return hmac.Equal(got, mac.Sum(nil))
+	}
+
+	return subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("secret")), []byte(w.secret)) == 1
+}
+
+// changesBranches reports whether the payload creates or deletes a branch.  Updates to existing branches do not change
+// which jobs a repository needs.
+func (p refChangePayload) changesBranches() bool {
+	for _, change := range p.Changes {
+		refID := change.Ref.ID
+		if refID == "" {
+			refID = change.RefID
+		}
+		if isBranchCreateOrDelete(refID, change.Type) {
+			return true
+		}
+	}
+	for _, change := range p.RefChanges {
+		if isBranchCreateOrDelete(change.RefID, change.Type) {
+			return true
+		}
+	}
+	return false
+}
+
+// changesPullRequests reports whether the payload opens or closes a pull request.  Other pull request events do not change
+// which jobs a repository needs.
+func (p refChangePayload) changesPullRequests() bool {
+	switch p.EventKey {
+	case "pr:opened", "pr:merged", "pr:declined", "pr:deleted":
+		return true
+	}
+	return false
+}
+
+func isBranchCreateOrDelete(refID, changeType string) bool {
+	return strings.HasPrefix(refID, "refs/heads/") && (changeType == "ADD" || changeType == "DELETE")
+}
+
+// schedule arranges for the repository to be reconciled once the debounce period passes without further events for it.
+func (w *WebhookReceiver) schedule(projectKey, slug string) {
+	key := strings.ToLower(projectKey + "/" + slug)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+
+	if timer, present := w.pending[key]; present && timer.Stop() {
+		timer.Reset(w.debounce)
+		return
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(w.debounce, func() {
+		w.mu.Lock()
+		if w.pending[key] == timer {
+			delete(w.pending, key)
+		}
+		w.mu.Unlock()
+		w.reconcile(projectKey, slug)
+	})
+	w.pending[key] = timer
+}
+
+// Close cancels pending reconciliations and ignores further events.  A reconciliation already started is not interrupted.
+func (w *WebhookReceiver) Close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	for key, timer := range w.pending {
+		timer.Stop()
+		delete(w.pending, key)
+	}
+}
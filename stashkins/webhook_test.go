@@ -0,0 +1,124 @@
+package stashkins
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const refsChangedPayload = `{
+  "eventKey": "repo:refs_changed",
+  "repository": {"slug": "slug", "project": {"key": "PROJ"}},
+  "changes": [{"ref": {"id": "refs/heads/feature/1", "displayId": "feature/1", "type": "BRANCH"}, "type": "ADD"}]
+}`
+
+func sign(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestWebhookVerification(t *testing.T) {
+	receiver := NewWebhookReceiver("s3cret", time.Hour, func(projectKey, slug string) {})
+	defer receiver.Close()
+
+	var tests = []struct {
+		url       string
+		signature string
+		want      int
+	}{
+		{"/webhook", "", http.StatusUnauthorized},
+		{"/webhook?secret=wrong", "", http.StatusUnauthorized},
+		{"/webhook?secret=s3cret", "", http.StatusAccepted},
+		{"/webhook", sign("wrong", refsChangedPayload), http.StatusUnauthorized},
+		{"/webhook", "sha256=zz", http.StatusUnauthorized},
+		{"/webhook", sign("s3cret", refsChangedPayload), http.StatusAccepted},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("POST", test.url, strings.NewReader(refsChangedPayload))
+		if test.signature != "" {
+			req.Header.Set("X-Hub-Signature", test.signature)
+		}
+		rec := httptest.NewRecorder()
+		receiver.ServeHTTP(rec, req)
+		if rec.Code != test.want {
+			t.Fatalf("Want %d but got %d for %+v\n", test.want, rec.Code, test)
+		}
+	}
+}
+
+func TestWebhookIgnoresBranchUpdates(t *testing.T) {
+	receiver := NewWebhookReceiver("", time.Millisecond, func(projectKey, slug string) {
+		t.Fatalf("Want no reconciliation for a branch update\n")
+	})
+	defer receiver.Close()
+
+	payload := `{"repository": {"slug": "slug", "project": {"key": "PROJ"}}, "refChanges": [{"refId": "refs/heads/feature/1", "type": "UPDATE"}]}`
+	rec := httptest.NewRecorder()
+	receiver.ServeHTTP(rec, httptest.NewRequest("POST", "/webhook", strings.NewReader(payload)))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("Want %d but got %d\n", http.StatusNoContent, rec.Code)
+	}
+	time.Sleep(10 * time.Millisecond)
+}
+
+func TestWebhookPullRequestEvents(t *testing.T) {
+	var tests = []struct {
+		eventKey string
+		want     int
+	}{
+		{"pr:opened", http.StatusAccepted},
+		{"pr:merged", http.StatusAccepted},
+		{"pr:declined", http.StatusAccepted},
+		{"pr:comment:added", http.StatusNoContent},
+	}
+	for _, test := range tests {
+		receiver := NewWebhookReceiver("", time.Hour, func(projectKey, slug string) {})
+		payload := `{"eventKey": "` + test.eventKey + `", "pullRequest": {"id": 1, "toRef": {"id": "refs/heads/develop", "repository": {"slug": "slug", "project": {"key": "PROJ"}}}}}`
+		rec := httptest.NewRecorder()
+		receiver.ServeHTTP(rec, httptest.NewRequest("POST", "/webhook", strings.NewReader(payload)))
+		if rec.Code != test.want {
+			t.Fatalf("Want %d for %s but got %d\n", test.want, test.eventKey, rec.Code)
+		}
+		if test.want == http.StatusAccepted && receiver.pending["proj/slug"] == nil {
+			t.Fatalf("Want PROJ/slug scheduled for %s\n", test.eventKey)
+		}
+		receiver.Close()
+	}
+}
+
+func TestWebhookDebounce(t *testing.T) {
+	var mu sync.Mutex
+	reconciled := make(map[string]int)
+	receiver := NewWebhookReceiver("", 50*time.Millisecond, func(projectKey, slug string) {
+		mu.Lock()
+		defer mu.Unlock()
+		reconciled[projectKey+"/"+slug]++
+	})
+	defer receiver.Close()
+
+	legacyPayload := `{"repository": {"slug": "other", "project": {"key": "PROJ"}}, "refChanges": [{"refId": "refs/heads/feature/2", "type": "DELETE"}]}`
+	for i := 0; i < 5; i++ {
+		for _, payload := range []string{refsChangedPayload, legacyPayload} {
+			rec := httptest.NewRecorder()
+			receiver.ServeHTTP(rec, httptest.NewRequest("POST", "/webhook", strings.NewReader(payload)))
+			if rec.Code != http.StatusAccepted {
+				t.Fatalf("Want %d but got %d\n", http.StatusAccepted, rec.Code)
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if reconciled["PROJ/slug"] != 1 || reconciled["PROJ/other"] != 1 {
+		t.Fatalf("Want one reconciliation of each repository but got %+v\n", reconciled)
+	}
+}